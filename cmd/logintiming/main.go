@@ -0,0 +1,118 @@
+// Command logintiming checks that Login takes the same time for an unknown email
+// and for a known email with a wrong password
+//
+// It alternates both kinds of requests against a running server and runs Welch's t-test
+// on the latencies. Disable the Login rate limit on the target server, otherwise the
+// requests are rejected before reaching the credential check.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	ssov1 "github.com/Aim4ikqwe/ssoprotos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:44044", "address of the gRPC server")
+	appID := flag.Int64("app", 1, "app_id used for logins")
+	email := flag.String("email", "", "email of an existing user")
+	samples := flag.Int("n", 200, "number of samples of each kind")
+	threshold := flag.Float64("t", 3.0, "maximum allowed absolute t statistic")
+	flag.Parse()
+
+	if *email == "" {
+		fmt.Fprintln(os.Stderr, "-email of an existing user is required")
+		os.Exit(2)
+	}
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to connect:", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	client := ssov1.NewSSOClient(conn)
+
+	measure := func(email string) time.Duration {
+		start := time.Now()
+		_, _ = client.Login(context.Background(), &ssov1.LoginRequest{
+			Email:    email,
+			Password: "definitely-not-the-password",
+			AppId:    *appID,
+		})
+		return time.Since(start)
+	}
+
+	// Warm up connections and caches before measuring
+	for i := 0; i < 10; i++ {
+		measure(*email)
+	}
+
+	known := make([]float64, 0, *samples)
+	unknown := make([]float64, 0, *samples)
+	for i := 0; i < *samples; i++ {
+		unknownEmail := fmt.Sprintf("timing-%d-%d@invalid.example", time.Now().UnixNano(), i)
+		// Alternate the order so drift affects both series equally
+		if i%2 == 0 {
+			known = append(known, seconds(measure(*email)))
+			unknown = append(unknown, seconds(measure(unknownEmail)))
+		} else {
+			unknown = append(unknown, seconds(measure(unknownEmail)))
+			known = append(known, seconds(measure(*email)))
+		}
+	}
+
+	t := welch(known, unknown)
+	fmt.Printf("known email:   mean %.2fms median %.2fms\n", mean(known)*1000, median(known)*1000)
+	fmt.Printf("unknown email: mean %.2fms median %.2fms\n", mean(unknown)*1000, median(unknown)*1000)
+	fmt.Printf("welch t = %.3f (threshold %.1f)\n", t, *threshold)
+	if math.Abs(t) > *threshold {
+		fmt.Println("FAIL: response time depends on whether the email exists")
+		os.Exit(1)
+	}
+	fmt.Println("OK: no significant timing difference")
+}
+
+func seconds(d time.Duration) float64 {
+	return d.Seconds()
+}
+
+func mean(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func variance(xs []float64) float64 {
+	m := mean(xs)
+	var sum float64
+	for _, x := range xs {
+		sum += (x - m) * (x - m)
+	}
+	return sum / float64(len(xs)-1)
+}
+
+func median(xs []float64) float64 {
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// welch returns Welch's t statistic for the difference of means of a and b
+func welch(a, b []float64) float64 {
+	se := math.Sqrt(variance(a)/float64(len(a)) + variance(b)/float64(len(b)))
+	if se == 0 {
+		return 0
+	}
+	return (mean(a) - mean(b)) / se
+}
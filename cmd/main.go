@@ -16,12 +16,18 @@ func main() {
 	log := initLogger(cfg)
 	fmt.Println(cfg.ConnectionString())
 	log.Info("app started")
-	application := app.New(log, cfg.Grpc.Port, cfg.ConnectionString(), cfg.TokenTTL)
+	application := app.New(log, cfg)
+	application.Retention.Start()
 	go func() {
 		if err := application.GRPCServer.Run(); err != nil {
 			log.Error("app.GRPCServer.Run: ", err)
 		}
 	}()
+	go func() {
+		if err := application.HTTPServer.Run(); err != nil {
+			log.Error("app.HTTPServer.Run: ", err)
+		}
+	}()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
@@ -29,7 +35,10 @@ func main() {
 	sign := <-stop
 	log.Info("stopping application", sign)
 
+	application.HTTPServer.Stop()
 	application.GRPCServer.Stop()
+	application.LogoutNotifier.Stop()
+	application.Retention.Stop()
 	log.Info("application stopped")
 }
 func initLogger(cfg *config.Config) *logrus.Logger {
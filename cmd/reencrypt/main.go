@@ -0,0 +1,56 @@
+// Command reencrypt seals the app secrets in the database with the current master key
+//
+// Run it after adding a new master key to [secrets] and making it current, or after enabling
+// encryption on a database with plaintext secrets. The previous master key must stay configured
+// until the command has finished, values it sealed cannot be read without it.
+//
+//	go run ./cmd/reencrypt -config config/config.toml
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"ssoq/internal/config"
+	"ssoq/internal/envelope"
+	"ssoq/internal/storage"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	timeout := flag.Duration("timeout", 10*time.Minute, "time limit of the re-encryption")
+	cfg := config.MustLoad()
+
+	log := logrus.New()
+	log.SetFormatter(&logrus.JSONFormatter{})
+	log.SetLevel(logrus.InfoLevel)
+
+	secrets, err := envelope.Load(cfg.Secrets.Current, cfg.Secrets.Keys, cfg.Secrets.File)
+	if err != nil {
+		fail(err)
+	}
+	if secrets.Current() == "" {
+		fail(fmt.Errorf("no current master key in [secrets], nothing to encrypt with"))
+	}
+	db, err := storage.NewDB(cfg.ConnectionString(), log, secrets)
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	updated, err := db.ReencryptAppSecrets(ctx)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("re-encrypted the secrets of %d apps with master key %q\n", updated, secrets.Current())
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "reencrypt:", err)
+	os.Exit(1)
+}
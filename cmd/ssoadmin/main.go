@@ -0,0 +1,250 @@
+// Command ssoadmin manages the apps of a running server through the sso.Admin gRPC service
+//
+// Usage:
+//
+//	ssoadmin [connection flags] apps <list|get|create|update|disable|enable|rotate-secret|set-attribute-schema> [flags]
+//
+// The caller authenticates with an admin access token (-token or SSO_ADMIN_TOKEN) or,
+// on a TLS server with client certificates, with -cert and -key listed in [admin.certUsers].
+// Secrets are printed only by create and rotate-secret, they cannot be retrieved later.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	authgrpc "ssoq/internal/server/grpc"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// stringsFlag collects the values of a flag that may be repeated
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:44044", "address of the gRPC server")
+	token := flag.String("token", os.Getenv("SSO_ADMIN_TOKEN"), "admin access token")
+	useTLS := flag.Bool("tls", false, "connect with TLS")
+	caFile := flag.String("ca", "", "PEM CA certificate of the server, system roots by default")
+	certFile := flag.String("cert", "", "PEM client certificate for mTLS")
+	keyFile := flag.String("key", "", "PEM key of the client certificate")
+	timeout := flag.Duration("timeout", 10*time.Second, "request timeout")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 || args[0] != "apps" {
+		usage()
+		os.Exit(2)
+	}
+
+	creds := insecure.NewCredentials()
+	if *useTLS || *certFile != "" {
+		cfg, err := clientTLS(*caFile, *certFile, *keyFile)
+		if err != nil {
+			fail(err)
+		}
+		creds = credentials.NewTLS(cfg)
+	}
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fail(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if *token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+	}
+	c := &client{conn: conn}
+
+	if err := c.apps(ctx, args[1], args[2:]); err != nil {
+		fail(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: ssoadmin [connection flags] apps <command> [flags]
+
+commands:
+  list                      list every app
+  get -id N                 show an app
+  create -name NAME ...     register an app and print its secret
+  update -id N ...          change the given settings of an app
+  disable -id N             disable an app
+  enable -id N              enable a disabled app
+  rotate-secret -id N       replace the secret of an app and print it,
+                            -grace 1h keeps the replaced secret valid for an hour, -grace 0 revokes it at once
+  set-attribute-schema -id N -schema FILE
+                            set the user attribute schema of an app from a JSON file,
+                            without -schema the schema is removed
+
+settings of create and update (-redirect-uri, -grant-type and -scope may be repeated):
+  -name, -owner, -redirect-uri, -grant-type, -scope, -backchannel-logout-uri, -frontchannel-logout-uri
+
+connection flags:`)
+	flag.PrintDefaults()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "ssoadmin:", err)
+	os.Exit(1)
+}
+
+// clientTLS returns the TLS configuration of the connection, with a client certificate when certFile is set
+func clientTLS(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+type client struct {
+	conn *grpc.ClientConn
+}
+
+// call invokes a method of the admin service with the JSON codec
+func (c *client) call(ctx context.Context, method string, req interface{}, res interface{}) error {
+	return c.conn.Invoke(ctx, "/"+authgrpc.AdminServiceName+"/"+method, req, res, grpc.CallContentSubtype("json"))
+}
+
+// apps runs an apps command and prints its result as JSON
+func (c *client) apps(ctx context.Context, command string, args []string) error {
+	fs := flag.NewFlagSet("apps "+command, flag.ExitOnError)
+	id := fs.Int64("id", 0, "app id")
+	name := fs.String("name", "", "app name")
+	owner := fs.String("owner", "", "owner of the app, e.g. a team or contact")
+	backchannel := fs.String("backchannel-logout-uri", "", "back-channel logout URI")
+	frontchannel := fs.String("frontchannel-logout-uri", "", "front-channel logout URI")
+	var redirectURIs, grantTypes, scopes stringsFlag
+	fs.Var(&redirectURIs, "redirect-uri", "allowed redirect URI")
+	fs.Var(&grantTypes, "grant-type", "allowed grant type, every grant is allowed without one")
+	fs.Var(&scopes, "scope", "scope the app may grant")
+	grace := fs.Duration("grace", 0, "how long rotate-secret keeps the replaced secret valid, the server default when not set")
+	schemaFile := fs.String("schema", "", "JSON file with the user attribute schema of the app")
+	fs.Parse(args)
+
+	var method string
+	var req, res interface{}
+	switch command {
+	case "list":
+		method, req, res = "ListApps", &authgrpc.ListAppsRequest{}, &authgrpc.ListAppsResponse{}
+	case "get":
+		method, req, res = "GetApp", &authgrpc.AppRequest{AppId: *id}, &authgrpc.AppResponse{}
+	case "create":
+		method, res = "CreateApp", &authgrpc.AppSecretResponse{}
+		req = &authgrpc.AppSettings{
+			Name:                  *name,
+			Owner:                 *owner,
+			Scopes:                scopes,
+			RedirectURIs:          redirectURIs,
+			GrantTypes:            grantTypes,
+			BackchannelLogoutURI:  *backchannel,
+			FrontchannelLogoutURI: *frontchannel,
+		}
+	case "update":
+		// UpdateApp replaces every setting, so flags that were not given keep the current values
+		var current authgrpc.AppResponse
+		if err := c.call(ctx, "GetApp", &authgrpc.AppRequest{AppId: *id}, &current); err != nil {
+			return err
+		}
+		update := &authgrpc.UpdateAppRequest{AppId: *id, AppSettings: authgrpc.AppSettings{
+			Name:                  current.App.Name,
+			Owner:                 current.App.Owner,
+			Scopes:                current.App.Scopes,
+			RedirectURIs:          current.App.RedirectURIs,
+			GrantTypes:            current.App.GrantTypes,
+			BackchannelLogoutURI:  current.App.BackchannelLogoutURI,
+			FrontchannelLogoutURI: current.App.FrontchannelLogoutURI,
+		}}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "name":
+				update.Name = *name
+			case "owner":
+				update.Owner = *owner
+			case "scope":
+				update.Scopes = scopes
+			case "redirect-uri":
+				update.RedirectURIs = redirectURIs
+			case "grant-type":
+				update.GrantTypes = grantTypes
+			case "backchannel-logout-uri":
+				update.BackchannelLogoutURI = *backchannel
+			case "frontchannel-logout-uri":
+				update.FrontchannelLogoutURI = *frontchannel
+			}
+		})
+		method, req, res = "UpdateApp", update, &authgrpc.AppResponse{}
+	case "disable":
+		method, req, res = "DisableApp", &authgrpc.AppRequest{AppId: *id}, &authgrpc.SuccessResponse{}
+	case "enable":
+		method, req, res = "EnableApp", &authgrpc.AppRequest{AppId: *id}, &authgrpc.SuccessResponse{}
+	case "rotate-secret":
+		rotate := &authgrpc.RotateAppSecretRequest{AppId: *id}
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "grace" {
+				seconds := int64(grace.Seconds())
+				rotate.GraceSeconds = &seconds
+			}
+		})
+		method, req, res = "RotateAppSecret", rotate, &authgrpc.AppSecretResponse{}
+	case "set-attribute-schema":
+		var schema []byte
+		if *schemaFile != "" {
+			data, err := os.ReadFile(*schemaFile)
+			if err != nil {
+				return err
+			}
+			if !json.Valid(data) {
+				return fmt.Errorf("%s is not valid JSON", *schemaFile)
+			}
+			schema = data
+		}
+		method, req, res = "SetAppAttributeSchema", &authgrpc.SetAppAttributeSchemaRequest{AppId: *id, Schema: schema}, &authgrpc.AppResponse{}
+	default:
+		return fmt.Errorf("unknown apps command %q", command)
+	}
+	if err := c.call(ctx, method, req, res); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
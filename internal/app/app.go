@@ -1,40 +1,140 @@
 package app
 
 import (
+	"crypto/tls"
 	grpcapp "ssoq/internal/app/grpc"
+	httpapp "ssoq/internal/app/http"
+	"ssoq/internal/config"
+	"ssoq/internal/envelope"
 	providerjwt "ssoq/internal/jwt"
+	"ssoq/internal/mail"
+	"ssoq/internal/pepper"
+	authhttp "ssoq/internal/server/http"
+	"ssoq/internal/services/account"
+	"ssoq/internal/services/admin"
 	"ssoq/internal/services/auth"
+	"ssoq/internal/services/logout"
+	"ssoq/internal/services/oauth"
+	"ssoq/internal/services/retention"
 	"ssoq/internal/storage"
-	"time"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-// App represents the main application that contains the gRPC server
+// App represents the main application that contains the gRPC and HTTP servers,
+// the notifier delivering logout tokens to apps and the worker releasing emails of deleted users in the background
 type App struct {
-	GRPCServer *grpcapp.App
+	GRPCServer     *grpcapp.App
+	HTTPServer     *httpapp.App
+	LogoutNotifier *logout.Notifier
+	Retention      *retention.Worker
 }
 
 // New creates a new instance of the application with the provided configuration
-// It initializes the database storage, authentication service, and gRPC server
-func New(log *logrus.Logger, grpcPort int, connectionString string, tokenTTL time.Duration) *App {
+// It initializes the database storage, authentication and OAuth services, and the gRPC and HTTP servers
+func New(log *logrus.Logger, cfg *config.Config) *App {
 	// Initialize JWT package logger
 	providerjwt.SetLogger(log)
 
-	storage, err := storage.NewDB(connectionString, log)
+	secrets, err := envelope.Load(cfg.Secrets.Current, cfg.Secrets.Keys, cfg.Secrets.File)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("failed to load secret master keys")
+	}
+	storage, err := storage.NewDB(cfg.ConnectionString(), log, secrets)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err,
 		}).Fatal("failed to create storage")
 	}
-	auth := auth.NewAuth(log, storage, storage, storage, storage, storage, tokenTTL)
-	grpcServer := grpcapp.New(log, auth, grpcPort)
+	passwordPepper, err := pepper.Load(cfg.Pepper.Current, cfg.Pepper.Keys, cfg.Pepper.File)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("failed to load password pepper")
+	}
+	lockout := auth.LockoutPolicy{
+		Threshold:   cfg.Lockout.Threshold,
+		BaseDelay:   cfg.Lockout.BaseDelay,
+		MaxDelay:    cfg.Lockout.MaxDelay,
+		Duration:    cfg.Lockout.Duration,
+		FailureTime: cfg.Lockout.FailureTime,
+	}
+	tenancy, err := auth.ParseTenancyMode(cfg.Tenancy)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("invalid tenancy mode")
+	}
+	adminPolicy := auth.AdminPolicy{
+		AppID:      cfg.Admin.AppId,
+		Permission: cfg.Admin.Permission,
+	}
+	signingKey, err := providerjwt.LoadSigningKey(cfg.OIDC.KeyFile)
+	if err != nil {
+		log.WithField("error", err).Fatal("failed to load OIDC signing key")
+	}
+	issuer := strings.TrimSuffix(cfg.OIDC.Issuer, "/")
+	logoutNotifier := logout.New(log, signingKey, issuer, cfg.Logout.Attempts, cfg.Logout.Timeout)
+
+	auth := auth.NewAuth(log, storage, storage, storage, storage, storage, storage, storage, storage, storage, storage, logoutNotifier, passwordPepper, lockout, tenancy, adminPolicy, cfg.TokenTTL)
+	admin := admin.New(log, storage, storage, storage, storage, storage, auth, storage, storage, auth, auth, storage, storage, storage, cfg.Admin.ImpersonationTTL, cfg.Admin.SecretGrace)
+	var mailer account.Mailer = mail.NewLog(log)
+	if cfg.Mail.Host != "" {
+		mailer = mail.NewSMTP(cfg.Mail.Host, cfg.Mail.Port, cfg.Mail.User, cfg.Mail.Pass, cfg.Mail.From, cfg.Mail.RequireTLS)
+	}
+	emailPolicy := account.EmailChangePolicy{
+		TTL:        cfg.Email.ChangeTTL,
+		ConfirmURL: cfg.Email.ConfirmURL,
+		Logout:     cfg.Email.LogoutOnChange,
+	}
+	account := account.New(log, storage, auth, storage, storage, storage, storage, auth, mailer, emailPolicy)
+	oauth := oauth.New(log, auth, storage, storage, storage, storage, storage, storage, storage, signingKey, issuer,
+		cfg.OAuth.CodeTTL, cfg.OAuth.DeviceCodeTTL, cfg.OAuth.DevicePollInterval, cfg.TokenTTL)
+
+	var limiter grpcapp.Limiter
+	switch cfg.RateLimit.Backend {
+	case "postgres":
+		limiter = storage
+	case "memory", "":
+		limiter = grpcapp.NewMemoryLimiter()
+	default:
+		log.WithField("backend", cfg.RateLimit.Backend).Fatal("unknown rate limit backend")
+	}
+	// The gRPC methods and the HTTP sign in forms share the rules, each looks up its own name
+	rateLimits := make(map[string]grpcapp.RateLimitRule, len(cfg.RateLimit.Rules))
+	formRateLimits := make(map[string]authhttp.RateLimitRule, len(cfg.RateLimit.Rules))
+	for method, rule := range cfg.RateLimit.Rules {
+		rateLimits[method] = grpcapp.RateLimitRule{
+			IPRate:   rule.IPRate,
+			IPBurst:  rule.IPBurst,
+			AppRate:  rule.AppRate,
+			AppBurst: rule.AppBurst,
+		}
+		formRateLimits[method] = authhttp.RateLimitRule(rateLimits[method])
+	}
+	var tlsConfig *tls.Config
+	if cfg.Grpc.CertFile != "" {
+		tlsConfig, err = grpcapp.LoadTLSConfig(cfg.Grpc.CertFile, cfg.Grpc.KeyFile, cfg.Grpc.ClientCAFile)
+		if err != nil {
+			log.WithField("error", err).Fatal("failed to load gRPC TLS certificate")
+		}
+	}
+	grpcServer := grpcapp.New(log, auth, admin, auth, cfg.Admin.CertUsers, oauth, account, auth, cfg.Grpc.Port, tlsConfig, limiter, rateLimits)
+	httpServer := httpapp.New(log, oauth, limiter, formRateLimits, cfg.Http.Port, cfg.Http.Timeout)
+	retentionWorker := retention.New(log, storage, cfg.Retention.DeletedUsers, cfg.Retention.Interval)
 
 	log.WithFields(logrus.Fields{
-		"port": grpcPort,
+		"grpc_port": cfg.Grpc.Port,
+		"http_port": cfg.Http.Port,
 	}).Info("application initialized successfully")
 
 	return &App{
-		GRPCServer: grpcServer,
+		GRPCServer:     grpcServer,
+		HTTPServer:     httpServer,
+		LogoutNotifier: logoutNotifier,
+		Retention:      retentionWorker,
 	}
 }
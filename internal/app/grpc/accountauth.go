@@ -0,0 +1,44 @@
+package grpcapp
+
+import (
+	"context"
+	authgrpc "ssoq/internal/server/grpc"
+	"ssoq/internal/services/auth"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccountAuthenticator verifies the access token of a signed in user
+type AccountAuthenticator interface {
+	VerifyBearerToken(ctx context.Context, token string) (*auth.Principal, error)
+}
+
+// AccountAuthInterceptor returns a unary interceptor that requires a user access token
+// in the "authorization: Bearer <token>" metadata for every method of the account service
+func AccountAuthInterceptor(log *logrus.Logger, authenticator AccountAuthenticator) grpc.UnaryServerInterceptor {
+	prefix := "/" + authgrpc.AccountServiceName + "/"
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, prefix) {
+			return handler(ctx, req)
+		}
+
+		token := bearerToken(ctx)
+		if token == "" {
+			return nil, status.Error(codes.Unauthenticated, "access token is required")
+		}
+		principal, err := authenticator.VerifyBearerToken(ctx, token)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"method": info.FullMethod,
+				"peer":   peerIP(ctx),
+				"error":  err,
+			}).Warn("account call rejected")
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		return handler(authgrpc.WithPrincipal(ctx, principal), req)
+	}
+}
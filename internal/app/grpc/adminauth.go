@@ -0,0 +1,92 @@
+package grpcapp
+
+import (
+	"context"
+	"errors"
+	authgrpc "ssoq/internal/server/grpc"
+	"ssoq/internal/services/auth"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AdminAuthorizer verifies that an access token, or the user a client certificate maps to, belongs to an administrator
+type AdminAuthorizer interface {
+	AuthorizeAdmin(ctx context.Context, token string) (*auth.Principal, error)
+	AuthorizeAdminUser(ctx context.Context, user_id int64) error
+}
+
+// AdminAuthInterceptor returns a unary interceptor that requires an admin access token
+// in the "authorization: Bearer <token>" metadata for every method of the admin service
+// Without a token, a verified client certificate whose common name is in certAdmins authenticates the admin it maps to,
+// as long as that user is still active and holds the admin permission
+func AdminAuthInterceptor(log *logrus.Logger, authorizer AdminAuthorizer, certAdmins map[string]int64) grpc.UnaryServerInterceptor {
+	prefix := "/" + authgrpc.AdminServiceName + "/"
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, prefix) {
+			return handler(ctx, req)
+		}
+
+		token := bearerToken(ctx)
+		if token == "" {
+			name := clientCertName(ctx)
+			if admin_id, ok := certAdmins[name]; ok && name != "" {
+				if err := authorizer.AuthorizeAdminUser(ctx, admin_id); err != nil {
+					log.WithFields(logrus.Fields{
+						"method":   info.FullMethod,
+						"admin_id": admin_id,
+						"cert":     name,
+						"error":    err,
+					}).Warn("admin call rejected")
+					if errors.Is(err, auth.ErrPermissionDenied) {
+						return nil, status.Error(codes.PermissionDenied, "admin permission is required")
+					}
+					return nil, status.Error(codes.Internal, "failed to authorize admin")
+				}
+				log.WithFields(logrus.Fields{
+					"method":   info.FullMethod,
+					"admin_id": admin_id,
+					"cert":     name,
+				}).Info("admin call")
+				return handler(authgrpc.WithAdmin(ctx, admin_id), req)
+			}
+			return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+		}
+		principal, err := authorizer.AuthorizeAdmin(ctx, token)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"method": info.FullMethod,
+				"peer":   peerIP(ctx),
+				"error":  err,
+			}).Warn("admin call rejected")
+			if errors.Is(err, auth.ErrPermissionDenied) {
+				return nil, status.Error(codes.PermissionDenied, "admin permission is required")
+			}
+			return nil, status.Error(codes.Unauthenticated, "invalid admin access token")
+		}
+
+		log.WithFields(logrus.Fields{
+			"method":   info.FullMethod,
+			"admin_id": principal.UserID,
+		}).Info("admin call")
+		return handler(authgrpc.WithAdmin(ctx, principal.UserID), req)
+	}
+}
+
+// bearerToken returns the token of the "authorization: Bearer <token>" metadata
+func bearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
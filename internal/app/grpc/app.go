@@ -1,12 +1,14 @@
 package grpcapp
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	authgrpc "ssoq/internal/server/grpc"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // App represents the gRPC application server
@@ -17,12 +19,33 @@ type App struct {
 }
 
 // New creates a new instance of the gRPC application with the provided logger, authentication service and port
-func New(log *logrus.Logger, auth authgrpc.Auth, port int) *App {
-	gRPCServer := grpc.NewServer()
+// Machine clients obtain tokens from oauth through the token service
+// The admin service is only served to callers accepted by adminAuthorizer or presenting a client certificate listed in adminCerts
+// The account service is served to users whose access token is verified by accountAuthenticator
+// Methods listed in rateLimits are limited per peer IP and per app_id using limiter
+// A nil tlsConfig serves plaintext gRPC
+func New(log *logrus.Logger, auth authgrpc.Auth, admin authgrpc.Admin, adminAuthorizer AdminAuthorizer, adminCerts map[string]int64, oauth authgrpc.TokenIssuer, account authgrpc.Account, accountAuthenticator AccountAuthenticator, port int, tlsConfig *tls.Config, limiter Limiter, rateLimits map[string]RateLimitRule) *App {
+	interceptors := []grpc.UnaryServerInterceptor{
+		ClientInfoInterceptor(),
+		AdminAuthInterceptor(log, adminAuthorizer, adminCerts),
+		AccountAuthInterceptor(log, accountAuthenticator),
+	}
+	if len(rateLimits) > 0 {
+		interceptors = append(interceptors, RateLimitInterceptor(log, limiter, rateLimits))
+	}
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptors...)}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	gRPCServer := grpc.NewServer(opts...)
 	authgrpc.Register(gRPCServer, auth)
+	authgrpc.RegisterAdmin(gRPCServer, admin)
+	authgrpc.RegisterToken(gRPCServer, oauth)
+	authgrpc.RegisterAccount(gRPCServer, account)
 	
 	log.WithFields(logrus.Fields{
 		"port": port,
+		"tls":  tlsConfig != nil,
 	}).Info("gRPC server initialized")
 	
 	return &App{
@@ -0,0 +1,27 @@
+package grpcapp
+
+import (
+	"context"
+	"ssoq/internal/services/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ClientInfoInterceptor returns a unary interceptor that records the user agent and peer IP of every call,
+// sessions opened by the call keep them for the user's session list
+// Apps signing users in from their backend can pass the end user's browser in the "x-user-agent" metadata
+func ClientInfoInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		client := auth.ClientInfo{IP: peerIP(ctx)}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, key := range []string{"x-user-agent", "user-agent"} {
+				if v := md.Get(key); len(v) > 0 {
+					client.UserAgent = v[0]
+					break
+				}
+			}
+		}
+		return handler(auth.WithClientInfo(ctx, client), req)
+	}
+}
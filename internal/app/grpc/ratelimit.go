@@ -0,0 +1,161 @@
+package grpcapp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RateLimitRule limits a single gRPC method by peer IP and by app_id
+// Rates are tokens per second, a zero rate disables the corresponding limit
+type RateLimitRule struct {
+	IPRate   float64
+	IPBurst  int
+	AppRate  float64
+	AppBurst int
+}
+
+// Limiter takes tokens from token buckets identified by key
+type Limiter interface {
+	TakeRateToken(ctx context.Context, key string, rate float64, burst int) (bool, error)
+}
+
+// MemoryLimiter is a Limiter that keeps token buckets in process memory
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	rate      float64
+	burst     int
+	updatedAt time.Time
+}
+
+// refill adds the tokens accumulated since the last update
+func (b *bucket) refill(now time.Time) {
+	b.tokens = min(float64(b.burst), b.tokens+now.Sub(b.updatedAt).Seconds()*b.rate)
+	b.updatedAt = now
+}
+
+// sweepInterval is how often buckets that have refilled completely are dropped
+const sweepInterval = time.Minute
+
+// NewMemoryLimiter creates a new in-memory Limiter
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// TakeRateToken takes one token from the bucket identified by key, it returns false when the bucket is empty
+func (l *MemoryLimiter) TakeRateToken(_ context.Context, key string, rate float64, burst int) (bool, error) {
+	return l.take(key, rate, burst, time.Now()), nil
+}
+
+// take takes one token from the bucket identified by key at now
+func (l *MemoryLimiter) take(key string, rate float64, burst int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.rate, b.burst = rate, burst
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops buckets that have refilled completely, a new bucket starts full anyway
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// appIDGetter is implemented by every request message that carries an app_id
+type appIDGetter interface {
+	GetAppId() int64
+}
+
+// RateLimitInterceptor returns a unary interceptor that applies rules keyed by the short method name
+// Requests over the limit are rejected with codes.ResourceExhausted, limiter failures let the request through
+func RateLimitInterceptor(log *logrus.Logger, limiter Limiter, rules map[string]RateLimitRule) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method := path.Base(info.FullMethod)
+		rule, ok := rules[method]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		if rule.IPRate > 0 {
+			if ip := peerIP(ctx); ip != "" {
+				key := fmt.Sprintf("ip:%s:%s", method, ip)
+				if !allow(ctx, log, limiter, key, rule.IPRate, rule.IPBurst) {
+					return nil, status.Error(codes.ResourceExhausted, "too many requests from this address")
+				}
+			}
+		}
+		if rule.AppRate > 0 {
+			if r, ok := req.(appIDGetter); ok && r.GetAppId() != 0 {
+				key := fmt.Sprintf("app:%s:%d", method, r.GetAppId())
+				if !allow(ctx, log, limiter, key, rule.AppRate, rule.AppBurst) {
+					return nil, status.Error(codes.ResourceExhausted, "too many requests for this app")
+				}
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// allow takes a token for key and fails open when the limiter itself fails
+func allow(ctx context.Context, log *logrus.Logger, limiter Limiter, key string, rate float64, burst int) bool {
+	ok, err := limiter.TakeRateToken(ctx, key, rate, burst)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"key":   key,
+			"error": err,
+		}).Error("rate limiter failed, letting request through")
+		return true
+	}
+	if !ok {
+		log.WithField("key", key).Warn("request rejected by rate limiter")
+	}
+	return ok
+}
+
+// peerIP returns the IP address of the calling peer without the port
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,109 @@
+package grpcapp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterTake(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	type take struct {
+		after time.Duration
+		want  bool
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		takes []take
+	}{
+		{
+			name:  "burst then empty",
+			rate:  1,
+			burst: 3,
+			takes: []take{{0, true}, {0, true}, {0, true}, {0, false}},
+		},
+		{
+			name:  "refills at rate",
+			rate:  1,
+			burst: 1,
+			takes: []take{{0, true}, {500 * time.Millisecond, false}, {time.Second, true}, {time.Second, false}},
+		},
+		{
+			name:  "slow rate",
+			rate:  0.5,
+			burst: 1,
+			takes: []take{{0, true}, {time.Second, false}, {2 * time.Second, true}},
+		},
+		{
+			name:  "refill capped at burst",
+			rate:  10,
+			burst: 2,
+			takes: []take{{0, true}, {0, true}, {time.Hour, true}, {time.Hour, true}, {time.Hour, false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewMemoryLimiter()
+			l.lastSweep = start
+			now := start
+			for i, tk := range tt.takes {
+				now = start.Add(tk.after)
+				if got := l.take("ip:Login:10.0.0.1", tt.rate, tt.burst, now); got != tk.want {
+					t.Fatalf("take #%d at +%v = %v, want %v", i, tk.after, got, tk.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterKeysAreIndependent(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := NewMemoryLimiter()
+	l.lastSweep = now
+
+	if !l.take("ip:Login:10.0.0.1", 1, 1, now) {
+		t.Fatal("first take of 10.0.0.1 = false, want true")
+	}
+	if l.take("ip:Login:10.0.0.1", 1, 1, now) {
+		t.Fatal("second take of 10.0.0.1 = true, want false")
+	}
+	if !l.take("ip:Login:10.0.0.2", 1, 1, now) {
+		t.Fatal("first take of 10.0.0.2 = false, want true")
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    int
+		takes    int
+		after    time.Duration
+		wantKept bool
+	}{
+		{name: "refilled bucket dropped", rate: 1, burst: 5, takes: 5, after: sweepInterval + time.Second, wantKept: false},
+		{name: "draining bucket kept", rate: 0.01, burst: 5, takes: 5, after: sweepInterval + time.Second, wantKept: true},
+		{name: "no sweep before interval", rate: 1, burst: 5, takes: 5, after: sweepInterval, wantKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewMemoryLimiter()
+			l.lastSweep = start
+			for range tt.takes {
+				l.take("app:Login:1", tt.rate, tt.burst, start)
+			}
+
+			// Any take runs the sweep once the interval has passed
+			l.take("ip:Login:10.0.0.1", 1, 1, start.Add(tt.after))
+			if _, kept := l.buckets["app:Login:1"]; kept != tt.wantKept {
+				t.Errorf("bucket kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	authhttp "ssoq/internal/server/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownTimeout bounds how long Stop waits for in-flight requests
+const shutdownTimeout = 5 * time.Second
+
+// App represents the HTTP application server serving the browser facing OAuth 2.0 endpoints
+type App struct {
+	log        *logrus.Logger
+	httpServer *http.Server
+	port       int
+}
+
+// New creates a new instance of the HTTP application with the provided logger, OAuth service and port
+// limiter and rateLimits limit the sign in forms like the gRPC methods
+func New(log *logrus.Logger, oauth authhttp.OAuth, limiter authhttp.Limiter, rateLimits map[string]authhttp.RateLimitRule, port int, timeout time.Duration) *App {
+	mux := http.NewServeMux()
+	authhttp.Register(mux, log, oauth, limiter, rateLimits)
+
+	log.WithFields(logrus.Fields{
+		"port": port,
+	}).Info("HTTP server initialized")
+
+	return &App{
+		log: log,
+		httpServer: &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           mux,
+			ReadHeaderTimeout: timeout,
+			ReadTimeout:       timeout,
+			WriteTimeout:      timeout,
+		},
+		port: port,
+	}
+}
+
+// Run starts the HTTP server on the configured port
+// It serves until Stop is called or an error occurs
+func (a *App) Run() error {
+	a.log.WithFields(logrus.Fields{
+		"port": a.port,
+	}).Info("HTTP server listening")
+
+	if err := a.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		a.log.WithFields(logrus.Fields{
+			"port":  a.port,
+			"error": err,
+		}).Error("HTTP server failed")
+		return fmt.Errorf("failed to serve http: %v", err)
+	}
+	return nil
+}
+
+// Stop gracefully stops the HTTP server
+func (a *App) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := a.httpServer.Shutdown(ctx); err != nil {
+		a.log.WithField("error", err).Error("failed to stop HTTP server gracefully")
+		return
+	}
+	a.log.Info("HTTP server stopped")
+}
+
+// MustRun starts the HTTP server and logs a fatal error if it fails
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("failed to run HTTP server")
+	}
+}
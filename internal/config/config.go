@@ -10,15 +10,60 @@ import (
 )
 
 type Config struct {
-	Env      string        `toml:"env" env-default:"local"`
-	TokenTTL time.Duration `toml:"tokenTTL" env-required:"true"`
-	Grpc     GrpcConfig    `toml:"grpc"`
-	Db       DbConfig      `toml:"db"`
+	Env       string          `toml:"env" env-default:"local"`
+	TokenTTL  time.Duration   `toml:"tokenTTL" env-required:"true"`
+	Tenancy   string          `toml:"tenancy" env-default:"global"`
+	Grpc      GrpcConfig      `toml:"grpc"`
+	Http      HttpConfig      `toml:"http"`
+	OAuth     OAuthConfig     `toml:"oauth"`
+	OIDC      OIDCConfig      `toml:"oidc"`
+	Logout    LogoutConfig    `toml:"logout"`
+	Db        DbConfig        `toml:"db"`
+	Pepper    PepperConfig    `toml:"pepper"`
+	Secrets   SecretsConfig   `toml:"secrets"`
+	Lockout   LockoutConfig   `toml:"lockout"`
+	RateLimit RateLimitConfig `toml:"ratelimit"`
+	Retention RetentionConfig `toml:"retention"`
+	Mail      MailConfig      `toml:"mail"`
+	Email     EmailConfig     `toml:"email"`
+	Admin     AdminConfig     `toml:"admin"`
 }
 
+// GrpcConfig describes the gRPC server
+// CertFile and KeyFile enable TLS, ClientCAFile additionally verifies client certificates used by AdminConfig.CertUsers
 type GrpcConfig struct {
-	Port    int           `toml:"port" env-required:"true"`
-	Timeout time.Duration `toml:"timeout" env-required:"true"`
+	Port         int           `toml:"port" env-required:"true"`
+	Timeout      time.Duration `toml:"timeout" env-required:"true"`
+	CertFile     string        `toml:"certFile"`
+	KeyFile      string        `toml:"keyFile"`
+	ClientCAFile string        `toml:"clientCAFile"`
+}
+
+type HttpConfig struct {
+	Port    int           `toml:"port" env-default:"8080"`
+	Timeout time.Duration `toml:"timeout" env-default:"10s"`
+}
+
+// OAuthConfig describes the OAuth 2.0 authorization server
+// DeviceCodeTTL and DevicePollInterval configure the device authorization grant
+type OAuthConfig struct {
+	CodeTTL            time.Duration `toml:"codeTTL" env-default:"1m"`
+	DeviceCodeTTL      time.Duration `toml:"deviceCodeTTL" env-default:"10m"`
+	DevicePollInterval time.Duration `toml:"devicePollInterval" env-default:"5s"`
+}
+
+// OIDCConfig describes the OpenID Connect provider
+// Issuer is the public base URL of the HTTP server, KeyFile is a PEM RSA key signing ID tokens
+type OIDCConfig struct {
+	Issuer  string `toml:"issuer" env-default:"http://localhost:8080"`
+	KeyFile string `toml:"keyFile"`
+}
+
+// LogoutConfig describes the delivery of back-channel logout tokens to apps
+// A delivery is attempted up to Attempts times with exponential backoff, each request is bounded by Timeout
+type LogoutConfig struct {
+	Attempts int           `toml:"attempts" env-default:"5"`
+	Timeout  time.Duration `toml:"timeout" env-default:"5s"`
 }
 
 type DbConfig struct {
@@ -30,6 +75,106 @@ type DbConfig struct {
 	Sslmode string `toml:"sslmode" env-default:"disable"`
 }
 
+// PepperConfig describes the server-side password pepper keys
+// Current is the key version used for new hashes, 0 disables the pepper
+type PepperConfig struct {
+	Current int               `toml:"current" env-default:"0"`
+	Keys    map[string]string `toml:"keys"`
+	File    string            `toml:"file"`
+}
+
+// SecretsConfig describes the master keys encrypting app secrets at rest
+// Keys are base64 encoded 32 byte AES keys indexed by id, Current seals new values, an empty Current disables encryption
+type SecretsConfig struct {
+	Current string            `toml:"current"`
+	Keys    map[string]string `toml:"keys"`
+	File    string            `toml:"file"`
+}
+
+// LockoutConfig describes how failed logins slow down and lock an account
+// Threshold 0 disables locking, BaseDelay 0 disables progressive delays
+// FailureTime is the minimum duration of a failed login, it must exceed a bcrypt comparison plus a database write
+type LockoutConfig struct {
+	Threshold   int           `toml:"threshold" env-default:"5"`
+	BaseDelay   time.Duration `toml:"baseDelay" env-default:"1s"`
+	MaxDelay    time.Duration `toml:"maxDelay" env-default:"30s"`
+	Duration    time.Duration `toml:"duration" env-default:"15m"`
+	FailureTime time.Duration `toml:"failureTime" env-default:"300ms"`
+}
+
+// RateLimitConfig describes token bucket limits of gRPC methods and of the HTTP sign in forms
+// Backend is either "memory" (per instance) or "postgres" (shared between instances)
+type RateLimitConfig struct {
+	Backend string                   `toml:"backend" env-default:"memory"`
+	Rules   map[string]RateLimitRule `toml:"rules"`
+}
+
+// RateLimitRule limits a single gRPC method or HTTP sign in form by peer IP and by app_id
+// Rates are tokens per second, a zero rate disables the corresponding limit
+type RateLimitRule struct {
+	IPRate   float64 `toml:"ipRate"`
+	IPBurst  int     `toml:"ipBurst"`
+	AppRate  float64 `toml:"appRate"`
+	AppBurst int     `toml:"appBurst"`
+}
+
+// validate rejects rules whose bucket could never hold a token, they would reject every request
+func (c RateLimitConfig) validate() error {
+	for method, rule := range c.Rules {
+		if rule.IPRate < 0 || rule.AppRate < 0 {
+			return fmt.Errorf("ratelimit rule %s: rates must not be negative", method)
+		}
+		if rule.IPRate > 0 && rule.IPBurst < 1 {
+			return fmt.Errorf("ratelimit rule %s: ipBurst must be at least 1 when ipRate is set", method)
+		}
+		if rule.AppRate > 0 && rule.AppBurst < 1 {
+			return fmt.Errorf("ratelimit rule %s: appBurst must be at least 1 when appRate is set", method)
+		}
+	}
+	return nil
+}
+
+// RetentionConfig describes how long soft-deleted users keep their email
+// DeletedUsers is the retention period, Interval is how often expired emails are released
+type RetentionConfig struct {
+	DeletedUsers time.Duration `toml:"deletedUsers" env-default:"720h"`
+	Interval     time.Duration `toml:"interval" env-default:"1h"`
+}
+
+// MailConfig describes the SMTP server emails to users are sent through
+// An empty Host logs emails instead of sending them, which is only meant for development
+// Port 465 uses implicit TLS, other ports STARTTLS; RequireTLS refuses to send without TLS
+type MailConfig struct {
+	Host       string `toml:"host"`
+	Port       int    `toml:"port" env-default:"587"`
+	User       string `toml:"user"`
+	Pass       string `toml:"pass"`
+	From       string `toml:"from" env-default:"sso@localhost"`
+	RequireTLS bool   `toml:"requireTLS" env-default:"true"`
+}
+
+// EmailConfig describes how users change their email
+// ChangeTTL is how long a confirmation code is valid, ConfirmURL is the client page the code is linked to
+// LogoutOnChange signs the user out of every session once a change is confirmed
+type EmailConfig struct {
+	ChangeTTL      time.Duration `toml:"changeTTL" env-default:"24h"`
+	ConfirmURL     string        `toml:"confirmURL"`
+	LogoutOnChange bool          `toml:"logoutOnChange"`
+}
+
+// AdminConfig describes who may call the admin gRPC service
+// Admins are users of AppId whose access token carries Permission, AppId 0 disables the service
+// ImpersonationTTL is the lifetime of tokens administrators obtain to act as a user
+// SecretGrace is how long a rotated app secret stays valid by default
+// CertUsers maps common names of client certificates to the admin user ids they authenticate as
+type AdminConfig struct {
+	AppId            int64            `toml:"appId" env-default:"0"`
+	Permission       string           `toml:"permission" env-default:"sso:admin"`
+	ImpersonationTTL time.Duration    `toml:"impersonationTTL" env-default:"15m"`
+	SecretGrace      time.Duration    `toml:"secretGrace" env-default:"24h"`
+	CertUsers        map[string]int64 `toml:"certUsers"`
+}
+
 func fetchConfig() string {
 	var res string
 	flag.StringVar(&res, "config", "", "path to config file")
@@ -54,6 +199,9 @@ func MustLoad() *Config {
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
 		panic("cannot read config: " + err.Error())
 	}
+	if err := cfg.RateLimit.validate(); err != nil {
+		panic("invalid config: " + err.Error())
+	}
 	return &cfg
 
 }
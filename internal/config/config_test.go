@@ -0,0 +1,28 @@
+package config
+
+import "testing"
+
+func TestRateLimitConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   map[string]RateLimitRule
+		wantErr bool
+	}{
+		{name: "no rules", rules: nil},
+		{name: "ip and app limits", rules: map[string]RateLimitRule{"Login": {IPRate: 1, IPBurst: 5, AppRate: 50, AppBurst: 100}}},
+		{name: "app limit disabled without burst", rules: map[string]RateLimitRule{"Login": {IPRate: 1, IPBurst: 5}}},
+		{name: "ip rate without burst", rules: map[string]RateLimitRule{"Login": {IPRate: 1}}, wantErr: true},
+		{name: "app rate without burst", rules: map[string]RateLimitRule{"Register": {AppRate: 10}}, wantErr: true},
+		{name: "negative ip rate", rules: map[string]RateLimitRule{"Refresh": {IPRate: -1, IPBurst: 5}}, wantErr: true},
+		{name: "negative app rate", rules: map[string]RateLimitRule{"Refresh": {AppRate: -1, AppBurst: 5}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RateLimitConfig{Rules: tt.rules}.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
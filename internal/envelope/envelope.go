@@ -0,0 +1,226 @@
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefix marks values sealed by Envelope, values without it are plaintext stored before encryption was enabled
+const prefix = "enc:v1:"
+
+var (
+	// ErrUnknownKey is returned when a value is sealed with a master key that is not loaded
+	ErrUnknownKey = errors.New("unknown master key id")
+	// ErrMalformed is returned when a sealed value cannot be parsed or fails authentication
+	ErrMalformed = errors.New("malformed sealed value")
+)
+
+// Envelope encrypts sensitive column values with AES-256-GCM
+// Every value is sealed with its own random data key, which is in turn sealed with a master key.
+// Master keys have ids, the id is stored with the value so older keys keep decrypting after a rotation
+type Envelope struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// New creates a new Envelope with the given 32 byte master keys, current is the id used for new values
+// An empty current disables encryption, new values are then stored as plaintext
+func New(current string, keys map[string][]byte) (*Envelope, error) {
+	e := &Envelope{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("envelope.New: invalid master key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("envelope.New: master key %q must be 32 bytes, got %d", id, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("envelope.New: %w", err)
+		}
+		e.keys[id] = aead
+	}
+	if _, ok := e.keys[current]; current != "" && !ok {
+		return nil, fmt.Errorf("envelope.New: current master key %q is not configured", current)
+	}
+	return e, nil
+}
+
+// Load builds an Envelope from base64 master keys set inline in the config and keys read from a file
+// Inline keys are indexed by id, the file contains one "id=key" pair per line
+func Load(current string, inline map[string]string, file string) (*Envelope, error) {
+	encoded := make(map[string]string, len(inline))
+	for id, key := range inline {
+		encoded[id] = key
+	}
+	if file != "" {
+		fileKeys, err := LoadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for id, key := range fileKeys {
+			encoded[id] = key
+		}
+	}
+	keys := make(map[string][]byte, len(encoded))
+	for id, key := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("envelope.Load: master key %q is not valid base64", id)
+		}
+		keys[id] = raw
+	}
+	return New(current, keys)
+}
+
+// LoadFile reads base64 master keys from a file with one "id=key" pair per line
+// Empty lines and lines starting with # are ignored
+func LoadFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("envelope.LoadFile: %w", err)
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, key, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("envelope.LoadFile: line %d: expected id=key", line)
+		}
+		keys[strings.TrimSpace(id)] = strings.TrimSpace(key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("envelope.LoadFile: %w", err)
+	}
+	return keys, nil
+}
+
+// Current returns the id of the master key sealing new values, "" when encryption is disabled
+func (e *Envelope) Current() string {
+	if e == nil {
+		return ""
+	}
+	return e.current
+}
+
+// Seal encrypts plaintext with a new data key sealed by the current master key
+// Empty values and values sealed while encryption is disabled are returned unchanged
+func (e *Envelope) Seal(plaintext string) (string, error) {
+	if plaintext == "" || e.Current() == "" {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("envelope.Seal: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("envelope.Seal: %w", err)
+	}
+	// The key id is bound as additional data, so a wrapped key cannot be moved under another id
+	wrapped, err := seal(e.keys[e.current], dataKey, []byte(e.current))
+	if err != nil {
+		return "", fmt.Errorf("envelope.Seal: %w", err)
+	}
+	sealed, err := seal(data, []byte(plaintext), nil)
+	if err != nil {
+		return "", fmt.Errorf("envelope.Seal: %w", err)
+	}
+	return prefix + e.current + ":" + encode(wrapped) + ":" + encode(sealed), nil
+}
+
+// Open decrypts a value returned by Seal, plaintext values stored before encryption was enabled are returned as is
+func (e *Envelope) Open(value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("envelope.Open: %w", ErrMalformed)
+	}
+	id := parts[0]
+	var master cipher.AEAD
+	if e != nil {
+		master = e.keys[id]
+	}
+	if master == nil {
+		return "", fmt.Errorf("envelope.Open: %w: %q", ErrUnknownKey, id)
+	}
+	wrapped, err := decode(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("envelope.Open: %w", ErrMalformed)
+	}
+	sealed, err := decode(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("envelope.Open: %w", ErrMalformed)
+	}
+	dataKey, err := open(master, wrapped, []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("envelope.Open: %w", ErrMalformed)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("envelope.Open: %w", ErrMalformed)
+	}
+	plaintext, err := open(data, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("envelope.Open: %w", ErrMalformed)
+	}
+	return string(plaintext), nil
+}
+
+// Stale reports whether value should be sealed again: it is plaintext or sealed with another master key than the current one
+// Nothing is stale while encryption is disabled
+func (e *Envelope) Stale(value string) bool {
+	if value == "" || e.Current() == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, prefix+e.current+":")
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open decrypts the output of seal
+func open(aead cipher.AEAD, sealed []byte, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,187 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKeys() map[string][]byte {
+	return map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}
+}
+
+func mustNew(t *testing.T, current string, keys map[string][]byte) *Envelope {
+	t.Helper()
+	e, err := New(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		keys    map[string][]byte
+		wantErr bool
+	}{
+		{name: "encryption disabled", current: "", keys: nil},
+		{name: "current key configured", current: "k2", keys: testKeys()},
+		{name: "current key missing", current: "k3", keys: testKeys(), wantErr: true},
+		{name: "short key", current: "k1", keys: map[string][]byte{"k1": make([]byte, 16)}, wantErr: true},
+		{name: "empty id", current: "", keys: map[string][]byte{"": make([]byte, 32)}, wantErr: true},
+		{name: "id with separator", current: "", keys: map[string][]byte{"a:b": make([]byte, 32)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.current, tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		envelope  *Envelope
+		plaintext string
+		// sealed is whether Seal is expected to encrypt the value
+		sealed bool
+	}{
+		{name: "secret", envelope: mustNew(t, "k1", testKeys()), plaintext: "app-secret", sealed: true},
+		{name: "unicode", envelope: mustNew(t, "k2", testKeys()), plaintext: "секрет приложения", sealed: true},
+		{name: "empty value", envelope: mustNew(t, "k1", testKeys()), plaintext: "", sealed: false},
+		{name: "encryption disabled", envelope: mustNew(t, "", testKeys()), plaintext: "app-secret", sealed: false},
+		{name: "nil envelope", envelope: nil, plaintext: "app-secret", sealed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := tt.envelope.Seal(tt.plaintext)
+			if err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+			if got := strings.HasPrefix(value, prefix); got != tt.sealed {
+				t.Fatalf("Seal() = %q, sealed = %v, want %v", value, got, tt.sealed)
+			}
+			if tt.sealed && strings.Contains(value, tt.plaintext) {
+				t.Errorf("Seal() = %q contains the plaintext", value)
+			}
+			got, err := tt.envelope.Open(value)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if got != tt.plaintext {
+				t.Errorf("Open() = %q, want %q", got, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestSealUsesFreshDataKeys(t *testing.T) {
+	e := mustNew(t, "k1", testKeys())
+	a, err := e.Seal("same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := e.Seal("same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("Seal() returned the same value twice")
+	}
+}
+
+func TestOpenAfterRotation(t *testing.T) {
+	old := mustNew(t, "k1", testKeys())
+	value, err := old.Seal("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := mustNew(t, "k2", testKeys())
+	if !rotated.Stale(value) {
+		t.Error("Stale() = false for a value sealed with the previous key")
+	}
+	got, err := rotated.Open(value)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if got != "app-secret" {
+		t.Errorf("Open() = %q, want %q", got, "app-secret")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	e := mustNew(t, "k1", testKeys())
+	value, err := e.Seal("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	flip := func(s string) string {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[len(raw)-1] ^= 1
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name     string
+		envelope *Envelope
+		value    string
+		wantErr  error
+	}{
+		{name: "unknown key", envelope: mustNew(t, "k2", map[string][]byte{"k2": testKeys()["k2"]}), value: value, wantErr: ErrUnknownKey},
+		{name: "nil envelope", envelope: nil, value: value, wantErr: ErrUnknownKey},
+		{name: "missing part", envelope: e, value: prefix + parts[0] + ":" + parts[1], wantErr: ErrMalformed},
+		{name: "bad base64", envelope: e, value: prefix + parts[0] + ":" + parts[1] + ":!!", wantErr: ErrMalformed},
+		{name: "tampered data key", envelope: e, value: prefix + parts[0] + ":" + flip(parts[1]) + ":" + parts[2], wantErr: ErrMalformed},
+		{name: "tampered ciphertext", envelope: e, value: prefix + parts[0] + ":" + parts[1] + ":" + flip(parts[2]), wantErr: ErrMalformed},
+		// The key id is bound to the wrapped data key, relabeling it fails authentication
+		{name: "moved under another key", envelope: e, value: prefix + "k2:" + parts[1] + ":" + parts[2], wantErr: ErrMalformed},
+		{name: "truncated", envelope: e, value: prefix + parts[0] + ":" + parts[1] + ":AAAA", wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.envelope.Open(tt.value); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStale(t *testing.T) {
+	e := mustNew(t, "k1", testKeys())
+	sealed, err := e.Seal("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		envelope *Envelope
+		value    string
+		want     bool
+	}{
+		{name: "sealed with current key", envelope: e, value: sealed, want: false},
+		{name: "plaintext", envelope: e, value: "app-secret", want: true},
+		{name: "empty", envelope: e, value: "", want: false},
+		{name: "encryption disabled", envelope: mustNew(t, "", testKeys()), value: "app-secret", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.envelope.Stale(tt.value); got != tt.want {
+				t.Errorf("Stale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package jwt
 import (
 	"fmt"
 	"ssoq/internal/model"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -17,9 +18,24 @@ func SetLogger(logger *logrus.Logger) {
 	log = logger
 }
 
+// Authorization carries the authorization data embedded in tokens
+// Scopes go to the access token, GrantScopes is the whole grant kept in the refresh token
+// ImpersonatorID marks delegated tokens an administrator obtained to act as the user
+// SessionID is the session a token pair belongs to, it goes to both tokens as sid
+// Claims are extra access token claims mapped from user attributes, they never override the standard claims
+type Authorization struct {
+	Roles          []string
+	Permissions    []string
+	Scopes         []string
+	GrantScopes    []string
+	ImpersonatorID int64
+	SessionID      int64
+	Claims         map[string]interface{}
+}
+
 // GenerateToken generates access and refresh tokens for a user and app
 // It creates JWT tokens with appropriate expiration times and purposes
-func GenerateToken(app *model.App, user *model.User, tokenTTL time.Duration) (string, string, error) {
+func GenerateToken(app *model.App, user *model.User, authz Authorization, tokenTTL time.Duration) (string, string, error) {
 	if app == nil {
 		log.Error("app is nil in GenerateToken")
 		return "", "", fmt.Errorf("app is nil")
@@ -28,21 +44,31 @@ func GenerateToken(app *model.App, user *model.User, tokenTTL time.Duration) (st
 		log.Error("user is nil in GenerateToken")
 		return "", "", fmt.Errorf("user is nil")
 	}
-	access_token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.Id,
-		"username": user.Username,
-		"email":    user.Email,
-		"app_id":   app.Id,
-		"exp":      time.Now().Add(tokenTTL).Unix(),
-		"purpose":  "access",
-	})
+	claims := jwt.MapClaims{
+		"user_id":       user.Id,
+		"username":      user.Username,
+		"email":         user.Email,
+		"app_id":        app.Id,
+		"exp":           time.Now().Add(tokenTTL).Unix(),
+		"purpose":       "access",
+		"roles":         nonNil(authz.Roles),
+		"permissions":   nonNil(authz.Permissions),
+		"scope":         strings.Join(authz.Scopes, " "),
+		"token_version": user.TokenVersion,
+		"sid":           authz.SessionID,
+	}
+	addClaims(claims, authz.Claims)
+	access_token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	refresh_token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.Id,
-		"username": user.Username,
-		"email":    user.Email,
-		"app_id":   app.Id,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
-		"purpose":  "refresh",
+		"user_id":       user.Id,
+		"username":      user.Username,
+		"email":         user.Email,
+		"app_id":        app.Id,
+		"exp":           time.Now().Add(24 * time.Hour).Unix(),
+		"purpose":       "refresh",
+		"scope":         strings.Join(authz.GrantScopes, " "),
+		"token_version": user.TokenVersion,
+		"sid":           authz.SessionID,
 	})
 	accessToken, err := access_token.SignedString([]byte(app.Secret))
 	if err != nil {
@@ -78,13 +104,121 @@ func GenerateToken(app *model.App, user *model.User, tokenTTL time.Duration) (st
 	return accessToken, refreshToken, nil
 }
 
+// GenerateDelegatedToken generates an access token of a user for an app obtained by token exchange
+// act identifies the party acting on behalf of the user, the token has no refresh token and expires at expiresAt
+func GenerateDelegatedToken(app *model.App, user *model.User, authz Authorization, act map[string]interface{}, expiresAt time.Time) (string, error) {
+	if app == nil || user == nil {
+		log.Error("app or user is nil in GenerateDelegatedToken")
+		return "", fmt.Errorf("app or user is nil")
+	}
+	claims := jwt.MapClaims{
+		"user_id":       user.Id,
+		"username":      user.Username,
+		"email":         user.Email,
+		"app_id":        app.Id,
+		"exp":           expiresAt.Unix(),
+		"purpose":       "access",
+		"roles":         nonNil(authz.Roles),
+		"permissions":   nonNil(authz.Permissions),
+		"scope":         strings.Join(authz.Scopes, " "),
+		"act":           act,
+		"token_version": user.TokenVersion,
+	}
+	if authz.ImpersonatorID != 0 {
+		claims["impersonator_id"] = authz.ImpersonatorID
+	}
+	addClaims(claims, authz.Claims)
+	access_token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	accessToken, err := access_token.SignedString([]byte(app.Secret))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"user_id": user.Id,
+			"app_id":  app.Id,
+			"error":   err,
+		}).Error("failed to sign delegated access token")
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{
+		"user_id": user.Id,
+		"app_id":  app.Id,
+		"purpose": "access",
+	}).Debug("delegated access token generated")
+	return accessToken, nil
+}
+
+// GenerateClientToken generates an access token for a machine client of an app
+// The token has the client as subject, carries no user claims and comes without a refresh token
+func GenerateClientToken(app *model.App, client *model.Client, scopes []string, tokenTTL time.Duration) (string, error) {
+	if app == nil || client == nil {
+		log.Error("app or client is nil in GenerateClientToken")
+		return "", fmt.Errorf("app or client is nil")
+	}
+	access_token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":       client.ClientId,
+		"client_id": client.ClientId,
+		"app_id":    app.Id,
+		"exp":       time.Now().Add(tokenTTL).Unix(),
+		"purpose":   "access",
+		"scope":     strings.Join(scopes, " "),
+	})
+	accessToken, err := access_token.SignedString([]byte(app.Secret))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"client_id": client.ClientId,
+			"app_id":    app.Id,
+			"error":     err,
+		}).Error("failed to sign client access token")
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{
+		"client_id": client.ClientId,
+		"app_id":    app.Id,
+		"purpose":   "access",
+	}).Debug("client access token generated")
+	return accessToken, nil
+}
+
 // ParseToken parses and validates a JWT token using the app's secret key
+// During the grace period after a secret rotation, tokens signed with the previous secret are accepted too
 func ParseToken(token string, app *model.App) (*jwt.Token, error) {
 	if app == nil {
 		log.Error("app is nil in ParseToken")
 		return nil, fmt.Errorf("app is nil")
 	}
 	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(app.Secret), nil
-	})
+		keys := jwt.VerificationKeySet{}
+		for _, secret := range AppSecrets(app, time.Now()) {
+			keys.Keys = append(keys.Keys, []byte(secret))
+		}
+		return keys, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+}
+
+// AppSecrets returns the secrets an app is authenticated with at now, the current secret first
+// The previous secret of a rotation is included until it expires
+func AppSecrets(app *model.App, now time.Time) []string {
+	secrets := []string{app.Secret}
+	if app.PreviousSecret != "" && now.Before(app.PreviousSecretExpiresAt) {
+		secrets = append(secrets, app.PreviousSecret)
+	}
+	return secrets
+}
+
+// addClaims copies extra claims into claims, keeping the value of claims that are already set
+func addClaims(claims jwt.MapClaims, extra map[string]interface{}) {
+	for name, value := range extra {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+}
+
+// nonNil returns an empty slice for nil so the claim is encoded as [] instead of null
+func nonNil(xs []string) []string {
+	if xs == nil {
+		return []string{}
+	}
+	return xs
 }
@@ -0,0 +1,94 @@
+package jwt
+
+import (
+	"slices"
+	"ssoq/internal/model"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAppSecrets(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		app  model.App
+		want []string
+	}{
+		{
+			name: "no rotation",
+			app:  model.App{Secret: "current"},
+			want: []string{"current"},
+		},
+		{
+			name: "previous secret inside grace period",
+			app:  model.App{Secret: "current", PreviousSecret: "previous", PreviousSecretExpiresAt: now.Add(time.Hour)},
+			want: []string{"current", "previous"},
+		},
+		{
+			name: "previous secret expired",
+			app:  model.App{Secret: "current", PreviousSecret: "previous", PreviousSecretExpiresAt: now.Add(-time.Second)},
+			want: []string{"current"},
+		},
+		{
+			name: "previous secret expires at now",
+			app:  model.App{Secret: "current", PreviousSecret: "previous", PreviousSecretExpiresAt: now},
+			want: []string{"current"},
+		},
+		{
+			// A rotation without grace stores no expiry, the previous secret is dropped at once
+			name: "zero expiry",
+			app:  model.App{Secret: "current", PreviousSecret: "previous"},
+			want: []string{"current"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AppSecrets(&tt.app, now); !slices.Equal(got, tt.want) {
+				t.Errorf("AppSecrets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTokenSecretRotation(t *testing.T) {
+	sign := func(method jwt.SigningMethod, secret string) string {
+		token, err := jwt.NewWithClaims(method, jwt.MapClaims{
+			"uid": 1,
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("SignedString() error = %v", err)
+		}
+		return token
+	}
+	inGrace := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		token   string
+		expires time.Time
+		wantErr bool
+	}{
+		{name: "current secret", token: sign(jwt.SigningMethodHS256, "current"), expires: inGrace},
+		{name: "previous secret inside grace period", token: sign(jwt.SigningMethodHS256, "previous"), expires: inGrace},
+		{name: "previous secret after grace period", token: sign(jwt.SigningMethodHS256, "previous"), expires: expired, wantErr: true},
+		{name: "previous secret with zero expiry", token: sign(jwt.SigningMethodHS256, "previous"), wantErr: true},
+		{name: "unknown secret", token: sign(jwt.SigningMethodHS256, "other"), expires: inGrace, wantErr: true},
+		{name: "other HMAC algorithm", token: sign(jwt.SigningMethodHS512, "current"), expires: inGrace, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &model.App{Id: 1, Secret: "current", PreviousSecret: "previous", PreviousSecretExpiresAt: tt.expires}
+			_, err := ParseToken(tt.token, app)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
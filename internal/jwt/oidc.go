@@ -0,0 +1,256 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// SigningKey is the RSA key that signs OpenID Connect ID tokens, published through the JWKS endpoint
+type SigningKey struct {
+	private *rsa.PrivateKey
+	keyID   string
+}
+
+// LoadSigningKey reads a PEM encoded RSA private key (PKCS#1 or PKCS#8)
+// An empty path generates an ephemeral key, ID tokens then do not survive a restart
+func LoadSigningKey(path string) (*SigningKey, error) {
+	if path == "" {
+		log.Warn("no OIDC signing key configured, generating an ephemeral one")
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("jwt.LoadSigningKey: %w", err)
+		}
+		return newSigningKey(private), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt.LoadSigningKey: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt.LoadSigningKey: no PEM block in %s", path)
+	}
+	if private, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return newSigningKey(private), nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("jwt.LoadSigningKey: %w", err)
+	}
+	private, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("jwt.LoadSigningKey: %s is not an RSA key", path)
+	}
+	return newSigningKey(private), nil
+}
+
+func newSigningKey(private *rsa.PrivateKey) *SigningKey {
+	return &SigningKey{private: private, keyID: thumbprint(&private.PublicKey)}
+}
+
+// thumbprint returns the RFC 7638 JWK thumbprint of an RSA public key, used as the key ID
+func thumbprint(public *rsa.PublicKey) string {
+	// Members in lexicographic order without whitespace as required by RFC 7638
+	canonical := fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, encodeExponent(public.E), encodeBigInt(public.N))
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func encodeExponent(e int) string {
+	return encodeBigInt(big.NewInt(int64(e)))
+}
+
+// JWKS returns the JSON Web Key Set publishing the public part of the key
+func (k *SigningKey) JWKS() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": k.keyID,
+			"n":   encodeBigInt(k.private.PublicKey.N),
+			"e":   encodeExponent(k.private.PublicKey.E),
+		}},
+	})
+}
+
+// IDToken holds the claims of an OpenID Connect ID token
+type IDToken struct {
+	Issuer      string
+	Subject     string
+	Audience    string
+	Nonce       string
+	AuthTime    time.Time
+	AccessToken string
+	// SessionID is the session opened with the ID token, it goes to the sid claim
+	SessionID int64
+	// Extra holds the scope dependent user claims such as email or preferred_username
+	Extra map[string]interface{}
+}
+
+// GenerateIDToken signs an OpenID Connect ID token valid for ttl with RS256
+func (k *SigningKey) GenerateIDToken(t *IDToken, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": t.Issuer,
+		"sub": t.Subject,
+		"aud": t.Audience,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+	if !t.AuthTime.IsZero() {
+		claims["auth_time"] = t.AuthTime.Unix()
+	}
+	if t.Nonce != "" {
+		claims["nonce"] = t.Nonce
+	}
+	if t.AccessToken != "" {
+		claims["at_hash"] = AccessTokenHash(t.AccessToken)
+	}
+	if t.SessionID != 0 {
+		claims["sid"] = strconv.FormatInt(t.SessionID, 10)
+	}
+	for name, value := range t.Extra {
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.keyID
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"sub":   t.Subject,
+			"aud":   t.Audience,
+			"error": err,
+		}).Error("failed to sign id token")
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{
+		"sub": t.Subject,
+		"aud": t.Audience,
+	}).Debug("id token generated")
+	return signed, nil
+}
+
+// AccessTokenHash returns the at_hash of an RS256 access token: the base64url left half of its SHA-256
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// BackchannelLogoutEvent is the member of the events claim identifying a logout token
+const BackchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutTokenTTL bounds how long a logout token is accepted by the app receiving it
+const logoutTokenTTL = 2 * time.Minute
+
+// GenerateLogoutToken signs an OpenID Connect back-channel logout token of the user subject for the app audience
+// sid names the single session that ended, it is empty when every session of the user ended
+func (k *SigningKey) GenerateLogoutToken(issuer string, subject string, sid string, audience string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":    issuer,
+		"sub":    subject,
+		"aud":    audience,
+		"iat":    now.Unix(),
+		"exp":    now.Add(logoutTokenTTL).Unix(),
+		"jti":    base64.RawURLEncoding.EncodeToString(jti),
+		"events": map[string]interface{}{BackchannelLogoutEvent: map[string]interface{}{}},
+	}
+	if sid != "" {
+		claims["sid"] = sid
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.keyID
+	token.Header["typ"] = "logout+jwt"
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"sub":   subject,
+			"aud":   audience,
+			"error": err,
+		}).Error("failed to sign logout token")
+		return "", err
+	}
+	return signed, nil
+}
+
+// ParseIDToken verifies the signature of an ID token issued with this key and returns its claims
+// Expiry is not checked: an expired ID token is still a valid id_token_hint of a logout request,
+// callers bound how old a hint may be through its iat claim
+func (k *SigningKey) ParseIDToken(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return &k.private.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// browserSessionType is the typ header of browser session tokens, so no other token of this key passes as one
+const browserSessionType = "session+jwt"
+
+// GenerateBrowserSession signs the token of the browser cookie recording that subject signed in at issuer
+func (k *SigningKey) GenerateBrowserSession(issuer string, subject string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": issuer,
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.keyID
+	token.Header["typ"] = browserSessionType
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"sub":   subject,
+			"error": err,
+		}).Error("failed to sign browser session")
+		return "", err
+	}
+	return signed, nil
+}
+
+// ParseBrowserSession verifies a browser session token of issuer and returns the subject signed in
+func (k *SigningKey) ParseBrowserSession(token string, issuer string) (string, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return &k.private.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(issuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	if parsed.Header["typ"] != browserSessionType {
+		return "", fmt.Errorf("token is not a browser session")
+	}
+	return claims.GetSubject()
+}
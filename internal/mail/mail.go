@@ -0,0 +1,165 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Message is a plain text email sent by the service
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// implicitTLSPort is the submission port where the connection is TLS from the start (RFC 8314)
+const implicitTLSPort = 465
+
+// SMTP sends messages through an SMTP server over TLS: implicit TLS on port 465,
+// otherwise STARTTLS when the server offers it
+type SMTP struct {
+	host        string
+	addr        string
+	user        string
+	pass        string
+	from        string
+	implicitTLS bool
+	requireTLS  bool
+}
+
+// NewSMTP creates a new SMTP sender, user and pass are only used when user is not empty
+// With requireTLS a message is never sent over a connection without TLS, e.g. after STARTTLS was stripped
+func NewSMTP(host string, port int, user string, pass string, from string, requireTLS bool) *SMTP {
+	return &SMTP{
+		host:        host,
+		addr:        net.JoinHostPort(host, strconv.Itoa(port)),
+		user:        user,
+		pass:        pass,
+		from:        from,
+		implicitTLS: port == implicitTLSPort,
+		requireTLS:  requireTLS,
+	}
+}
+
+// Send delivers msg, the whole SMTP exchange is bounded by the deadline of ctx
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("mail.Send: line break in a header")
+	}
+	tlsConfig := &tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}
+	var conn net.Conn
+	var err error
+	if m.implicitTLS {
+		d := tls.Dialer{Config: tlsConfig}
+		conn, err = d.DialContext(ctx, "tcp", m.addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("mail.Send: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mail.Send: %w", err)
+	}
+	defer c.Close()
+
+	if !m.implicitTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("mail.Send: %w", err)
+			}
+		}
+	}
+	// Confirmation codes must not travel in cleartext, whatever the server advertised
+	if _, ok := c.TLSConnectionState(); !ok && m.requireTLS {
+		return fmt.Errorf("mail.Send: TLS is required but %s did not negotiate it", m.host)
+	}
+	if m.user != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection to a remote host
+		if err := c.Auth(smtp.PlainAuth("", m.user, m.pass, m.host)); err != nil {
+			return fmt.Errorf("mail.Send: %w", err)
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return fmt.Errorf("mail.Send: %w", err)
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("mail.Send: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("mail.Send: %w", err)
+	}
+	data, err := m.format(msg)
+	if err != nil {
+		return fmt.Errorf("mail.Send: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("mail.Send: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mail.Send: %w", err)
+	}
+	return c.Quit()
+}
+
+// format renders msg with its headers, the body is UTF-8 encoded as quoted-printable
+func (m *SMTP) format(msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Log writes messages to the log instead of sending them, it is used when no SMTP server is configured
+// Bodies may contain confirmation codes, so they are only logged at debug level
+type Log struct {
+	log *logrus.Logger
+}
+
+// NewLog creates a new Log sender
+func NewLog(log *logrus.Logger) *Log {
+	return &Log{log: log}
+}
+
+// Send logs msg
+func (m *Log) Send(ctx context.Context, msg Message) error {
+	m.log.WithFields(logrus.Fields{
+		"to":      msg.To,
+		"subject": msg.Subject,
+	}).Warn("no SMTP server configured, email not sent")
+	m.log.WithFields(logrus.Fields{
+		"to":   msg.To,
+		"body": msg.Body,
+	}).Debug("email body")
+	return nil
+}
@@ -1,6 +1,24 @@
 package model
+
+import "time"
+
 type App struct {
 	Id     int64
 	Name   string
 	Secret string
-}
\ No newline at end of file
+	// PreviousSecret is still accepted after a rotation until PreviousSecretExpiresAt
+	PreviousSecret string
+	PreviousSecretExpiresAt time.Time
+	Scopes []string
+	RedirectURIs []string
+	BackchannelLogoutURI string
+	FrontchannelLogoutURI string
+	Owner string
+	// GrantTypes restricts the grants the app may use, empty allows every grant
+	GrantTypes []string
+	CreatedAt time.Time
+	// DisabledAt is set while an administrator has disabled the app
+	DisabledAt time.Time
+	// AttributeSchema is the JSON schema of the attributes of the app members, empty when the app has none
+	AttributeSchema []byte
+}
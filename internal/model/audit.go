@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// AuditEvent is an entry of the audit log
+// Actor identifies who acted, e.g. "client:svc_..." or "user:42", UserId is the user acted upon
+type AuditEvent struct {
+	Id        int64
+	Event     string
+	Actor     string
+	UserId    int64
+	AppId     int64
+	Details   map[string]interface{}
+	CreatedAt time.Time
+}
+
+// Impersonation is a record of an administrator obtaining a token to act as a user
+type Impersonation struct {
+	Id        int64
+	AdminId   int64
+	UserId    int64
+	AppId     int64
+	Reason    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// Client is a machine client of an app that obtains tokens with the client credentials grant
+// It authenticates either with a secret, stored as SecretHash, or with a JWT signed by the key PublicKey
+type Client struct {
+	Id         int64
+	ClientId   string
+	AppId      int64
+	Name       string
+	SecretHash string
+	PublicKey  string
+	Scopes     []string
+	CreatedAt  time.Time
+}
+
+// ExchangePolicy allows a machine client to exchange access tokens of users of SubjectAppId
+// for access tokens of AudienceAppId limited to Scopes
+type ExchangePolicy struct {
+	Id            int64
+	ClientId      string
+	SubjectAppId  int64
+	AudienceAppId int64
+	Scopes        []string
+}
@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// AuthorizationCode is an OAuth 2.0 authorization code, only the hash of the code is stored
+type AuthorizationCode struct {
+	CodeHash      string
+	AppId         int64
+	UserId        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	Nonce         string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+}
+
+// Device code statuses
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DeviceCode is a pending device authorization, only the hash of the device code is stored
+// The user approves or denies it on the verification page by entering UserCode
+type DeviceCode struct {
+	DeviceCodeHash string
+	UserCode       string
+	AppId          int64
+	Scopes         []string
+	Status         string
+	UserId         int64
+	ApprovedAt     time.Time
+	// PollInterval is the minimal number of seconds between two token requests of the device
+	PollInterval int
+	LastPolledAt time.Time
+	ExpiresAt    time.Time
+}
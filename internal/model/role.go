@@ -0,0 +1,18 @@
+package model
+
+// Role is a named set of permissions defined within an app
+type Role struct {
+	Id          int64
+	AppId       int64
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// Permission is a single permission defined within an app
+type Permission struct {
+	Id          int64
+	AppId       int64
+	Name        string
+	Description string
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Session is a signed in device of a user, its refresh token is rotated on every refresh
+type Session struct {
+	Id           int64
+	UserId       int64
+	AppId        int64
+	RefreshToken string
+	UserAgent    string
+	IP           string
+	CreatedAt    time.Time
+	LastUsedAt   time.Time
+}
@@ -1,8 +1,56 @@
 package model
+
+import "time"
+
 type User struct {
 	Id int64
 	Email string
 	Password []byte
+	PepperVersion int
 	Username string
 	AppId int64
+	TenantAppId int64
+	FailedAttempts int
+	LastFailedAt time.Time
+	LockedUntil time.Time
+	TokenVersion int
+	CreatedAt time.Time
+	// Status is one of the UserStatus constants, StatusReason is the reason given by the administrator who set it
+	Status string
+	StatusReason string
+	StatusChangedAt time.Time
+	// EmailReleasedAt is set once the email of a deleted user may be registered again
+	EmailReleasedAt time.Time
+	DisplayName string
+	Locale string
+	Timezone string
+	Metadata map[string]string
+	// UpdatedAt changes with every update of the user, UpdateProfile uses it as the version of the profile
+	UpdatedAt time.Time
+}
+
+// ProfileUpdate changes the profile of a user, nil fields keep their value and a non-nil Metadata replaces all metadata
+type ProfileUpdate struct {
+	Username *string
+	DisplayName *string
+	Locale *string
+	Timezone *string
+	Metadata map[string]string
+}
+
+// States of a user account, only active users may log in and use their tokens
+const (
+	UserStatusActive = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusLocked = "locked"
+	UserStatusPendingVerification = "pending_verification"
+	UserStatusDeleted = "deleted"
+)
+
+// UserFilter selects users listed by administrators, zero fields do not filter
+// Email matches a case-insensitive substring, AppId selects members of an app
+type UserFilter struct {
+	Email string
+	AppId int64
+	Status string
 }
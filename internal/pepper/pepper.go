@@ -0,0 +1,117 @@
+package pepper
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownVersion is returned when a hash references a pepper key that is not loaded
+var ErrUnknownVersion = errors.New("unknown pepper key version")
+
+// Pepper applies a server-side HMAC key to passwords before they are hashed
+// Every key has a version, version 0 means the password is hashed without pepper
+type Pepper struct {
+	current int
+	keys    map[int][]byte
+}
+
+// New creates a new Pepper with the given keys, current is the version used for new hashes
+func New(current int, keys map[int][]byte) (*Pepper, error) {
+	if current < 0 {
+		return nil, fmt.Errorf("pepper.New: invalid current version %d", current)
+	}
+	if current != 0 {
+		if key, ok := keys[current]; !ok || len(key) == 0 {
+			return nil, fmt.Errorf("pepper.New: key for current version %d is not configured", current)
+		}
+	}
+	return &Pepper{current: current, keys: keys}, nil
+}
+
+// Load builds a Pepper from keys set inline in the config and keys read from a file
+// Inline keys are indexed by version, the file contains one "version=key" pair per line
+func Load(current int, inline map[string]string, file string) (*Pepper, error) {
+	keys := make(map[int][]byte, len(inline))
+	for v, key := range inline {
+		version, err := strconv.Atoi(v)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("pepper.Load: invalid key version %q", v)
+		}
+		keys[version] = []byte(key)
+	}
+	if file != "" {
+		fileKeys, err := LoadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for version, key := range fileKeys {
+			keys[version] = key
+		}
+	}
+	return New(current, keys)
+}
+
+// LoadFile reads pepper keys from a file with one "version=key" pair per line
+// Empty lines and lines starting with # are ignored
+func LoadFile(path string) (map[int][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("pepper.LoadFile: %w", err)
+	}
+	defer f.Close()
+
+	keys := make(map[int][]byte)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		v, key, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("pepper.LoadFile: line %d: expected version=key", line)
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("pepper.LoadFile: line %d: invalid key version", line)
+		}
+		keys[version] = []byte(strings.TrimSpace(key))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("pepper.LoadFile: %w", err)
+	}
+	return keys, nil
+}
+
+// Current returns the key version used for newly hashed passwords
+func (p *Pepper) Current() int {
+	if p == nil {
+		return 0
+	}
+	return p.current
+}
+
+// Apply returns the password peppered with the key of the given version
+// The result is base64 encoded so it stays within the bcrypt input limit
+func (p *Pepper) Apply(password string, version int) ([]byte, error) {
+	if version == 0 {
+		return []byte(password), nil
+	}
+	if p == nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	key, ok := p.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil))), nil
+}
@@ -0,0 +1,158 @@
+package pepper
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		current int
+		keys    map[int][]byte
+		wantErr bool
+	}{
+		{name: "no pepper", current: 0, keys: nil},
+		{name: "current key configured", current: 2, keys: map[int][]byte{1: []byte("old"), 2: []byte("new")}},
+		{name: "negative version", current: -1, keys: nil, wantErr: true},
+		{name: "current key missing", current: 2, keys: map[int][]byte{1: []byte("old")}, wantErr: true},
+		{name: "current key empty", current: 1, keys: map[int][]byte{1: {}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.current, tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.Current() != tt.current {
+				t.Errorf("Current() = %d, want %d", p.Current(), tt.current)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	p, err := New(2, map[int][]byte{1: []byte("key-one"), 2: []byte("key-two")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacOf := func(key string, password string) []byte {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(password))
+		return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	}
+
+	tests := []struct {
+		name     string
+		pepper   *Pepper
+		password string
+		version  int
+		want     []byte
+		wantErr  error
+	}{
+		{name: "version 0 is the bare password", pepper: p, password: "secret", version: 0, want: []byte("secret")},
+		{name: "current key", pepper: p, password: "secret", version: 2, want: hmacOf("key-two", "secret")},
+		{name: "older key", pepper: p, password: "secret", version: 1, want: hmacOf("key-one", "secret")},
+		{name: "unknown version", pepper: p, password: "secret", version: 3, wantErr: ErrUnknownVersion},
+		{name: "nil pepper without version", pepper: nil, password: "secret", version: 0, want: []byte("secret")},
+		{name: "nil pepper with version", pepper: nil, password: "secret", version: 1, wantErr: ErrUnknownVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.pepper.Apply(tt.password, tt.version)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Apply() error = %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyStaysWithinBcryptLimit(t *testing.T) {
+	p, err := New(1, map[int][]byte{1: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := string(bytes.Repeat([]byte("x"), 200))
+	got, err := p.Apply(long, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 72 {
+		t.Errorf("len(Apply()) = %d, bcrypt only uses 72 bytes", len(got))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pepper")
+	content := "# pepper keys\n\n1 = from-file\n3=third\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		current int
+		inline  map[string]string
+		file    string
+		want    map[int]string
+		wantErr bool
+	}{
+		{name: "inline keys", current: 1, inline: map[string]string{"1": "inline"}, want: map[int]string{1: "inline"}},
+		{name: "file overrides inline", current: 3, inline: map[string]string{"1": "inline", "2": "two"}, file: file,
+			want: map[int]string{1: "from-file", 2: "two", 3: "third"}},
+		{name: "invalid inline version", current: 0, inline: map[string]string{"one": "key"}, wantErr: true},
+		{name: "zero inline version", current: 0, inline: map[string]string{"0": "key"}, wantErr: true},
+		{name: "missing file", current: 0, file: filepath.Join(t.TempDir(), "missing"), wantErr: true},
+		{name: "current not loaded", current: 4, file: file, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Load(tt.current, tt.inline, tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(p.keys) != len(tt.want) {
+				t.Fatalf("Load() loaded %d keys, want %d", len(p.keys), len(tt.want))
+			}
+			for version, key := range tt.want {
+				if string(p.keys[version]) != key {
+					t.Errorf("key %d = %q, want %q", version, p.keys[version], key)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFileRejectsMalformedLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "missing separator", content: "1 key\n"},
+		{name: "non numeric version", content: "v1=key\n"},
+		{name: "negative version", content: "-1=key\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "pepper")
+			if err := os.WriteFile(file, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadFile(file); err == nil {
+				t.Error("LoadFile() error = nil, want an error")
+			}
+		})
+	}
+}
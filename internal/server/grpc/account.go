@@ -0,0 +1,441 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"ssoq/internal/model"
+	"ssoq/internal/services/account"
+	"ssoq/internal/services/attributes"
+	"ssoq/internal/services/auth"
+	"ssoq/internal/storage"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccountServiceName is the name of the gRPC service of signed in users managing their own account
+const AccountServiceName = "sso.Account"
+
+// AccountServer implements the sso.Account gRPC service
+type AccountServer struct {
+	Account Account
+}
+
+// Account is the self-service used by AccountServer
+type Account interface {
+	ListImpersonations(ctx context.Context, user_id int64) ([]model.Impersonation, error)
+	LogoutAll(ctx context.Context, user_id int64) error
+	ListSessions(ctx context.Context, user_id int64) ([]model.Session, error)
+	RevokeSession(ctx context.Context, user_id int64, session_id int64) error
+	GetProfile(ctx context.Context, user_id int64) (*model.User, error)
+	UpdateProfile(ctx context.Context, user_id int64, update model.ProfileUpdate, version time.Time) (*model.User, error)
+	GetAttributes(ctx context.Context, user_id int64, app_id int64) (map[string]interface{}, error)
+	UpdateAttributes(ctx context.Context, user_id int64, app_id int64, patch map[string]interface{}) (map[string]interface{}, error)
+	ChangeEmail(ctx context.Context, user_id int64, email string, password string) (time.Time, error)
+	ConfirmEmailChange(ctx context.Context, user_id int64, token string) (*model.User, error)
+}
+
+var accountServiceDesc = grpc.ServiceDesc{
+	ServiceName: AccountServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(AccountServiceName, "ListImpersonations", (*AccountServer).ListImpersonations),
+		unaryMethod(AccountServiceName, "LogoutAll", (*AccountServer).LogoutAll),
+		unaryMethod(AccountServiceName, "ListSessions", (*AccountServer).ListSessions),
+		unaryMethod(AccountServiceName, "RevokeSession", (*AccountServer).RevokeSession),
+		unaryMethod(AccountServiceName, "GetProfile", (*AccountServer).GetProfile),
+		unaryMethod(AccountServiceName, "UpdateProfile", (*AccountServer).UpdateProfile),
+		unaryMethod(AccountServiceName, "GetAttributes", (*AccountServer).GetAttributes),
+		unaryMethod(AccountServiceName, "UpdateAttributes", (*AccountServer).UpdateAttributes),
+		unaryMethod(AccountServiceName, "ChangeEmail", (*AccountServer).ChangeEmail),
+		unaryMethod(AccountServiceName, "ConfirmEmailChange", (*AccountServer).ConfirmEmailChange),
+	},
+	Metadata: "account",
+}
+
+// RegisterAccount registers the sso.Account service, it uses the JSON codec
+func RegisterAccount(gRPC *grpc.Server, account Account) {
+	gRPC.RegisterService(&accountServiceDesc, &AccountServer{Account: account})
+}
+
+// Impersonation is the wire representation of model.Impersonation
+type Impersonation struct {
+	Id        int64  `json:"id"`
+	AdminId   int64  `json:"admin_id"`
+	AppId     int64  `json:"app_id"`
+	Reason    string `json:"reason"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type ListImpersonationsRequest struct{}
+
+type ListImpersonationsResponse struct {
+	Impersonations []Impersonation `json:"impersonations"`
+}
+
+func (s *AccountServer) ListImpersonations(ctx context.Context, req *ListImpersonationsRequest) (*ListImpersonationsResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	impersonations, err := s.Account.ListImpersonations(ctx, principal.UserID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	res := &ListImpersonationsResponse{Impersonations: make([]Impersonation, 0, len(impersonations))}
+	for _, i := range impersonations {
+		res.Impersonations = append(res.Impersonations, Impersonation{
+			Id:        i.Id,
+			AdminId:   i.AdminId,
+			AppId:     i.AppId,
+			Reason:    i.Reason,
+			CreatedAt: i.CreatedAt.Unix(),
+			ExpiresAt: i.ExpiresAt.Unix(),
+		})
+	}
+	return res, nil
+}
+
+type LogoutAllRequest struct{}
+
+// LogoutAll signs the caller out of every app
+// Delegated tokens are refused, an administrator impersonating the user uses ForceLogout of sso.Admin instead
+func (s *AccountServer) LogoutAll(ctx context.Context, req *LogoutAllRequest) (*SuccessResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+	if principal.Actor != nil {
+		return nil, status.Error(codes.PermissionDenied, "delegated access tokens cannot sign the user out")
+	}
+
+	if err := s.Account.LogoutAll(ctx, principal.UserID); err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+// Session is the wire representation of model.Session
+// Current marks the session of the access token used for the call
+type Session struct {
+	Id         int64  `json:"id"`
+	AppId      int64  `json:"app_id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	Current    bool   `json:"current"`
+}
+
+type ListSessionsRequest struct{}
+
+type ListSessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+type RevokeSessionRequest struct {
+	SessionId int64 `json:"session_id"`
+}
+
+func (s *AccountServer) ListSessions(ctx context.Context, req *ListSessionsRequest) (*ListSessionsResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	sessions, err := s.Account.ListSessions(ctx, principal.UserID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	res := &ListSessionsResponse{Sessions: make([]Session, 0, len(sessions))}
+	for _, session := range sessions {
+		res.Sessions = append(res.Sessions, Session{
+			Id:         session.Id,
+			AppId:      session.AppId,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: session.LastUsedAt.Unix(),
+			Current:    session.Id == principal.SessionID,
+		})
+	}
+	return res, nil
+}
+
+// RevokeSession signs the caller out of one of its sessions
+// Delegated tokens are refused like for LogoutAll
+func (s *AccountServer) RevokeSession(ctx context.Context, req *RevokeSessionRequest) (*SuccessResponse, error) {
+	if req.SessionId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+	if principal.Actor != nil {
+		return nil, status.Error(codes.PermissionDenied, "delegated access tokens cannot sign the user out")
+	}
+
+	if err := s.Account.RevokeSession(ctx, principal.UserID, req.SessionId); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return nil, status.Error(codes.NotFound, "session not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+// Profile is the wire representation of the profile of a user
+// version is the updated_at of the user in Unix microseconds, UpdateProfile must send the version it is based on
+type Profile struct {
+	Id          int64             `json:"id"`
+	Email       string            `json:"email"`
+	Username    string            `json:"username"`
+	DisplayName string            `json:"display_name"`
+	Locale      string            `json:"locale"`
+	Timezone    string            `json:"timezone"`
+	Metadata    map[string]string `json:"metadata"`
+	Version     int64             `json:"version"`
+}
+
+type GetProfileRequest struct{}
+
+// UpdateProfileRequest changes the given fields of the profile, omitted fields keep their value
+// metadata replaces all metadata, version is the version of the profile the changes are based on
+type UpdateProfileRequest struct {
+	Username    *string           `json:"username"`
+	DisplayName *string           `json:"display_name"`
+	Locale      *string           `json:"locale"`
+	Timezone    *string           `json:"timezone"`
+	Metadata    map[string]string `json:"metadata"`
+	Version     int64             `json:"version"`
+}
+
+type ProfileResponse struct {
+	Profile Profile `json:"profile"`
+}
+
+func (s *AccountServer) GetProfile(ctx context.Context, req *GetProfileRequest) (*ProfileResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	user, err := s.Account.GetProfile(ctx, principal.UserID)
+	if err != nil {
+		return nil, profileError(err)
+	}
+	return &ProfileResponse{Profile: toProfile(user)}, nil
+}
+
+// UpdateProfile changes the profile of the caller if nobody changed it since version
+// A stale version fails with Aborted, the client reads the profile again and reapplies its changes.
+// Delegated tokens are refused, administrators change users with UpdateUser of sso.Admin
+func (s *AccountServer) UpdateProfile(ctx context.Context, req *UpdateProfileRequest) (*ProfileResponse, error) {
+	if req.Version == 0 {
+		return nil, status.Error(codes.InvalidArgument, "version is required")
+	}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+	if principal.Actor != nil {
+		return nil, status.Error(codes.PermissionDenied, "delegated access tokens cannot change the profile")
+	}
+
+	update := model.ProfileUpdate{
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+		Metadata:    req.Metadata,
+	}
+	user, err := s.Account.UpdateProfile(ctx, principal.UserID, update, time.UnixMicro(req.Version))
+	if err != nil {
+		return nil, profileError(err)
+	}
+	return &ProfileResponse{Profile: toProfile(user)}, nil
+}
+
+func toProfile(user *model.User) Profile {
+	metadata := user.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return Profile{
+		Id:          user.Id,
+		Email:       user.Email,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+		Metadata:    metadata,
+		Version:     user.UpdatedAt.UnixMicro(),
+	}
+}
+
+// profileError maps errors of the profile operations to gRPC statuses
+func profileError(err error) error {
+	switch {
+	case errors.Is(err, account.ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrUserChanged):
+		return status.Error(codes.Aborted, "profile was changed since the given version")
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+// GetAttributesRequest reads the attributes of the caller in the app its access token was issued for
+type GetAttributesRequest struct{}
+
+// UpdateAttributesRequest patches the attributes of the caller in the app its access token was issued for
+// A null value removes the attribute, only attributes the app lets users write may be patched
+type UpdateAttributesRequest struct {
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+type AttributesResponse struct {
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+func (s *AccountServer) GetAttributes(ctx context.Context, req *GetAttributesRequest) (*AttributesResponse, error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	attrs, err := s.Account.GetAttributes(ctx, principal.UserID, principal.AppID)
+	if err != nil {
+		return nil, attributesError(err)
+	}
+	return &AttributesResponse{Attributes: attrs}, nil
+}
+
+func (s *AccountServer) UpdateAttributes(ctx context.Context, req *UpdateAttributesRequest) (*AttributesResponse, error) {
+	if len(req.Attributes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "attributes are required")
+	}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+	if principal.Actor != nil {
+		return nil, status.Error(codes.PermissionDenied, "delegated access tokens cannot change attributes")
+	}
+
+	attrs, err := s.Account.UpdateAttributes(ctx, principal.UserID, principal.AppID, req.Attributes)
+	if err != nil {
+		return nil, attributesError(err)
+	}
+	return &AttributesResponse{Attributes: attrs}, nil
+}
+
+// attributesError maps errors of the attribute operations to gRPC statuses
+func attributesError(err error) error {
+	switch {
+	case errors.Is(err, attributes.ErrInvalidAttributes):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, attributes.ErrNotWritable):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, storage.ErrUserChanged):
+		return status.Error(codes.Aborted, "attributes were changed concurrently, retry")
+	case errors.Is(err, storage.ErrNotMember), errors.Is(err, storage.ErrAppNotFound):
+		return status.Error(codes.NotFound, "user is not a member of the app")
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+// ChangeEmailRequest starts changing the email of the caller, a confirmation code is sent to the new address
+// Password is the current password of the caller
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type ChangeEmailResponse struct {
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// ConfirmEmailChangeRequest completes the pending email change of the caller with the code sent to the new address
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+func (s *AccountServer) ChangeEmail(ctx context.Context, req *ChangeEmailRequest) (*ChangeEmailResponse, error) {
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+	if req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "password is required")
+	}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+	if principal.Actor != nil {
+		return nil, status.Error(codes.PermissionDenied, "delegated access tokens cannot change the email")
+	}
+
+	expiresAt, err := s.Account.ChangeEmail(ctx, principal.UserID, req.Email, req.Password)
+	if err != nil {
+		return nil, emailError(err)
+	}
+	return &ChangeEmailResponse{ExpiresAt: expiresAt.Unix()}, nil
+}
+
+func (s *AccountServer) ConfirmEmailChange(ctx context.Context, req *ConfirmEmailChangeRequest) (*ProfileResponse, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+	if principal.Actor != nil {
+		return nil, status.Error(codes.PermissionDenied, "delegated access tokens cannot change the email")
+	}
+
+	user, err := s.Account.ConfirmEmailChange(ctx, principal.UserID, req.Token)
+	if err != nil {
+		return nil, emailError(err)
+	}
+	return &ProfileResponse{Profile: toProfile(user)}, nil
+}
+
+// emailError maps errors of the email change to gRPC statuses
+func emailError(err error) error {
+	switch {
+	case errors.Is(err, account.ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return status.Error(codes.PermissionDenied, "current password is invalid")
+	case errors.Is(err, auth.ErrLoginThrottled):
+		return throttledStatus(err)
+	case errors.Is(err, storage.ErrUserExists):
+		return status.Error(codes.AlreadyExists, "email is already in use")
+	case errors.Is(err, storage.ErrEmailChangeNotFound):
+		return status.Error(codes.InvalidArgument, "confirmation code is invalid or expired")
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+type principalContextKey struct{}
+
+// WithPrincipal returns a context carrying the verified access token of the caller
+func WithPrincipal(ctx context.Context, principal *auth.Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the verified access token of the caller stored by WithPrincipal
+func PrincipalFromContext(ctx context.Context) (*auth.Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*auth.Principal)
+	return principal, ok
+}
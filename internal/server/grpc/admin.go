@@ -0,0 +1,1072 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ssoq/internal/model"
+	"ssoq/internal/services/admin"
+	"ssoq/internal/services/attributes"
+	"ssoq/internal/services/auth"
+	"ssoq/internal/storage"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminServiceName is the name of the admin gRPC service, its methods are only served to administrators
+const AdminServiceName = "sso.Admin"
+
+// AdminServer implements the sso.Admin gRPC service
+type AdminServer struct {
+	Admin Admin
+}
+
+// Admin is the administration service used by AdminServer
+type Admin interface {
+	CreateRole(ctx context.Context, app_id int64, name string, description string, permissions []string) (*model.Role, error)
+	DeleteRole(ctx context.Context, role_id int64) error
+	ListRoles(ctx context.Context, app_id int64) ([]model.Role, error)
+	SetRolePermissions(ctx context.Context, role_id int64, permissions []string) (*model.Role, error)
+	CreatePermission(ctx context.Context, app_id int64, name string, description string) (*model.Permission, error)
+	DeletePermission(ctx context.Context, permission_id int64) error
+	ListPermissions(ctx context.Context, app_id int64) ([]model.Permission, error)
+	AssignRole(ctx context.Context, user_id int64, role_id int64) error
+	UnassignRole(ctx context.Context, user_id int64, role_id int64) error
+	ListUserRoles(ctx context.Context, user_id int64, app_id int64) ([]model.Role, error)
+	CreateClient(ctx context.Context, app_id int64, name string, scopes []string, publicKey string) (*model.Client, string, error)
+	DeleteClient(ctx context.Context, client_id string) error
+	ListClients(ctx context.Context, app_id int64) ([]model.Client, error)
+	CreateExchangePolicy(ctx context.Context, client_id string, subject_app_id int64, audience_app_id int64, scopes []string) (*model.ExchangePolicy, error)
+	DeleteExchangePolicy(ctx context.Context, policy_id int64) error
+	ListExchangePolicies(ctx context.Context, client_id string) ([]model.ExchangePolicy, error)
+	StartImpersonation(ctx context.Context, admin_id int64, user_id int64, app_id int64, reason string, scopes []string) (*model.Impersonation, string, error)
+	ForceLogout(ctx context.Context, admin_id int64, user_id int64) error
+	ListUsers(ctx context.Context, filter model.UserFilter, cursor int64, limit int) ([]model.User, int64, error)
+	GetUser(ctx context.Context, user_id int64) (*model.User, error)
+	UpdateUser(ctx context.Context, admin_id int64, user_id int64, email string, username string) (*model.User, error)
+	DisableUser(ctx context.Context, admin_id int64, user_id int64, reason string) error
+	EnableUser(ctx context.Context, admin_id int64, user_id int64) error
+	SetUserStatus(ctx context.Context, admin_id int64, user_id int64, status string, reason string) error
+	DeleteUser(ctx context.Context, admin_id int64, user_id int64, reason string) error
+	ResetMFA(ctx context.Context, admin_id int64, user_id int64) error
+	CreateApp(ctx context.Context, admin_id int64, app *model.App) (*model.App, string, error)
+	GetApp(ctx context.Context, app_id int64) (*model.App, error)
+	UpdateApp(ctx context.Context, admin_id int64, app *model.App) (*model.App, error)
+	ListApps(ctx context.Context) ([]model.App, error)
+	DisableApp(ctx context.Context, admin_id int64, app_id int64) error
+	EnableApp(ctx context.Context, admin_id int64, app_id int64) error
+	RotateAppSecret(ctx context.Context, admin_id int64, app_id int64, grace time.Duration) (*model.App, string, error)
+	SetAppAttributeSchema(ctx context.Context, admin_id int64, app_id int64, schema []byte) (*model.App, error)
+	GetUserAttributes(ctx context.Context, user_id int64, app_id int64) (map[string]interface{}, error)
+	UpdateUserAttributes(ctx context.Context, admin_id int64, user_id int64, app_id int64, patch map[string]interface{}) (map[string]interface{}, error)
+}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: AdminServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(AdminServiceName, "CreateRole", (*AdminServer).CreateRole),
+		unaryMethod(AdminServiceName, "DeleteRole", (*AdminServer).DeleteRole),
+		unaryMethod(AdminServiceName, "ListRoles", (*AdminServer).ListRoles),
+		unaryMethod(AdminServiceName, "SetRolePermissions", (*AdminServer).SetRolePermissions),
+		unaryMethod(AdminServiceName, "CreatePermission", (*AdminServer).CreatePermission),
+		unaryMethod(AdminServiceName, "DeletePermission", (*AdminServer).DeletePermission),
+		unaryMethod(AdminServiceName, "ListPermissions", (*AdminServer).ListPermissions),
+		unaryMethod(AdminServiceName, "AssignRole", (*AdminServer).AssignRole),
+		unaryMethod(AdminServiceName, "UnassignRole", (*AdminServer).UnassignRole),
+		unaryMethod(AdminServiceName, "ListUserRoles", (*AdminServer).ListUserRoles),
+		unaryMethod(AdminServiceName, "CreateClient", (*AdminServer).CreateClient),
+		unaryMethod(AdminServiceName, "DeleteClient", (*AdminServer).DeleteClient),
+		unaryMethod(AdminServiceName, "ListClients", (*AdminServer).ListClients),
+		unaryMethod(AdminServiceName, "CreateExchangePolicy", (*AdminServer).CreateExchangePolicy),
+		unaryMethod(AdminServiceName, "DeleteExchangePolicy", (*AdminServer).DeleteExchangePolicy),
+		unaryMethod(AdminServiceName, "ListExchangePolicies", (*AdminServer).ListExchangePolicies),
+		unaryMethod(AdminServiceName, "StartImpersonation", (*AdminServer).StartImpersonation),
+		unaryMethod(AdminServiceName, "ForceLogout", (*AdminServer).ForceLogout),
+		unaryMethod(AdminServiceName, "ListUsers", (*AdminServer).ListUsers),
+		unaryMethod(AdminServiceName, "GetUser", (*AdminServer).GetUser),
+		unaryMethod(AdminServiceName, "UpdateUser", (*AdminServer).UpdateUser),
+		unaryMethod(AdminServiceName, "DisableUser", (*AdminServer).DisableUser),
+		unaryMethod(AdminServiceName, "EnableUser", (*AdminServer).EnableUser),
+		unaryMethod(AdminServiceName, "SetUserStatus", (*AdminServer).SetUserStatus),
+		unaryMethod(AdminServiceName, "DeleteUser", (*AdminServer).DeleteUser),
+		unaryMethod(AdminServiceName, "ResetMFA", (*AdminServer).ResetMFA),
+		unaryMethod(AdminServiceName, "CreateApp", (*AdminServer).CreateApp),
+		unaryMethod(AdminServiceName, "GetApp", (*AdminServer).GetApp),
+		unaryMethod(AdminServiceName, "UpdateApp", (*AdminServer).UpdateApp),
+		unaryMethod(AdminServiceName, "ListApps", (*AdminServer).ListApps),
+		unaryMethod(AdminServiceName, "DisableApp", (*AdminServer).DisableApp),
+		unaryMethod(AdminServiceName, "EnableApp", (*AdminServer).EnableApp),
+		unaryMethod(AdminServiceName, "RotateAppSecret", (*AdminServer).RotateAppSecret),
+		unaryMethod(AdminServiceName, "SetAppAttributeSchema", (*AdminServer).SetAppAttributeSchema),
+		unaryMethod(AdminServiceName, "GetUserAttributes", (*AdminServer).GetUserAttributes),
+		unaryMethod(AdminServiceName, "UpdateUserAttributes", (*AdminServer).UpdateUserAttributes),
+	},
+	Metadata: "admin",
+}
+
+// RegisterAdmin registers the sso.Admin service, it uses the JSON codec
+func RegisterAdmin(gRPC *grpc.Server, admin Admin) {
+	gRPC.RegisterService(&adminServiceDesc, &AdminServer{Admin: admin})
+}
+
+// Role is the wire representation of model.Role
+type Role struct {
+	Id          int64    `json:"id"`
+	AppId       int64    `json:"app_id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// Permission is the wire representation of model.Permission
+type Permission struct {
+	Id          int64  `json:"id"`
+	AppId       int64  `json:"app_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type CreateRoleRequest struct {
+	AppId       int64    `json:"app_id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleResponse struct {
+	Role Role `json:"role"`
+}
+
+type DeleteRoleRequest struct {
+	RoleId int64 `json:"role_id"`
+}
+
+type ListRolesRequest struct {
+	AppId int64 `json:"app_id"`
+}
+
+type ListRolesResponse struct {
+	Roles []Role `json:"roles"`
+}
+
+type SetRolePermissionsRequest struct {
+	RoleId      int64    `json:"role_id"`
+	Permissions []string `json:"permissions"`
+}
+
+type CreatePermissionRequest struct {
+	AppId       int64  `json:"app_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type PermissionResponse struct {
+	Permission Permission `json:"permission"`
+}
+
+type DeletePermissionRequest struct {
+	PermissionId int64 `json:"permission_id"`
+}
+
+type ListPermissionsRequest struct {
+	AppId int64 `json:"app_id"`
+}
+
+type ListPermissionsResponse struct {
+	Permissions []Permission `json:"permissions"`
+}
+
+type UserRoleRequest struct {
+	UserId int64 `json:"user_id"`
+	RoleId int64 `json:"role_id"`
+}
+
+type ListUserRolesRequest struct {
+	UserId int64 `json:"user_id"`
+	AppId  int64 `json:"app_id"`
+}
+
+// Client is the wire representation of model.Client, the secret hash is never sent
+type Client struct {
+	ClientId  string   `json:"client_id"`
+	AppId     int64    `json:"app_id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	PublicKey string   `json:"public_key,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+type CreateClientRequest struct {
+	AppId     int64    `json:"app_id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	PublicKey string   `json:"public_key"`
+}
+
+// CreateClientResponse carries the client secret, it is only returned once
+type CreateClientResponse struct {
+	Client       Client `json:"client"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type DeleteClientRequest struct {
+	ClientId string `json:"client_id"`
+}
+
+type ListClientsRequest struct {
+	AppId int64 `json:"app_id"`
+}
+
+type ListClientsResponse struct {
+	Clients []Client `json:"clients"`
+}
+
+// ExchangePolicy is the wire representation of model.ExchangePolicy
+type ExchangePolicy struct {
+	Id            int64    `json:"id"`
+	ClientId      string   `json:"client_id"`
+	SubjectAppId  int64    `json:"subject_app_id"`
+	AudienceAppId int64    `json:"audience_app_id"`
+	Scopes        []string `json:"scopes"`
+}
+
+type CreateExchangePolicyRequest struct {
+	ClientId      string   `json:"client_id"`
+	SubjectAppId  int64    `json:"subject_app_id"`
+	AudienceAppId int64    `json:"audience_app_id"`
+	Scopes        []string `json:"scopes"`
+}
+
+type ExchangePolicyResponse struct {
+	Policy ExchangePolicy `json:"policy"`
+}
+
+type DeleteExchangePolicyRequest struct {
+	PolicyId int64 `json:"policy_id"`
+}
+
+type ListExchangePoliciesRequest struct {
+	ClientId string `json:"client_id"`
+}
+
+type ListExchangePoliciesResponse struct {
+	Policies []ExchangePolicy `json:"policies"`
+}
+
+type StartImpersonationRequest struct {
+	UserId int64    `json:"user_id"`
+	AppId  int64    `json:"app_id"`
+	Reason string   `json:"reason"`
+	Scopes []string `json:"scopes"`
+}
+
+// StartImpersonationResponse carries a token acting as the user, it cannot be refreshed
+type StartImpersonationResponse struct {
+	ImpersonationId int64  `json:"impersonation_id"`
+	AccessToken     string `json:"access_token"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	ExpiresAt       int64  `json:"expires_at"`
+}
+
+// UserRequest selects a user, reason is recorded by DisableUser and DeleteUser
+type UserRequest struct {
+	UserId int64  `json:"user_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// UserStatusRequest sets the status of a user: active, disabled, locked or pending_verification
+type UserStatusRequest struct {
+	UserId int64  `json:"user_id"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// User is the wire representation of model.User, times are Unix seconds and 0 when unset
+type User struct {
+	Id              int64  `json:"id"`
+	Email           string `json:"email"`
+	Username        string `json:"username"`
+	AppId           int64  `json:"app_id"`
+	TenantAppId     int64  `json:"tenant_app_id"`
+	CreatedAt       int64  `json:"created_at"`
+	Status          string `json:"status"`
+	StatusReason    string `json:"status_reason"`
+	StatusChangedAt int64  `json:"status_changed_at"`
+	EmailReleasedAt int64  `json:"email_released_at"`
+	LockedUntil     int64  `json:"locked_until"`
+}
+
+// ListUsersRequest filters users, empty fields do not filter, cursor is the next_cursor of the previous page
+type ListUsersRequest struct {
+	Email  string `json:"email"`
+	AppId  int64  `json:"app_id"`
+	Status string `json:"status"`
+	Cursor int64  `json:"cursor"`
+	Limit  int    `json:"limit"`
+}
+
+// ListUsersResponse carries a page of users, next_cursor is 0 on the last page
+type ListUsersResponse struct {
+	Users      []User `json:"users"`
+	NextCursor int64  `json:"next_cursor"`
+}
+
+type UserResponse struct {
+	User User `json:"user"`
+}
+
+type UpdateUserRequest struct {
+	UserId   int64  `json:"user_id"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+// App is the wire representation of model.App, the secret is only returned by CreateApp and RotateAppSecret
+type App struct {
+	Id                    int64    `json:"id"`
+	Name                  string   `json:"name"`
+	Owner                 string   `json:"owner"`
+	Scopes                []string `json:"scopes"`
+	RedirectURIs          []string `json:"redirect_uris"`
+	GrantTypes            []string `json:"grant_types"`
+	BackchannelLogoutURI  string   `json:"backchannel_logout_uri"`
+	FrontchannelLogoutURI string   `json:"frontchannel_logout_uri"`
+	CreatedAt             int64    `json:"created_at"`
+	Disabled              bool     `json:"disabled"`
+	DisabledAt            int64    `json:"disabled_at"`
+	// PreviousSecretExpiresAt is when the secret replaced by the last rotation stops being accepted
+	PreviousSecretExpiresAt int64 `json:"previous_secret_expires_at"`
+	// AttributeSchema is the JSON Schema of the user attributes of the app, omitted when it has none
+	AttributeSchema json.RawMessage `json:"attribute_schema,omitempty"`
+}
+
+// AppSettings are the settings of an app set by CreateApp and replaced as a whole by UpdateApp
+// An empty grant_types list allows every grant type
+type AppSettings struct {
+	Name                  string   `json:"name"`
+	Owner                 string   `json:"owner"`
+	Scopes                []string `json:"scopes"`
+	RedirectURIs          []string `json:"redirect_uris"`
+	GrantTypes            []string `json:"grant_types"`
+	BackchannelLogoutURI  string   `json:"backchannel_logout_uri"`
+	FrontchannelLogoutURI string   `json:"frontchannel_logout_uri"`
+}
+
+type UpdateAppRequest struct {
+	AppId int64 `json:"app_id"`
+	AppSettings
+}
+
+type AppRequest struct {
+	AppId int64 `json:"app_id"`
+}
+
+// RotateAppSecretRequest rotates the secret of an app, the replaced secret stays valid for grace_seconds
+// Without grace_seconds the configured grace period applies, 0 cuts the replaced secret off at once
+type RotateAppSecretRequest struct {
+	AppId        int64  `json:"app_id"`
+	GraceSeconds *int64 `json:"grace_seconds"`
+}
+
+type AppResponse struct {
+	App App `json:"app"`
+}
+
+// AppSecretResponse carries a newly generated app secret, it cannot be retrieved again
+type AppSecretResponse struct {
+	App    *App   `json:"app,omitempty"`
+	Secret string `json:"secret"`
+}
+
+type ListAppsRequest struct{}
+
+type ListAppsResponse struct {
+	Apps []App `json:"apps"`
+}
+
+// SetAppAttributeSchemaRequest replaces the attribute schema of an app, a missing or null schema removes it
+type SetAppAttributeSchemaRequest struct {
+	AppId  int64           `json:"app_id"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+type UserAttributesRequest struct {
+	UserId int64 `json:"user_id"`
+	AppId  int64 `json:"app_id"`
+}
+
+// UpdateUserAttributesRequest patches the attributes of a user in an app, a null value removes the attribute
+type UpdateUserAttributesRequest struct {
+	UserId     int64                  `json:"user_id"`
+	AppId      int64                  `json:"app_id"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+type UserAttributesResponse struct {
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// SuccessResponse is returned by admin operations that have no other result
+type SuccessResponse struct {
+	Success bool `json:"success"`
+}
+
+func (s *AdminServer) CreateRole(ctx context.Context, req *CreateRoleRequest) (*RoleResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	role, err := s.Admin.CreateRole(ctx, req.AppId, req.Name, req.Description, req.Permissions)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &RoleResponse{Role: toRole(role)}, nil
+}
+
+func (s *AdminServer) DeleteRole(ctx context.Context, req *DeleteRoleRequest) (*SuccessResponse, error) {
+	if req.RoleId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "role_id is required")
+	}
+
+	if err := s.Admin.DeleteRole(ctx, req.RoleId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (s *AdminServer) ListRoles(ctx context.Context, req *ListRolesRequest) (*ListRolesResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	roles, err := s.Admin.ListRoles(ctx, req.AppId)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &ListRolesResponse{Roles: toRoles(roles)}, nil
+}
+
+func (s *AdminServer) SetRolePermissions(ctx context.Context, req *SetRolePermissionsRequest) (*RoleResponse, error) {
+	if req.RoleId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "role_id is required")
+	}
+
+	role, err := s.Admin.SetRolePermissions(ctx, req.RoleId, req.Permissions)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &RoleResponse{Role: toRole(role)}, nil
+}
+
+func (s *AdminServer) CreatePermission(ctx context.Context, req *CreatePermissionRequest) (*PermissionResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	permission, err := s.Admin.CreatePermission(ctx, req.AppId, req.Name, req.Description)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &PermissionResponse{Permission: Permission(*permission)}, nil
+}
+
+func (s *AdminServer) DeletePermission(ctx context.Context, req *DeletePermissionRequest) (*SuccessResponse, error) {
+	if req.PermissionId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "permission_id is required")
+	}
+
+	if err := s.Admin.DeletePermission(ctx, req.PermissionId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (s *AdminServer) ListPermissions(ctx context.Context, req *ListPermissionsRequest) (*ListPermissionsResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	permissions, err := s.Admin.ListPermissions(ctx, req.AppId)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	res := &ListPermissionsResponse{Permissions: make([]Permission, 0, len(permissions))}
+	for _, p := range permissions {
+		res.Permissions = append(res.Permissions, Permission(p))
+	}
+	return res, nil
+}
+
+func (s *AdminServer) AssignRole(ctx context.Context, req *UserRoleRequest) (*SuccessResponse, error) {
+	if req.UserId == 0 || req.RoleId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id and role_id are required")
+	}
+
+	if err := s.Admin.AssignRole(ctx, req.UserId, req.RoleId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (s *AdminServer) UnassignRole(ctx context.Context, req *UserRoleRequest) (*SuccessResponse, error) {
+	if req.UserId == 0 || req.RoleId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id and role_id are required")
+	}
+
+	if err := s.Admin.UnassignRole(ctx, req.UserId, req.RoleId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (s *AdminServer) ListUserRoles(ctx context.Context, req *ListUserRolesRequest) (*ListRolesResponse, error) {
+	if req.UserId == 0 || req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id and app_id are required")
+	}
+
+	roles, err := s.Admin.ListUserRoles(ctx, req.UserId, req.AppId)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &ListRolesResponse{Roles: toRoles(roles)}, nil
+}
+
+func (s *AdminServer) CreateClient(ctx context.Context, req *CreateClientRequest) (*CreateClientResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	client, secret, err := s.Admin.CreateClient(ctx, req.AppId, req.Name, req.Scopes, req.PublicKey)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &CreateClientResponse{Client: toClient(client), ClientSecret: secret}, nil
+}
+
+func (s *AdminServer) DeleteClient(ctx context.Context, req *DeleteClientRequest) (*SuccessResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+
+	if err := s.Admin.DeleteClient(ctx, req.ClientId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (s *AdminServer) ListClients(ctx context.Context, req *ListClientsRequest) (*ListClientsResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	clients, err := s.Admin.ListClients(ctx, req.AppId)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	res := &ListClientsResponse{Clients: make([]Client, 0, len(clients))}
+	for i := range clients {
+		res.Clients = append(res.Clients, toClient(&clients[i]))
+	}
+	return res, nil
+}
+
+func (s *AdminServer) CreateExchangePolicy(ctx context.Context, req *CreateExchangePolicyRequest) (*ExchangePolicyResponse, error) {
+	if req.ClientId == "" || req.SubjectAppId == 0 || req.AudienceAppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "client_id, subject_app_id and audience_app_id are required")
+	}
+
+	policy, err := s.Admin.CreateExchangePolicy(ctx, req.ClientId, req.SubjectAppId, req.AudienceAppId, req.Scopes)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &ExchangePolicyResponse{Policy: toExchangePolicy(policy)}, nil
+}
+
+func (s *AdminServer) DeleteExchangePolicy(ctx context.Context, req *DeleteExchangePolicyRequest) (*SuccessResponse, error) {
+	if req.PolicyId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "policy_id is required")
+	}
+
+	if err := s.Admin.DeleteExchangePolicy(ctx, req.PolicyId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (s *AdminServer) ListExchangePolicies(ctx context.Context, req *ListExchangePoliciesRequest) (*ListExchangePoliciesResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+
+	policies, err := s.Admin.ListExchangePolicies(ctx, req.ClientId)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	res := &ListExchangePoliciesResponse{Policies: make([]ExchangePolicy, 0, len(policies))}
+	for i := range policies {
+		res.Policies = append(res.Policies, toExchangePolicy(&policies[i]))
+	}
+	return res, nil
+}
+
+func (s *AdminServer) StartImpersonation(ctx context.Context, req *StartImpersonationRequest) (*StartImpersonationResponse, error) {
+	if req.UserId == 0 || req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id and app_id are required")
+	}
+	if req.Reason == "" {
+		return nil, status.Error(codes.InvalidArgument, "reason is required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	impersonation, token, err := s.Admin.StartImpersonation(ctx, admin_id, req.UserId, req.AppId, req.Reason, req.Scopes)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &StartImpersonationResponse{
+		ImpersonationId: impersonation.Id,
+		AccessToken:     token,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(time.Until(impersonation.ExpiresAt).Seconds()),
+		ExpiresAt:       impersonation.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (s *AdminServer) ForceLogout(ctx context.Context, req *UserRequest) (*SuccessResponse, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	if err := s.Admin.ForceLogout(ctx, admin_id, req.UserId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (s *AdminServer) ListUsers(ctx context.Context, req *ListUsersRequest) (*ListUsersResponse, error) {
+	if req.Cursor < 0 || req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "cursor and limit must not be negative")
+	}
+
+	filter := model.UserFilter{Email: req.Email, AppId: req.AppId, Status: req.Status}
+	users, next, err := s.Admin.ListUsers(ctx, filter, req.Cursor, req.Limit)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	res := &ListUsersResponse{Users: make([]User, 0, len(users)), NextCursor: next}
+	for i := range users {
+		res.Users = append(res.Users, toUser(&users[i]))
+	}
+	return res, nil
+}
+
+func (s *AdminServer) GetUser(ctx context.Context, req *UserRequest) (*UserResponse, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	user, err := s.Admin.GetUser(ctx, req.UserId)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &UserResponse{User: toUser(user)}, nil
+}
+
+func (s *AdminServer) UpdateUser(ctx context.Context, req *UpdateUserRequest) (*UserResponse, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if req.Email == "" && req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "email or username is required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	user, err := s.Admin.UpdateUser(ctx, admin_id, req.UserId, req.Email, req.Username)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &UserResponse{User: toUser(user)}, nil
+}
+
+func (s *AdminServer) DisableUser(ctx context.Context, req *UserRequest) (*SuccessResponse, error) {
+	return s.userAction(ctx, req, func(ctx context.Context, admin_id int64, user_id int64) error {
+		return s.Admin.DisableUser(ctx, admin_id, user_id, req.Reason)
+	})
+}
+
+func (s *AdminServer) EnableUser(ctx context.Context, req *UserRequest) (*SuccessResponse, error) {
+	return s.userAction(ctx, req, s.Admin.EnableUser)
+}
+
+func (s *AdminServer) SetUserStatus(ctx context.Context, req *UserStatusRequest) (*SuccessResponse, error) {
+	if req.Status == "" {
+		return nil, status.Error(codes.InvalidArgument, "status is required")
+	}
+	return s.userAction(ctx, &UserRequest{UserId: req.UserId}, func(ctx context.Context, admin_id int64, user_id int64) error {
+		return s.Admin.SetUserStatus(ctx, admin_id, user_id, req.Status, req.Reason)
+	})
+}
+
+func (s *AdminServer) DeleteUser(ctx context.Context, req *UserRequest) (*SuccessResponse, error) {
+	return s.userAction(ctx, req, func(ctx context.Context, admin_id int64, user_id int64) error {
+		return s.Admin.DeleteUser(ctx, admin_id, user_id, req.Reason)
+	})
+}
+
+func (s *AdminServer) ResetMFA(ctx context.Context, req *UserRequest) (*SuccessResponse, error) {
+	return s.userAction(ctx, req, s.Admin.ResetMFA)
+}
+
+// userAction runs an admin operation on the user of req on behalf of the authenticated administrator
+func (s *AdminServer) userAction(ctx context.Context, req *UserRequest, action func(ctx context.Context, admin_id int64, user_id int64) error) (*SuccessResponse, error) {
+	if req.UserId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	if err := action(ctx, admin_id, req.UserId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (s *AdminServer) CreateApp(ctx context.Context, req *AppSettings) (*AppSecretResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	app, secret, err := s.Admin.CreateApp(ctx, admin_id, req.toApp(0))
+	if err != nil {
+		return nil, adminError(err)
+	}
+	res := toApp(app)
+	return &AppSecretResponse{App: &res, Secret: secret}, nil
+}
+
+func (s *AdminServer) GetApp(ctx context.Context, req *AppRequest) (*AppResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	app, err := s.Admin.GetApp(ctx, req.AppId)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &AppResponse{App: toApp(app)}, nil
+}
+
+func (s *AdminServer) UpdateApp(ctx context.Context, req *UpdateAppRequest) (*AppResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	app, err := s.Admin.UpdateApp(ctx, admin_id, req.toApp(req.AppId))
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &AppResponse{App: toApp(app)}, nil
+}
+
+func (s *AdminServer) ListApps(ctx context.Context, req *ListAppsRequest) (*ListAppsResponse, error) {
+	apps, err := s.Admin.ListApps(ctx)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	res := &ListAppsResponse{Apps: make([]App, 0, len(apps))}
+	for i := range apps {
+		res.Apps = append(res.Apps, toApp(&apps[i]))
+	}
+	return res, nil
+}
+
+func (s *AdminServer) DisableApp(ctx context.Context, req *AppRequest) (*SuccessResponse, error) {
+	return s.appAction(ctx, req, s.Admin.DisableApp)
+}
+
+func (s *AdminServer) EnableApp(ctx context.Context, req *AppRequest) (*SuccessResponse, error) {
+	return s.appAction(ctx, req, s.Admin.EnableApp)
+}
+
+func (s *AdminServer) RotateAppSecret(ctx context.Context, req *RotateAppSecretRequest) (*AppSecretResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	grace := time.Duration(-1)
+	if req.GraceSeconds != nil {
+		if *req.GraceSeconds < 0 {
+			return nil, status.Error(codes.InvalidArgument, "grace_seconds must not be negative")
+		}
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	app, secret, err := s.Admin.RotateAppSecret(ctx, admin_id, req.AppId, grace)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	res := toApp(app)
+	return &AppSecretResponse{App: &res, Secret: secret}, nil
+}
+
+func (s *AdminServer) SetAppAttributeSchema(ctx context.Context, req *SetAppAttributeSchemaRequest) (*AppResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	schema := []byte(req.Schema)
+	if string(schema) == "null" {
+		schema = nil
+	}
+	app, err := s.Admin.SetAppAttributeSchema(ctx, admin_id, req.AppId, schema)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &AppResponse{App: toApp(app)}, nil
+}
+
+func (s *AdminServer) GetUserAttributes(ctx context.Context, req *UserAttributesRequest) (*UserAttributesResponse, error) {
+	if req.UserId == 0 || req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id and app_id are required")
+	}
+
+	attrs, err := s.Admin.GetUserAttributes(ctx, req.UserId, req.AppId)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &UserAttributesResponse{Attributes: attrs}, nil
+}
+
+func (s *AdminServer) UpdateUserAttributes(ctx context.Context, req *UpdateUserAttributesRequest) (*UserAttributesResponse, error) {
+	if req.UserId == 0 || req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id and app_id are required")
+	}
+	if len(req.Attributes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "attributes are required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	attrs, err := s.Admin.UpdateUserAttributes(ctx, admin_id, req.UserId, req.AppId, req.Attributes)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &UserAttributesResponse{Attributes: attrs}, nil
+}
+
+// appAction runs an admin operation on the app of req on behalf of the authenticated administrator
+func (s *AdminServer) appAction(ctx context.Context, req *AppRequest, action func(ctx context.Context, admin_id int64, app_id int64) error) (*SuccessResponse, error) {
+	if req.AppId == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	admin_id, ok := AdminFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "admin access token is required")
+	}
+
+	if err := action(ctx, admin_id, req.AppId); err != nil {
+		return nil, adminError(err)
+	}
+	return &SuccessResponse{Success: true}, nil
+}
+
+func (a *AppSettings) toApp(app_id int64) *model.App {
+	return &model.App{
+		Id:                    app_id,
+		Name:                  a.Name,
+		Owner:                 a.Owner,
+		Scopes:                a.Scopes,
+		RedirectURIs:          a.RedirectURIs,
+		GrantTypes:            a.GrantTypes,
+		BackchannelLogoutURI:  a.BackchannelLogoutURI,
+		FrontchannelLogoutURI: a.FrontchannelLogoutURI,
+	}
+}
+
+func toApp(app *model.App) App {
+	return App{
+		Id:                    app.Id,
+		Name:                  app.Name,
+		Owner:                 app.Owner,
+		Scopes:                nonNil(app.Scopes),
+		RedirectURIs:          nonNil(app.RedirectURIs),
+		GrantTypes:            nonNil(app.GrantTypes),
+		BackchannelLogoutURI:  app.BackchannelLogoutURI,
+		FrontchannelLogoutURI: app.FrontchannelLogoutURI,
+		CreatedAt:             unixOrZero(app.CreatedAt),
+		Disabled:              !app.DisabledAt.IsZero(),
+		DisabledAt:            unixOrZero(app.DisabledAt),
+		// An expired previous secret is no longer accepted, so it is not reported either
+		PreviousSecretExpiresAt: unixOrZero(previousSecretExpiry(app)),
+		AttributeSchema:         app.AttributeSchema,
+	}
+}
+
+// previousSecretExpiry returns when the previous secret of app expires, or the zero time when none is accepted now
+func previousSecretExpiry(app *model.App) time.Time {
+	if app.PreviousSecret == "" || time.Now().After(app.PreviousSecretExpiresAt) {
+		return time.Time{}
+	}
+	return app.PreviousSecretExpiresAt
+}
+
+// nonNil returns s, or an empty slice so it is encoded as [] rather than null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func toUser(user *model.User) User {
+	return User{
+		Id:              user.Id,
+		Email:           user.Email,
+		Username:        user.Username,
+		AppId:           user.AppId,
+		TenantAppId:     user.TenantAppId,
+		CreatedAt:       unixOrZero(user.CreatedAt),
+		Status:          user.Status,
+		StatusReason:    user.StatusReason,
+		StatusChangedAt: unixOrZero(user.StatusChangedAt),
+		EmailReleasedAt: unixOrZero(user.EmailReleasedAt),
+		LockedUntil:     unixOrZero(user.LockedUntil),
+	}
+}
+
+// unixOrZero returns t as Unix seconds, or 0 for the zero time
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func toRole(role *model.Role) Role {
+	permissions := role.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return Role{Id: role.Id, AppId: role.AppId, Name: role.Name, Description: role.Description, Permissions: permissions}
+}
+
+func toRoles(roles []model.Role) []Role {
+	res := make([]Role, 0, len(roles))
+	for i := range roles {
+		res = append(res, toRole(&roles[i]))
+	}
+	return res
+}
+
+func toClient(client *model.Client) Client {
+	scopes := client.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return Client{
+		ClientId:  client.ClientId,
+		AppId:     client.AppId,
+		Name:      client.Name,
+		Scopes:    scopes,
+		PublicKey: client.PublicKey,
+		CreatedAt: client.CreatedAt.Unix(),
+	}
+}
+
+func toExchangePolicy(policy *model.ExchangePolicy) ExchangePolicy {
+	scopes := policy.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return ExchangePolicy{
+		Id:            policy.Id,
+		ClientId:      policy.ClientId,
+		SubjectAppId:  policy.SubjectAppId,
+		AudienceAppId: policy.AudienceAppId,
+		Scopes:        scopes,
+	}
+}
+
+// adminError maps service and storage errors to gRPC status errors
+func adminError(err error) error {
+	switch {
+	case errors.Is(err, admin.ErrInvalidArgument), errors.Is(err, attributes.ErrInvalidSchema),
+		errors.Is(err, attributes.ErrInvalidAttributes):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrNotMember):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, storage.ErrUserChanged):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, storage.ErrRoleNotFound), errors.Is(err, storage.ErrPermissionNotFound),
+		errors.Is(err, storage.ErrUserNotFound), errors.Is(err, storage.ErrClientNotFound),
+		errors.Is(err, storage.ErrExchangePolicyNotFound), errors.Is(err, storage.ErrAppNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrRoleExists), errors.Is(err, storage.ErrPermissionExists),
+		errors.Is(err, storage.ErrExchangePolicyExists), errors.Is(err, storage.ErrUserExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, admin.ErrUnsupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, auth.ErrPermissionDenied), errors.Is(err, auth.ErrAppAccessDenied),
+		errors.Is(err, attributes.ErrNotWritable):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+type adminContextKey struct{}
+
+// WithAdmin returns a context carrying the id of the authenticated administrator
+func WithAdmin(ctx context.Context, admin_id int64) context.Context {
+	return context.WithValue(ctx, adminContextKey{}, admin_id)
+}
+
+// AdminFromContext returns the id of the authenticated administrator stored by WithAdmin
+func AdminFromContext(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(adminContextKey{}).(int64)
+	return id, ok
+}
@@ -2,10 +2,16 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"ssoq/internal/services/auth"
+	"strings"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	ssov1 "github.com/Aim4ikqwe/ssoprotos/gen/go/sso"
 )
@@ -16,10 +22,10 @@ type Server struct {
 }
 
 type Auth interface {
-	Login(ctx context.Context, email string, password string, app_id int64) (bool, string, string, error)
+	Login(ctx context.Context, email string, password string, app_id int64, scope string) (bool, string, string, error)
 	Register(ctx context.Context, email string, password string, username string, app_id int64) (bool, int64, error)
 	Logout(ctx context.Context, token string, app_id int64) (bool, error)
-	RefreshToken(ctx context.Context, token string, app_id int64) (string, string, error)
+	RefreshToken(ctx context.Context, token string, app_id int64, scope string) (string, string, error)
 }
 
 func Register(gRPC *grpc.Server, auth Auth) {
@@ -37,8 +43,20 @@ func (s *Server) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.Log
 		return nil, status.Error(codes.InvalidArgument, "app_id is required")
 	}
 
-	success, access_token, refresh_token, err := s.Auth.Login(ctx, req.Email, req.Password, req.AppId)
+	success, access_token, refresh_token, err := s.Auth.Login(ctx, req.Email, req.Password, req.AppId, scopeFromMetadata(ctx))
 	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case errors.Is(err, auth.ErrLoginThrottled):
+			return nil, throttledStatus(err)
+		case errors.Is(err, auth.ErrAppAccessDenied), errors.Is(err, auth.ErrAppDisabled), errors.Is(err, auth.ErrGrantNotAllowed):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, auth.ErrInvalidScope):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, auth.ErrAccountLocked), errors.Is(err, auth.ErrAccountDisabled), errors.Is(err, auth.ErrAccountNotVerified):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	return &ssov1.LoginResponse{Success: success, AccessToken: access_token, RefreshToken: refresh_token}, nil
@@ -85,9 +103,42 @@ func (s *Server) RefreshToken(ctx context.Context, req *ssov1.RefreshRequest) (*
 		return nil, status.Error(codes.InvalidArgument, "app_id is required")
 	}
 
-	access_token, refresh_token, err := s.Auth.RefreshToken(ctx, req.RefreshToken, req.AppId)
+	access_token, refresh_token, err := s.Auth.RefreshToken(ctx, req.RefreshToken, req.AppId, scopeFromMetadata(ctx))
 	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrAppAccessDenied), errors.Is(err, auth.ErrAppDisabled), errors.Is(err, auth.ErrGrantNotAllowed):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, auth.ErrInvalidScope):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, auth.ErrAccountLocked), errors.Is(err, auth.ErrAccountDisabled), errors.Is(err, auth.ErrAccountNotVerified):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	return &ssov1.RefreshResponse{AccessToken: access_token, RefreshToken: refresh_token}, nil
 }
+
+// throttledStatus returns the ResourceExhausted status of a login rejected by the lockout policy,
+// with a RetryInfo detail telling the client when to try again
+func throttledStatus(err error) error {
+	st := status.New(codes.ResourceExhausted, auth.ErrLoginThrottled.Error())
+	var throttled *auth.ThrottledError
+	if !errors.As(err, &throttled) {
+		return st.Err()
+	}
+	detailed, detailErr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(throttled.RetryAfter)})
+	if detailErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// scopeFromMetadata returns the space separated scopes requested in the "scope" metadata
+// LoginRequest and RefreshRequest have no scope field, so clients pass it alongside the call
+func scopeFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	return strings.Join(md.Get("scope"), " ")
+}
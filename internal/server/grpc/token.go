@@ -0,0 +1,141 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"ssoq/internal/services/oauth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TokenServiceName is the name of the gRPC service issuing tokens to machine clients and exchanging tokens
+const TokenServiceName = "sso.Token"
+
+// TokenServer implements the sso.Token gRPC service
+type TokenServer struct {
+	OAuth TokenIssuer
+}
+
+// TokenIssuer is the OAuth 2.0 token endpoint used by TokenServer
+type TokenIssuer interface {
+	Token(ctx context.Context, req *oauth.TokenRequest) (*oauth.TokenResponse, error)
+}
+
+var tokenServiceDesc = grpc.ServiceDesc{
+	ServiceName: TokenServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(TokenServiceName, "ClientCredentials", (*TokenServer).ClientCredentials),
+		unaryMethod(TokenServiceName, "Exchange", (*TokenServer).Exchange),
+	},
+	Metadata: "token",
+}
+
+// RegisterToken registers the sso.Token service, it uses the JSON codec
+func RegisterToken(gRPC *grpc.Server, oauth TokenIssuer) {
+	gRPC.RegisterService(&tokenServiceDesc, &TokenServer{OAuth: oauth})
+}
+
+type ClientCredentialsRequest struct {
+	ClientId            string `json:"client_id"`
+	ClientSecret        string `json:"client_secret"`
+	ClientAssertionType string `json:"client_assertion_type"`
+	ClientAssertion     string `json:"client_assertion"`
+	Scope               string `json:"scope"`
+}
+
+// ExchangeRequest is a token exchange request of RFC 8693, token types default to access tokens
+type ExchangeRequest struct {
+	ClientId            string `json:"client_id"`
+	ClientSecret        string `json:"client_secret"`
+	ClientAssertionType string `json:"client_assertion_type"`
+	ClientAssertion     string `json:"client_assertion"`
+	SubjectToken        string `json:"subject_token"`
+	SubjectTokenType    string `json:"subject_token_type"`
+	ActorToken          string `json:"actor_token"`
+	ActorTokenType      string `json:"actor_token_type"`
+	Audience            string `json:"audience"`
+	Scope               string `json:"scope"`
+}
+
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+func (s *TokenServer) ClientCredentials(ctx context.Context, req *ClientCredentialsRequest) (*TokenResponse, error) {
+	res, err := s.OAuth.Token(ctx, &oauth.TokenRequest{
+		GrantType:           "client_credentials",
+		ClientID:            req.ClientId,
+		ClientSecret:        req.ClientSecret,
+		ClientAssertionType: req.ClientAssertionType,
+		ClientAssertion:     req.ClientAssertion,
+		Scope:               req.Scope,
+	})
+	if err != nil {
+		return nil, tokenError(err)
+	}
+	return &TokenResponse{
+		AccessToken: res.AccessToken,
+		TokenType:   res.TokenType,
+		ExpiresIn:   res.ExpiresIn,
+		Scope:       res.Scope,
+	}, nil
+}
+
+func (s *TokenServer) Exchange(ctx context.Context, req *ExchangeRequest) (*TokenResponse, error) {
+	subjectTokenType := req.SubjectTokenType
+	if subjectTokenType == "" {
+		subjectTokenType = oauth.TokenTypeAccessToken
+	}
+	actorTokenType := req.ActorTokenType
+	if actorTokenType == "" && req.ActorToken != "" {
+		actorTokenType = oauth.TokenTypeAccessToken
+	}
+
+	res, err := s.OAuth.Token(ctx, &oauth.TokenRequest{
+		GrantType:           oauth.GrantTypeTokenExchange,
+		ClientID:            req.ClientId,
+		ClientSecret:        req.ClientSecret,
+		ClientAssertionType: req.ClientAssertionType,
+		ClientAssertion:     req.ClientAssertion,
+		SubjectToken:        req.SubjectToken,
+		SubjectTokenType:    subjectTokenType,
+		ActorToken:          req.ActorToken,
+		ActorTokenType:      actorTokenType,
+		Audience:            req.Audience,
+		Scope:               req.Scope,
+	})
+	if err != nil {
+		return nil, tokenError(err)
+	}
+	return &TokenResponse{
+		AccessToken:     res.AccessToken,
+		IssuedTokenType: res.IssuedTokenType,
+		TokenType:       res.TokenType,
+		ExpiresIn:       res.ExpiresIn,
+		Scope:           res.Scope,
+	}, nil
+}
+
+// tokenError maps OAuth 2.0 errors to gRPC status errors
+func tokenError(err error) error {
+	var oauthErr *oauth.Error
+	if !errors.As(err, &oauthErr) {
+		return status.Error(codes.Internal, "internal error")
+	}
+	switch oauthErr.Code {
+	case oauth.ErrCodeInvalidClient:
+		return status.Error(codes.Unauthenticated, oauthErr.Description)
+	case oauth.ErrCodeUnauthorizedClient, oauth.ErrCodeAccessDenied, oauth.ErrCodeInvalidTarget:
+		return status.Error(codes.PermissionDenied, oauthErr.Description)
+	case oauth.ErrCodeServerError:
+		return status.Error(codes.Internal, oauthErr.Description)
+	}
+	return status.Error(codes.InvalidArgument, oauthErr.Description)
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"ssoq/internal/model"
+	"ssoq/internal/services/oauth"
+
+	"github.com/sirupsen/logrus"
+)
+
+// deviceAuthorization serves the device authorization endpoint of RFC 8628
+func (s *Server) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, &oauth.Error{Code: oauth.ErrCodeInvalidRequest, Description: "malformed form"})
+		return
+	}
+	client_id, secret := r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	if id, pass, ok := r.BasicAuth(); ok {
+		client_id, secret = id, pass
+	}
+
+	res, err := s.OAuth.DeviceAuthorization(r.Context(), client_id, secret, r.PostForm.Get("scope"))
+	if err != nil {
+		var oauthErr *oauth.Error
+		if !errors.As(err, &oauthErr) {
+			s.log.WithFields(logrus.Fields{
+				"client_id": client_id,
+				"error":     err,
+			}).Error("device authorization failed")
+			oauthErr = &oauth.Error{Code: oauth.ErrCodeServerError, Description: "internal error"}
+		}
+		writeTokenError(w, oauthErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":               res.DeviceCode,
+		"user_code":                 res.UserCode,
+		"verification_uri":          res.VerificationURI,
+		"verification_uri_complete": res.VerificationURIComplete,
+		"expires_in":                res.ExpiresIn,
+		"interval":                  res.Interval,
+	})
+}
+
+// device renders the verification page: the user code form, or the sign in form of a known user code
+func (s *Server) device(w http.ResponseWriter, r *http.Request) {
+	user_code := r.URL.Query().Get("user_code")
+	if user_code == "" {
+		s.renderDevice(w, r, nil, nil, "", "", "")
+		return
+	}
+	code, app, err := s.OAuth.PendingDevice(r.Context(), user_code)
+	if err != nil {
+		s.deviceError(w, r, err)
+		return
+	}
+	s.renderDevice(w, r, code, app, user_code, "", "")
+}
+
+// deviceSubmit approves the device authorization for the signed in user, or denies it
+func (s *Server) deviceSubmit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "malformed form")
+		return
+	}
+	user_code := r.PostForm.Get("user_code")
+	code, app, err := s.OAuth.PendingDevice(r.Context(), user_code)
+	if err != nil {
+		s.deviceError(w, r, err)
+		return
+	}
+	email := r.PostForm.Get("email")
+	if !validCSRF(r) {
+		s.renderDevice(w, r, code, app, user_code, email, "Your session has expired, please try again.")
+		return
+	}
+
+	if r.PostForm.Get("action") == "deny" {
+		err = s.OAuth.DenyDevice(r.Context(), user_code)
+	} else {
+		if s.rateLimited(r, deviceVerifyRule, app.Id) {
+			renderError(w, http.StatusTooManyRequests, "Too many sign in attempts, try again later.")
+			return
+		}
+		err = s.OAuth.ApproveDevice(r.Context(), user_code, email, r.PostForm.Get("password"))
+	}
+	if err != nil {
+		if msg := credentialMessage(err); msg != "" {
+			s.renderDevice(w, r, code, app, user_code, email, msg)
+			return
+		}
+		s.deviceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = deviceDonePage.Execute(w, map[string]interface{}{
+		"AppName":  app.Name,
+		"Approved": r.PostForm.Get("action") != "deny",
+	})
+}
+
+// deviceError shows the user code form again for unknown codes and an error page otherwise
+func (s *Server) deviceError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, oauth.ErrUnknownUserCode) {
+		s.renderDevice(w, r, nil, nil, "", "", "This code is invalid or has expired, check the code shown on your device.")
+		return
+	}
+	s.log.WithField("error", err).Error("device verification failed")
+	renderError(w, http.StatusInternalServerError, "internal error")
+}
+
+// renderDevice renders the verification page, the sign in form is shown when code is known
+func (s *Server) renderDevice(w http.ResponseWriter, r *http.Request, code *model.DeviceCode, app *model.App, user_code string, email string, errMsg string) {
+	token, err := setCSRFCookie(w, r)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	data := map[string]interface{}{
+		"Action":    r.URL.Path,
+		"CSRFToken": token,
+		"UserCode":  user_code,
+		"Email":     email,
+		"Error":     errMsg,
+	}
+	if code != nil {
+		data["AppName"] = app.Name
+		data["Scopes"] = code.Scopes
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	if errMsg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	if err := devicePage.Execute(w, data); err != nil {
+		s.log.WithField("error", err).Error("failed to render device page")
+	}
+}
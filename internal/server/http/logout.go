@@ -0,0 +1,76 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"ssoq/internal/services/oauth"
+)
+
+// logoutParams are the request parameters carried through the logout confirmation form
+var logoutParams = []string{"id_token_hint", "post_logout_redirect_uri", "state"}
+
+// logout serves the end session endpoint: it ends the session of the hint and renders the front-channel logout page
+// When the logout cannot be attributed to the browser's own session the user is asked to confirm it first
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "malformed request")
+		return
+	}
+
+	req := &oauth.EndSessionRequest{
+		IDTokenHint:           r.Form.Get("id_token_hint"),
+		PostLogoutRedirectURI: r.Form.Get("post_logout_redirect_uri"),
+		State:                 r.Form.Get("state"),
+		Confirmed:             r.Method == http.MethodPost && r.PostForm.Get("confirm") != "" && validCSRF(r),
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		req.BrowserSession = cookie.Value
+	}
+	res, err := s.OAuth.EndSession(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, oauth.ErrLogoutConfirmationRequired) {
+			s.renderLogoutConfirm(w, r)
+			return
+		}
+		var oauthErr *oauth.Error
+		if errors.As(err, &oauthErr) {
+			renderError(w, http.StatusBadRequest, oauthErr.Description)
+			return
+		}
+		s.log.WithField("error", err).Error("logout failed")
+		renderError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	setSessionCookie(w, r, "")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := logoutPage.Execute(w, res); err != nil {
+		s.log.WithField("error", err).Error("failed to render logout page")
+	}
+}
+
+// renderLogoutConfirm renders the logout confirmation form with a fresh CSRF token
+func (s *Server) renderLogoutConfirm(w http.ResponseWriter, r *http.Request) {
+	token, err := setCSRFCookie(w, r)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	params := make(map[string]string, len(logoutParams))
+	for _, name := range logoutParams {
+		params[name] = r.Form.Get(name)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	err = logoutConfirmPage.Execute(w, map[string]interface{}{
+		"Action":    r.URL.Path,
+		"Params":    params,
+		"CSRFToken": token,
+	})
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to render logout confirmation page")
+	}
+}
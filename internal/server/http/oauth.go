@@ -0,0 +1,442 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"ssoq/internal/services/oauth"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// csrfCookie is the name of the double-submit cookie protecting the login form
+const csrfCookie = "sso_csrf"
+
+// sessionCookie is the name of the cookie recording the user signed in at the provider in this browser
+const sessionCookie = "sso_session"
+
+// Server serves the OAuth 2.0 HTTP endpoints
+type Server struct {
+	log        *logrus.Logger
+	OAuth      OAuth
+	limiter    Limiter
+	rateLimits map[string]RateLimitRule
+}
+
+// OAuth is the OAuth 2.0 authorization server used by Server
+type OAuth interface {
+	ValidateClient(ctx context.Context, req *oauth.AuthorizeRequest) (*model.App, error)
+	ValidateRequest(req *oauth.AuthorizeRequest, app *model.App) error
+	Authorize(ctx context.Context, req *oauth.AuthorizeRequest, app *model.App, email string, password string) (string, string, error)
+	Token(ctx context.Context, req *oauth.TokenRequest) (*oauth.TokenResponse, error)
+	UserInfo(ctx context.Context, token string) (map[string]interface{}, error)
+	Discovery() map[string]interface{}
+	JWKS() ([]byte, error)
+	DeviceAuthorization(ctx context.Context, client_id string, secret string, scope string) (*oauth.DeviceAuthorizationResponse, error)
+	PendingDevice(ctx context.Context, user_code string) (*model.DeviceCode, *model.App, error)
+	ApproveDevice(ctx context.Context, user_code string, email string, password string) error
+	DenyDevice(ctx context.Context, user_code string) error
+	EndSession(ctx context.Context, req *oauth.EndSessionRequest) (*oauth.EndSessionResponse, error)
+}
+
+// Register registers the OAuth 2.0 and OpenID Connect endpoints on mux
+// The sign in forms are limited by rateLimits, keyed by the Authorize and DeviceVerify rule names
+func Register(mux *http.ServeMux, log *logrus.Logger, oauth OAuth, limiter Limiter, rateLimits map[string]RateLimitRule) {
+	s := &Server{log: log, OAuth: oauth, limiter: limiter, rateLimits: rateLimits}
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /authorize", s.authorizeSubmit)
+	mux.HandleFunc("POST /token", s.token)
+	mux.HandleFunc("GET /userinfo", s.userInfo)
+	mux.HandleFunc("POST /userinfo", s.userInfo)
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET /.well-known/jwks.json", s.jwks)
+	mux.HandleFunc("POST /device_authorization", s.deviceAuthorization)
+	mux.HandleFunc("GET /device", s.device)
+	mux.HandleFunc("POST /device", s.deviceSubmit)
+	mux.HandleFunc("GET /logout", s.logout)
+	mux.HandleFunc("POST /logout", s.logout)
+}
+
+// authorizeParams are the request parameters carried through the login form
+var authorizeParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method", "nonce"}
+
+func parseAuthorizeRequest(form url.Values) *oauth.AuthorizeRequest {
+	return &oauth.AuthorizeRequest{
+		ResponseType:        form.Get("response_type"),
+		ClientID:            form.Get("client_id"),
+		RedirectURI:         form.Get("redirect_uri"),
+		Scope:               form.Get("scope"),
+		State:               form.Get("state"),
+		CodeChallenge:       form.Get("code_challenge"),
+		CodeChallengeMethod: form.Get("code_challenge_method"),
+		Nonce:               form.Get("nonce"),
+	}
+}
+
+// validateAuthorize validates an authorization request and answers it when it is invalid
+// It returns nil when the response has already been written
+func (s *Server) validateAuthorize(w http.ResponseWriter, r *http.Request, req *oauth.AuthorizeRequest) *model.App {
+	app, err := s.OAuth.ValidateClient(r.Context(), req)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, errorDescription(err))
+		return nil
+	}
+	if err := s.OAuth.ValidateRequest(req, app); err != nil {
+		redirectError(w, r, req, err)
+		return nil
+	}
+	return app
+}
+
+// authorize renders the login page of a valid authorization request
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	req := parseAuthorizeRequest(r.URL.Query())
+	app := s.validateAuthorize(w, r, req)
+	if app == nil {
+		return
+	}
+	s.renderLogin(w, r, app, "", "")
+}
+
+// authorizeSubmit checks the submitted credentials and redirects back to the client with a code
+func (s *Server) authorizeSubmit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "malformed form")
+		return
+	}
+	req := parseAuthorizeRequest(r.PostForm)
+	app := s.validateAuthorize(w, r, req)
+	if app == nil {
+		return
+	}
+	if !validCSRF(r) {
+		s.renderLogin(w, r, app, r.PostForm.Get("email"), "Your session has expired, please try again.")
+		return
+	}
+	if s.rateLimited(r, authorizeRule, app.Id) {
+		renderError(w, http.StatusTooManyRequests, "Too many sign in attempts, try again later.")
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	code, session, err := s.OAuth.Authorize(r.Context(), req, app, email, r.PostForm.Get("password"))
+	if err != nil {
+		if msg := credentialMessage(err); msg != "" {
+			s.renderLogin(w, r, app, email, msg)
+			return
+		}
+		s.log.WithFields(logrus.Fields{
+			"app_id": app.Id,
+			"error":  err,
+		}).Error("authorization failed")
+		redirectError(w, r, req, err)
+		return
+	}
+
+	setSessionCookie(w, r, session)
+	redirect(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+// token serves the token endpoint
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, &oauth.Error{Code: oauth.ErrCodeInvalidRequest, Description: "malformed form"})
+		return
+	}
+	req := &oauth.TokenRequest{
+		GrantType:           r.PostForm.Get("grant_type"),
+		ClientID:            r.PostForm.Get("client_id"),
+		ClientSecret:        r.PostForm.Get("client_secret"),
+		ClientAssertionType: r.PostForm.Get("client_assertion_type"),
+		ClientAssertion:     r.PostForm.Get("client_assertion"),
+		Code:                r.PostForm.Get("code"),
+		RedirectURI:         r.PostForm.Get("redirect_uri"),
+		CodeVerifier:        r.PostForm.Get("code_verifier"),
+		RefreshToken:        r.PostForm.Get("refresh_token"),
+		DeviceCode:          r.PostForm.Get("device_code"),
+		SubjectToken:        r.PostForm.Get("subject_token"),
+		SubjectTokenType:    r.PostForm.Get("subject_token_type"),
+		ActorToken:          r.PostForm.Get("actor_token"),
+		ActorTokenType:      r.PostForm.Get("actor_token_type"),
+		Audience:            r.PostForm.Get("audience"),
+		RequestedTokenType:  r.PostForm.Get("requested_token_type"),
+		Scope:               r.PostForm.Get("scope"),
+	}
+	if id, secret, ok := r.BasicAuth(); ok {
+		req.ClientID, req.ClientSecret = id, secret
+	}
+
+	res, err := s.OAuth.Token(auth.WithClientInfo(r.Context(), clientInfo(r)), req)
+	if err != nil {
+		var oauthErr *oauth.Error
+		if !errors.As(err, &oauthErr) {
+			s.log.WithFields(logrus.Fields{
+				"grant_type": req.GrantType,
+				"client_id":  req.ClientID,
+				"error":      err,
+			}).Error("token request failed")
+			oauthErr = &oauth.Error{Code: oauth.ErrCodeServerError, Description: "internal error"}
+		}
+		writeTokenError(w, oauthErr)
+		return
+	}
+
+	body := map[string]interface{}{
+		"access_token": res.AccessToken,
+		"token_type":   res.TokenType,
+		"expires_in":   res.ExpiresIn,
+	}
+	if res.RefreshToken != "" {
+		body["refresh_token"] = res.RefreshToken
+	}
+	if res.Scope != "" {
+		body["scope"] = res.Scope
+	}
+	if res.IDToken != "" {
+		body["id_token"] = res.IDToken
+	}
+	if res.IssuedTokenType != "" {
+		body["issued_token_type"] = res.IssuedTokenType
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// userInfo serves the OpenID Connect userinfo endpoint for a bearer access token
+func (s *Server) userInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error":             oauth.ErrCodeInvalidRequest,
+			"error_description": "bearer access token is required",
+		})
+		return
+	}
+
+	claims, err := s.OAuth.UserInfo(r.Context(), token)
+	if err != nil {
+		var oauthErr *oauth.Error
+		if !errors.As(err, &oauthErr) {
+			s.log.WithField("error", err).Error("userinfo request failed")
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": oauth.ErrCodeServerError})
+			return
+		}
+		status := http.StatusUnauthorized
+		if oauthErr.Code == oauth.ErrCodeInsufficientScope {
+			status = http.StatusForbidden
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sso", error="`+oauthErr.Code+`"`)
+		writeJSON(w, status, map[string]string{
+			"error":             oauthErr.Code,
+			"error_description": oauthErr.Description,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, claims)
+}
+
+// discovery serves the OpenID Connect discovery document
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.OAuth.Discovery())
+}
+
+// jwks serves the public keys verifying ID tokens
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	body, err := s.OAuth.JWKS()
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to encode jwks")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": oauth.ErrCodeServerError})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// renderLogin renders the login form with a fresh CSRF token
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, app *model.App, email string, errMsg string) {
+	token, err := setCSRFCookie(w, r)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	source := r.URL.Query()
+	if r.Method == http.MethodPost {
+		source = r.PostForm
+	}
+	params := make(map[string]string, len(authorizeParams))
+	for _, name := range authorizeParams {
+		params[name] = source.Get(name)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	if errMsg != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	err = loginPage.Execute(w, map[string]interface{}{
+		"AppName":   app.Name,
+		"Action":    r.URL.Path,
+		"Params":    params,
+		"CSRFToken": token,
+		"Email":     email,
+		"Error":     errMsg,
+	})
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to render login page")
+	}
+}
+
+// setCSRFCookie sets a fresh double-submit cookie and returns the token the form must echo
+func setCSRFCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	token, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// setSessionCookie records the browser session of the user that signed in, an empty value clears it
+func setSessionCookie(w http.ResponseWriter, r *http.Request, session string) {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if session == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// validCSRF compares the form token with the double-submit cookie
+func validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf_token"))) == 1
+}
+
+// credentialMessage returns the message shown on the login page for a credential error, or "" for other errors
+func credentialMessage(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return "Invalid email or password."
+	case errors.Is(err, auth.ErrLoginThrottled):
+		return "Too many failed sign in attempts, try again later."
+	case errors.Is(err, auth.ErrAccountLocked):
+		return "Your account is locked."
+	case errors.Is(err, auth.ErrAccountDisabled):
+		return "Your account has been disabled."
+	case errors.Is(err, auth.ErrAccountNotVerified):
+		return "Your account is not verified yet."
+	case errors.Is(err, auth.ErrAppAccessDenied):
+		return "Your account has no access to this application."
+	}
+	return ""
+}
+
+// redirect sends the user agent to redirectURI with params added to its query
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+	q := u.Query()
+	for name, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			q.Set(name, values[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// redirectError sends an OAuth error back to the validated redirect_uri of the request
+func redirectError(w http.ResponseWriter, r *http.Request, req *oauth.AuthorizeRequest, err error) {
+	code := oauth.ErrCodeServerError
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		code = oauthErr.Code
+	}
+	redirect(w, r, req.RedirectURI, url.Values{
+		"error":             {code},
+		"error_description": {errorDescription(err)},
+		"state":             {req.State},
+	})
+}
+
+func errorDescription(err error) string {
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		return oauthErr.Description
+	}
+	return "internal error"
+}
+
+func renderError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = errorPage.Execute(w, msg)
+}
+
+// clientInfo returns the user agent and remote IP of a request, recorded in the sessions it opens
+func clientInfo(r *http.Request) auth.ClientInfo {
+	return auth.ClientInfo{UserAgent: r.UserAgent(), IP: remoteIP(r)}
+}
+
+// writeTokenError writes an RFC 6749 error response of the token endpoint
+func writeTokenError(w http.ResponseWriter, err *oauth.Error) {
+	status := http.StatusBadRequest
+	switch err.Code {
+	case oauth.ErrCodeInvalidClient:
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+	case oauth.ErrCodeServerError:
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, map[string]string{
+		"error":             err.Code,
+		"error_description": err.Description,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// randomString returns 32 random bytes encoded as unpadded base64url
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
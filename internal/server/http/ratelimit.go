@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rule names of the sign in forms in the rate limit rules, next to the gRPC methods
+const (
+	authorizeRule    = "Authorize"
+	deviceVerifyRule = "DeviceVerify"
+)
+
+// Limiter takes tokens from token buckets identified by key
+type Limiter interface {
+	TakeRateToken(ctx context.Context, key string, rate float64, burst int) (bool, error)
+}
+
+// RateLimitRule limits a sign in form by remote address and by app_id
+// Rates are tokens per second, a zero rate disables the corresponding limit
+type RateLimitRule struct {
+	IPRate   float64
+	IPBurst  int
+	AppRate  float64
+	AppBurst int
+}
+
+// rateLimited reports whether a submission of the form named rule for app_id is over its limits
+// Keys are built like the gRPC interceptor builds them, limiter failures let the request through
+func (s *Server) rateLimited(r *http.Request, rule string, app_id int64) bool {
+	limits, ok := s.rateLimits[rule]
+	if !ok || s.limiter == nil {
+		return false
+	}
+	if limits.IPRate > 0 {
+		if ip := remoteIP(r); ip != "" && !s.allow(r.Context(), fmt.Sprintf("ip:%s:%s", rule, ip), limits.IPRate, limits.IPBurst) {
+			return true
+		}
+	}
+	if limits.AppRate > 0 && app_id != 0 {
+		if !s.allow(r.Context(), fmt.Sprintf("app:%s:%d", rule, app_id), limits.AppRate, limits.AppBurst) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow takes a token for key and fails open when the limiter itself fails
+func (s *Server) allow(ctx context.Context, key string, rate float64, burst int) bool {
+	ok, err := s.limiter.TakeRateToken(ctx, key, rate, burst)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"key":   key,
+			"error": err,
+		}).Error("rate limiter failed, letting request through")
+		return true
+	}
+	if !ok {
+		s.log.WithField("key", key).Warn("request rejected by rate limiter")
+	}
+	return ok
+}
+
+// remoteIP returns the IP address of the client of r without the port
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeLimiter records the keys it is asked for and rejects the keys in empty
+type fakeLimiter struct {
+	empty []string
+	err   error
+	keys  []string
+}
+
+func (f *fakeLimiter) TakeRateToken(ctx context.Context, key string, rate float64, burst int) (bool, error) {
+	f.keys = append(f.keys, key)
+	if f.err != nil {
+		return false, f.err
+	}
+	return !slices.Contains(f.empty, key), nil
+}
+
+func TestRateLimited(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	rules := map[string]RateLimitRule{
+		authorizeRule:    {IPRate: 1, IPBurst: 10, AppRate: 50, AppBurst: 100},
+		deviceVerifyRule: {IPRate: 1, IPBurst: 10},
+	}
+
+	tests := []struct {
+		name     string
+		rule     string
+		limiter  *fakeLimiter
+		want     bool
+		wantKeys []string
+	}{
+		{
+			name:     "under both limits",
+			rule:     authorizeRule,
+			limiter:  &fakeLimiter{},
+			wantKeys: []string{"ip:Authorize:192.0.2.1", "app:Authorize:7"},
+		},
+		{
+			name:     "address over limit",
+			rule:     authorizeRule,
+			limiter:  &fakeLimiter{empty: []string{"ip:Authorize:192.0.2.1"}},
+			want:     true,
+			wantKeys: []string{"ip:Authorize:192.0.2.1"},
+		},
+		{
+			name:     "app over limit",
+			rule:     authorizeRule,
+			limiter:  &fakeLimiter{empty: []string{"app:Authorize:7"}},
+			want:     true,
+			wantKeys: []string{"ip:Authorize:192.0.2.1", "app:Authorize:7"},
+		},
+		{
+			name:     "app limit disabled",
+			rule:     deviceVerifyRule,
+			limiter:  &fakeLimiter{empty: []string{"app:DeviceVerify:7"}},
+			wantKeys: []string{"ip:DeviceVerify:192.0.2.1"},
+		},
+		{
+			name:    "no rule",
+			rule:    "Unknown",
+			limiter: &fakeLimiter{},
+		},
+		{
+			name:     "limiter failure lets the request through",
+			rule:     authorizeRule,
+			limiter:  &fakeLimiter{err: errors.New("database is down")},
+			wantKeys: []string{"ip:Authorize:192.0.2.1", "app:Authorize:7"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{log: log, limiter: tt.limiter, rateLimits: rules}
+			r := httptest.NewRequest("POST", "/authorize", nil)
+			r.RemoteAddr = "192.0.2.1:50412"
+
+			if got := s.rateLimited(r, tt.rule, 7); got != tt.want {
+				t.Errorf("rateLimited() = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(tt.limiter.keys, tt.wantKeys) {
+				t.Errorf("limiter keys = %v, want %v", tt.limiter.keys, tt.wantKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package http
+
+import "html/template"
+
+// loginPage is the minimal server-rendered login form of the authorization endpoint
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in to {{.AppName}}</title>
+<style>
+body { font-family: sans-serif; max-width: 22rem; margin: 4rem auto; padding: 0 1rem; }
+label, input, button { display: block; width: 100%; box-sizing: border-box; }
+input { margin: .25rem 0 1rem; padding: .5rem; }
+button { padding: .6rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Sign in</h1>
+<p>to continue to <strong>{{.AppName}}</strong></p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<label for="email">Email</label>
+<input id="email" name="email" type="email" autocomplete="username" value="{{.Email}}" required autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// devicePage is the verification page of the device authorization grant
+// Without AppName it asks for the user code, with it the user signs in to approve or deny the device
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect a device</title>
+<style>
+body { font-family: sans-serif; max-width: 22rem; margin: 4rem auto; padding: 0 1rem; }
+label, input, button { display: block; width: 100%; box-sizing: border-box; }
+input { margin: .25rem 0 1rem; padding: .5rem; }
+button { padding: .6rem; margin-bottom: .5rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Connect a device</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .AppName}}<p><strong>{{.AppName}}</strong> is requesting access to your account with code <strong>{{.UserCode}}</strong>.</p>
+{{if .Scopes}}<p>Requested access: {{range $i, $s := .Scopes}}{{if $i}}, {{end}}{{$s}}{{end}}</p>{{end}}
+<p>Only continue if this code is shown on your device.</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="user_code" value="{{.UserCode}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<label for="email">Email</label>
+<input id="email" name="email" type="email" autocomplete="username" value="{{.Email}}" autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password">
+<button type="submit" name="action" value="approve">Sign in and allow</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</form>
+{{else}}<p>Enter the code shown on your device.</p>
+<form method="get" action="{{.Action}}">
+<label for="user_code">Code</label>
+<input id="user_code" name="user_code" autocomplete="off" autocapitalize="characters" value="{{.UserCode}}" required autofocus>
+<button type="submit">Continue</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// deviceDonePage tells the user to return to the device after a decision
+var deviceDonePage = template.Must(template.New("deviceDone").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+{{if .Approved}}<h1>Device connected</h1>
+<p>You have allowed <strong>{{.AppName}}</strong>, you can return to your device.</p>
+{{else}}<h1>Request denied</h1>
+<p>The device was not given access to your account.</p>
+{{end}}</body>
+</html>
+`))
+
+// logoutConfirmPage asks the user to confirm a logout request that the browser cannot be trusted with
+var logoutConfirmPage = template.Must(template.New("logoutConfirm").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign out</title>
+<style>
+body { font-family: sans-serif; max-width: 22rem; margin: 4rem auto; padding: 0 1rem; }
+button { display: block; width: 100%; padding: .6rem; }
+</style>
+</head>
+<body>
+<h1>Sign out</h1>
+<p>Do you want to sign out?</p>
+<form method="post" action="{{.Action}}">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit" name="confirm" value="1">Sign out</button>
+</form>
+</body>
+</html>
+`))
+
+// logoutPage loads the front-channel logout URI of the app of the ended session in a hidden iframe
+// and then follows RedirectURI when the logout request had a post_logout_redirect_uri
+var logoutPage = template.Must(template.New("logout").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+{{if .RedirectURI}}<meta http-equiv="refresh" content="2; url={{.RedirectURI}}">
+{{end}}<title>Signed out</title>
+<style>
+body { font-family: sans-serif; max-width: 22rem; margin: 4rem auto; padding: 0 1rem; }
+iframe { display: none; }
+</style>
+</head>
+<body>
+<h1>Signed out</h1>
+<p>You have been signed out of <strong>{{.AppName}}</strong>.</p>
+{{if .RedirectURI}}<p><a href="{{.RedirectURI}}">Continue</a></p>
+{{end}}{{range .FrontchannelURIs}}<iframe src="{{.}}" title="logout"></iframe>
+{{end}}</body>
+</html>
+`))
+
+// errorPage reports errors that cannot be sent back to the client
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Error</title></head>
+<body>
+<h1>Request cannot be processed</h1>
+<p>{{.}}</p>
+</body>
+</html>
+`))
@@ -0,0 +1,54 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+	"ssoq/internal/services/attributes"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AttributeStore interface defines methods for reading and updating the attributes of a user in an app
+type AttributeStore interface {
+	attributes.Store
+	App(ctx context.Context, app_id int64) (*model.App, error)
+}
+
+// GetAttributes returns the attributes of the user in an app
+func (a *Account) GetAttributes(ctx context.Context, user_id int64, app_id int64) (map[string]interface{}, error) {
+	const op = "account.GetAttributes"
+
+	attrs, err := a.attributes.UserAttributes(ctx, user_id, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return attrs, nil
+}
+
+// UpdateAttributes applies patch to the attributes of the user in an app, a nil value removes the attribute
+// Only attributes the schema of the app lets users write may be patched
+func (a *Account) UpdateAttributes(ctx context.Context, user_id int64, app_id int64, patch map[string]interface{}) (map[string]interface{}, error) {
+	const op = "account.UpdateAttributes"
+
+	app, err := a.attributes.App(ctx, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	attrs, err := attributes.Update(ctx, a.attributes, app, user_id, patch, attributes.WriterUser)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"app_id":  app_id,
+			"op":      op,
+			"error":   err,
+		}).Warn("attribute update rejected")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"user_id": user_id,
+		"app_id":  app_id,
+	}).Info("user attributes updated")
+	return attrs, nil
+}
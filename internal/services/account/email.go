@@ -0,0 +1,213 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"ssoq/internal/mail"
+	"ssoq/internal/model"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EmailChangeStore interface defines methods for recording and confirming email changes
+type EmailChangeStore interface {
+	GetUser(ctx context.Context, tenant_app_id int64, email string) (*model.User, error)
+	SaveEmailChange(ctx context.Context, user_id int64, email string, tokenHash string, expiresAt time.Time) error
+	ConfirmEmailChange(ctx context.Context, user_id int64, tokenHash string) (string, string, error)
+}
+
+// PasswordVerifier interface defines how a signed in user is re-authenticated before a sensitive change
+type PasswordVerifier interface {
+	VerifyPassword(ctx context.Context, user_id int64, password string) error
+}
+
+// Mailer interface defines how emails are sent to users
+type Mailer interface {
+	Send(ctx context.Context, msg mail.Message) error
+}
+
+// EmailChangePolicy describes the email change flow
+// TTL is how long the confirmation code sent to the new address is valid
+// ConfirmURL is a page of the client confirming the change, the code is appended as the token query parameter
+// Logout signs the user out everywhere once the change is confirmed
+type EmailChangePolicy struct {
+	TTL        time.Duration
+	ConfirmURL string
+	Logout     bool
+}
+
+// ChangeEmail starts changing the email of the user to email and returns when the confirmation code expires
+// The current password of the user is required, so an access token alone cannot move the login identifier
+// The code is sent to the new address, the old address is told about the change, nothing changes until ConfirmEmailChange
+// An address already registered gets a notice instead of a code and the request answers the same,
+// so the change cannot be used to find out which addresses are registered
+func (a *Account) ChangeEmail(ctx context.Context, user_id int64, email string, password string) (time.Time, error) {
+	const op = "account.ChangeEmail"
+
+	user, err := a.GetProfile(ctx, user_id)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := validateEmail(email); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if email == user.Email {
+		return time.Time{}, fmt.Errorf("%s: email is unchanged: %w", op, ErrInvalidArgument)
+	}
+	if err := a.passwords.VerifyPassword(ctx, user_id, password); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	expiresAt := time.Now().Add(a.emailPolicy.TTL)
+	// The swap checks the unique index again, this only spares a confirmation that is bound to fail
+	taken, err := a.emailChanges.GetUser(ctx, user.TenantAppId, email)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if taken != nil {
+		if err := a.sendChangeMail(ctx, user_id, inUseMessage(email), changeNoticeMessage(user.Email, email)); err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+		a.log.WithField("user_id", user_id).Info("email change requested to an address in use")
+		return expiresAt, nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.emailChanges.SaveEmailChange(ctx, user_id, email, hashToken(token), expiresAt); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.sendChangeMail(ctx, user_id, a.confirmationMessage(email, token, expiresAt), changeNoticeMessage(user.Email, email)); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"user_id":    user_id,
+		"expires_at": expiresAt,
+	}).Info("email change requested")
+	return expiresAt, nil
+}
+
+// ConfirmEmailChange swaps the email of the user for the pending new email confirmed by token
+// When the policy says so, the user is then signed out of every session
+func (a *Account) ConfirmEmailChange(ctx context.Context, user_id int64, token string) (*model.User, error) {
+	const op = "account.ConfirmEmailChange"
+
+	old, email, err := a.emailChanges.ConfirmEmailChange(ctx, user_id, hashToken(token))
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Warn("email change confirmation rejected")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithFields(logrus.Fields{
+		"user_id":   user_id,
+		"old_email": old,
+		"new_email": email,
+	}).Info("email changed")
+
+	if a.emailPolicy.Logout {
+		if err := a.sessions.LogoutAll(ctx, user_id); err != nil {
+			a.log.WithFields(logrus.Fields{
+				"user_id": user_id,
+				"op":      op,
+				"error":   err,
+			}).Error("failed to sign out after email change")
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	user, err := a.GetProfile(ctx, user_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return user, nil
+}
+
+// validateEmail accepts a bare address such as user@example.com, display names and comments are rejected
+func validateEmail(email string) error {
+	if len(email) > maxProfileField {
+		return fmt.Errorf("email is longer than %d bytes: %w", maxProfileField, ErrInvalidArgument)
+	}
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return fmt.Errorf("invalid email %q: %w", email, ErrInvalidArgument)
+	}
+	return nil
+}
+
+func (a *Account) confirmationMessage(email string, token string, expiresAt time.Time) mail.Message {
+	var body strings.Builder
+	body.WriteString("Someone asked to use this address for their account.\n\n")
+	if a.emailPolicy.ConfirmURL != "" {
+		link := a.emailPolicy.ConfirmURL
+		if strings.Contains(link, "?") {
+			link += "&"
+		} else {
+			link += "?"
+		}
+		fmt.Fprintf(&body, "To confirm the change, open %stoken=%s\n", link, url.QueryEscape(token))
+	} else {
+		fmt.Fprintf(&body, "To confirm the change, enter this code: %s\n", token)
+	}
+	fmt.Fprintf(&body, "\nThe code is valid until %s. If you did not ask for it, ignore this email.\n", expiresAt.UTC().Format(time.RFC1123))
+	return mail.Message{To: email, Subject: "Confirm your new email address", Body: body.String()}
+}
+
+// inUseMessage tells the owner of an address that someone tried to move another account to it
+func inUseMessage(email string) mail.Message {
+	body := "Someone asked to use this address for their account, but it is already registered.\n\n" +
+		"Nothing was changed. If it was you, sign in with this address instead. Otherwise ignore this email.\n"
+	return mail.Message{To: email, Subject: "Your email address is already in use", Body: body}
+}
+
+func changeNoticeMessage(old string, email string) mail.Message {
+	body := fmt.Sprintf("A change of the email of your account to %s was requested.\n\n"+
+		"The email is only changed once the new address is confirmed. "+
+		"If you did not ask for it, change your password and sign out of all sessions.\n", email)
+	return mail.Message{To: old, Subject: "Your email address is being changed", Body: body}
+}
+
+// sendChangeMail sends msg to the new address and the notice to the old address of an email change request
+// Only a failure of msg is returned: without it the request cannot go on, while the notice is informative
+func (a *Account) sendChangeMail(ctx context.Context, user_id int64, msg mail.Message, notice mail.Message) error {
+	if err := a.mailer.Send(ctx, msg); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"error":   err,
+		}).Error("failed to send email change mail to the new address")
+		return err
+	}
+	if err := a.mailer.Send(ctx, notice); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"error":   err,
+		}).Error("failed to notify the old address of an email change")
+	}
+	return nil
+}
+
+// randomToken returns 32 random bytes encoded as unpadded base64url
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a token, confirmation codes are only stored hashed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
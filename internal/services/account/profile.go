@@ -0,0 +1,122 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+	"ssoq/internal/storage"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones are validated even where the system has no zoneinfo
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/text/language"
+)
+
+// ErrInvalidArgument is returned when a profile update is rejected by validation
+var ErrInvalidArgument = errors.New("invalid argument")
+
+const (
+	maxProfileField  = 255
+	maxMetadataKeys  = 32
+	maxMetadataKey   = 64
+	maxMetadataValue = 1024
+)
+
+// ProfileStore interface defines methods for reading and updating the profile of a user
+type ProfileStore interface {
+	GetUserByID(ctx context.Context, id int64) (*model.User, error)
+	UpdateProfile(ctx context.Context, user *model.User, version time.Time) (time.Time, error)
+}
+
+// GetProfile returns the user with its profile, its UpdatedAt is the version to send with UpdateProfile
+func (a *Account) GetProfile(ctx context.Context, user_id int64) (*model.User, error) {
+	const op = "account.GetProfile"
+
+	user, err := a.profiles.GetUserByID(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to get profile")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if user == nil || user.Status == model.UserStatusDeleted {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return user, nil
+}
+
+// UpdateProfile applies update to the profile of the user if the profile is still at version
+// It fails with storage.ErrUserChanged when the user was updated since, the client then reads the profile again
+func (a *Account) UpdateProfile(ctx context.Context, user_id int64, update model.ProfileUpdate, version time.Time) (*model.User, error) {
+	const op = "account.UpdateProfile"
+
+	user, err := a.GetProfile(ctx, user_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if update.Username != nil {
+		user.Username = strings.TrimSpace(*update.Username)
+	}
+	if update.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*update.DisplayName)
+	}
+	if update.Locale != nil {
+		user.Locale = *update.Locale
+	}
+	if update.Timezone != nil {
+		user.Timezone = *update.Timezone
+	}
+	if update.Metadata != nil {
+		user.Metadata = update.Metadata
+	}
+	if err := validateProfile(user); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user.UpdatedAt, err = a.profiles.UpdateProfile(ctx, user, version)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithField("user_id", user_id).Info("profile updated")
+	return user, nil
+}
+
+// validateProfile checks the profile fields of user and canonicalizes its locale
+func validateProfile(user *model.User) error {
+	if user.Username == "" {
+		return fmt.Errorf("username is required: %w", ErrInvalidArgument)
+	}
+	if utf8.RuneCountInString(user.Username) > maxProfileField || utf8.RuneCountInString(user.DisplayName) > maxProfileField {
+		return fmt.Errorf("username and display_name are limited to %d characters: %w", maxProfileField, ErrInvalidArgument)
+	}
+	if user.Locale != "" {
+		tag, err := language.Parse(user.Locale)
+		if err != nil {
+			return fmt.Errorf("locale %q is not a BCP 47 language tag: %w", user.Locale, ErrInvalidArgument)
+		}
+		user.Locale = tag.String()
+	}
+	if user.Timezone != "" {
+		// LoadLocation also accepts "Local", which means nothing to other services
+		if _, err := time.LoadLocation(user.Timezone); err != nil || user.Timezone == "Local" {
+			return fmt.Errorf("timezone %q is not an IANA time zone: %w", user.Timezone, ErrInvalidArgument)
+		}
+	}
+	if len(user.Metadata) > maxMetadataKeys {
+		return fmt.Errorf("metadata is limited to %d keys: %w", maxMetadataKeys, ErrInvalidArgument)
+	}
+	for key, value := range user.Metadata {
+		if key == "" || len(key) > maxMetadataKey {
+			return fmt.Errorf("metadata keys must have 1 to %d bytes: %w", maxMetadataKey, ErrInvalidArgument)
+		}
+		if len(value) > maxMetadataValue {
+			return fmt.Errorf("metadata value of %q exceeds %d bytes: %w", key, maxMetadataValue, ErrInvalidArgument)
+		}
+	}
+	return nil
+}
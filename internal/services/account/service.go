@@ -0,0 +1,107 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Account represents the self-service of signed in users over their own account
+type Account struct {
+	log            *logrus.Logger
+	impersonations ImpersonationProvider
+	sessions       SessionRevoker
+	sessionList    SessionProvider
+	profiles       ProfileStore
+	attributes     AttributeStore
+	emailChanges   EmailChangeStore
+	passwords      PasswordVerifier
+	mailer         Mailer
+	emailPolicy    EmailChangePolicy
+}
+
+// ImpersonationProvider interface defines methods for retrieving the impersonations of a user
+type ImpersonationProvider interface {
+	Impersonations(ctx context.Context, user_id int64) ([]model.Impersonation, error)
+}
+
+// SessionRevoker interface defines how sessions of a user are revoked
+type SessionRevoker interface {
+	LogoutAll(ctx context.Context, user_id int64) error
+	RevokeSession(ctx context.Context, user_id int64, session_id int64) error
+}
+
+// SessionProvider interface defines methods for retrieving the sessions of a user
+type SessionProvider interface {
+	Sessions(ctx context.Context, user_id int64) ([]model.Session, error)
+}
+
+// New creates a new instance of the Account service with the provided dependencies
+func New(log *logrus.Logger, impersonations ImpersonationProvider, sessions SessionRevoker, sessionList SessionProvider, profiles ProfileStore, attributes AttributeStore, emailChanges EmailChangeStore, passwords PasswordVerifier, mailer Mailer, emailPolicy EmailChangePolicy) *Account {
+	return &Account{
+		log:            log,
+		impersonations: impersonations,
+		sessions:       sessions,
+		sessionList:    sessionList,
+		profiles:       profiles,
+		attributes:     attributes,
+		emailChanges:   emailChanges,
+		passwords:      passwords,
+		mailer:         mailer,
+		emailPolicy:    emailPolicy,
+	}
+}
+
+// ListImpersonations returns the security history of administrators acting as the user, newest first
+func (a *Account) ListImpersonations(ctx context.Context, user_id int64) ([]model.Impersonation, error) {
+	const op = "account.ListImpersonations"
+
+	impersonations, err := a.impersonations.Impersonations(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to list impersonations")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return impersonations, nil
+}
+
+// LogoutAll signs the user out of every app, outstanding access and refresh tokens are rejected afterwards
+func (a *Account) LogoutAll(ctx context.Context, user_id int64) error {
+	const op = "account.LogoutAll"
+
+	if err := a.sessions.LogoutAll(ctx, user_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// ListSessions returns the devices the user is signed in on, most recently used first
+func (a *Account) ListSessions(ctx context.Context, user_id int64) ([]model.Session, error) {
+	const op = "account.ListSessions"
+
+	sessions, err := a.sessionList.Sessions(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to list sessions")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs the user out of a single session, only sessions of the user can be revoked
+func (a *Account) RevokeSession(ctx context.Context, user_id int64, session_id int64) error {
+	const op = "account.RevokeSession"
+
+	if err := a.sessions.RevokeSession(ctx, user_id, session_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"ssoq/internal/services/oauth"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AuditAppCreated is the audit log event of an app registered by an administrator
+	AuditAppCreated = "app_created"
+	// AuditAppUpdated is the audit log event of an app whose settings were changed by an administrator
+	AuditAppUpdated = "app_updated"
+	// AuditAppDisabled is the audit log event of an app disabled by an administrator
+	AuditAppDisabled = "app_disabled"
+	// AuditAppEnabled is the audit log event of an app enabled again by an administrator
+	AuditAppEnabled = "app_enabled"
+	// AuditAppSecretRotated is the audit log event of an app secret replaced by an administrator
+	AuditAppSecretRotated = "app_secret_rotated"
+)
+
+// AppStore interface defines methods for registering and managing apps
+type AppStore interface {
+	SaveApp(ctx context.Context, app *model.App) (int64, error)
+	UpdateApp(ctx context.Context, app *model.App) error
+	Apps(ctx context.Context) ([]model.App, error)
+	SetAppDisabled(ctx context.Context, app_id int64, disabled bool) error
+	SetAppSecret(ctx context.Context, app_id int64, secret string, previousExpiresAt time.Time) error
+}
+
+// CreateApp registers an app with a generated secret, which is returned only here
+func (a *Admin) CreateApp(ctx context.Context, admin_id int64, app *model.App) (*model.App, string, error) {
+	const op = "admin.CreateApp"
+
+	if err := validateApp(app); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	secret, err := oauth.GenerateAppSecret()
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	app.Secret = secret
+	app.Id, err = a.appStore.SaveApp(ctx, app)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	details := map[string]interface{}{"name": app.Name, "owner": app.Owner}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: AuditAppCreated, AppId: app.Id, Details: details}); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"app_id":   app.Id,
+		"app_name": app.Name,
+	}).Info("app created by admin")
+	created, err := a.appProvider.App(ctx, app.Id)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	return created, secret, nil
+}
+
+// GetApp returns an app by id
+func (a *Admin) GetApp(ctx context.Context, app_id int64) (*model.App, error) {
+	const op = "admin.GetApp"
+
+	app, err := a.appProvider.App(ctx, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return app, nil
+}
+
+// UpdateApp replaces the settings of an app with those of app, the secret and status are kept
+func (a *Admin) UpdateApp(ctx context.Context, admin_id int64, app *model.App) (*model.App, error) {
+	const op = "admin.UpdateApp"
+
+	if err := validateApp(app); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.appStore.UpdateApp(ctx, app); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: AuditAppUpdated, AppId: app.Id}); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"app_id":   app.Id,
+	}).Info("app updated by admin")
+	updated, err := a.appProvider.App(ctx, app.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return updated, nil
+}
+
+// ListApps returns every app
+func (a *Admin) ListApps(ctx context.Context) ([]model.App, error) {
+	const op = "admin.ListApps"
+
+	apps, err := a.appStore.Apps(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return apps, nil
+}
+
+// DisableApp stops an app from logging users in and from obtaining or verifying tokens
+func (a *Admin) DisableApp(ctx context.Context, admin_id int64, app_id int64) error {
+	const op = "admin.DisableApp"
+
+	if err := a.appStore.SetAppDisabled(ctx, app_id, true); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: AuditAppDisabled, AppId: app_id}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"app_id":   app_id,
+	}).Warn("app disabled by admin")
+	return nil
+}
+
+// EnableApp allows a disabled app to be used again
+func (a *Admin) EnableApp(ctx context.Context, admin_id int64, app_id int64) error {
+	const op = "admin.EnableApp"
+
+	if err := a.appStore.SetAppDisabled(ctx, app_id, false); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: AuditAppEnabled, AppId: app_id}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"app_id":   app_id,
+	}).Info("app enabled by admin")
+	return nil
+}
+
+// RotateAppSecret replaces the secret of an app with a generated one, which is returned only here
+// Tokens signed with and clients authenticating with the replaced secret are accepted for grace,
+// a negative grace uses the configured grace period and 0 cuts the replaced secret off at once
+func (a *Admin) RotateAppSecret(ctx context.Context, admin_id int64, app_id int64, grace time.Duration) (*model.App, string, error) {
+	const op = "admin.RotateAppSecret"
+
+	if grace < 0 {
+		grace = a.secretGrace
+	}
+	var previousExpiresAt time.Time
+	if grace > 0 {
+		previousExpiresAt = time.Now().Add(grace)
+	}
+	secret, err := oauth.GenerateAppSecret()
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.appStore.SetAppSecret(ctx, app_id, secret, previousExpiresAt); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	details := map[string]interface{}{"grace_seconds": int64(grace.Seconds())}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: AuditAppSecretRotated, AppId: app_id, Details: details}); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"app_id":   app_id,
+		"grace":    grace,
+	}).Warn("app secret rotated by admin")
+	app, err := a.appProvider.App(ctx, app_id)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	return app, secret, nil
+}
+
+// validateApp checks the settings of an app registered or updated by an administrator
+func validateApp(app *model.App) error {
+	if strings.TrimSpace(app.Name) == "" {
+		return fmt.Errorf("app name is required: %w", ErrInvalidArgument)
+	}
+	for _, uri := range app.RedirectURIs {
+		if !validURI(uri) {
+			return fmt.Errorf("invalid redirect URI %q: %w", uri, ErrInvalidArgument)
+		}
+	}
+	for _, uri := range []string{app.BackchannelLogoutURI, app.FrontchannelLogoutURI} {
+		if uri != "" && !validURI(uri) {
+			return fmt.Errorf("invalid logout URI %q: %w", uri, ErrInvalidArgument)
+		}
+	}
+	for _, grant := range app.GrantTypes {
+		if grant != auth.GrantTypePassword && !slices.Contains(oauth.GrantTypes, grant) {
+			return fmt.Errorf("unknown grant type %q: %w", grant, ErrInvalidArgument)
+		}
+	}
+	for _, scope := range app.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return fmt.Errorf("invalid scope %q: %w", scope, ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// validURI reports whether uri is an absolute http or https URI without a fragment, as OAuth requires of redirect URIs
+func validURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && u.Fragment == ""
+}
@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"ssoq/internal/model"
+	"ssoq/internal/services/attributes"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AuditAppAttributeSchemaUpdated is the audit log event of an attribute schema set or removed by an administrator
+	AuditAppAttributeSchemaUpdated = "app_attribute_schema_updated"
+	// AuditUserAttributesUpdated is the audit log event of user attributes changed by an administrator
+	AuditUserAttributesUpdated = "user_attributes_updated"
+)
+
+// AttributeStore interface defines methods for managing app attribute schemas and user attributes
+type AttributeStore interface {
+	attributes.Store
+	SetAppAttributeSchema(ctx context.Context, app_id int64, schema []byte) error
+}
+
+// SetAppAttributeSchema replaces the attribute schema of an app, an empty schema removes it
+// Stored attributes are not checked against the new schema: updates only validate the attributes they patch,
+// and attributes the schema no longer declares can be removed with a null value
+func (a *Admin) SetAppAttributeSchema(ctx context.Context, admin_id int64, app_id int64, schema []byte) (*model.App, error) {
+	const op = "admin.SetAppAttributeSchema"
+
+	if _, err := attributes.Parse(schema); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	schema = bytes.TrimSpace(schema)
+	if len(schema) == 0 {
+		schema = nil
+	}
+	if err := a.attributeStore.SetAppAttributeSchema(ctx, app_id, schema); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	details := map[string]interface{}{"removed": schema == nil}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: AuditAppAttributeSchemaUpdated, AppId: app_id, Details: details}); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"app_id":   app_id,
+	}).Info("app attribute schema updated by admin")
+	app, err := a.appProvider.App(ctx, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return app, nil
+}
+
+// GetUserAttributes returns the attributes of a user in an app
+func (a *Admin) GetUserAttributes(ctx context.Context, user_id int64, app_id int64) (map[string]interface{}, error) {
+	const op = "admin.GetUserAttributes"
+
+	attrs, err := a.attributeStore.UserAttributes(ctx, user_id, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return attrs, nil
+}
+
+// UpdateUserAttributes applies patch to the attributes of a user in an app, a null value removes the attribute
+// Only attributes the schema lets administrators write may be patched
+func (a *Admin) UpdateUserAttributes(ctx context.Context, admin_id int64, user_id int64, app_id int64, patch map[string]interface{}) (map[string]interface{}, error) {
+	const op = "admin.UpdateUserAttributes"
+
+	app, err := a.appProvider.App(ctx, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	attrs, err := attributes.Update(ctx, a.attributeStore, app, user_id, patch, attributes.WriterAdmin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	details := map[string]interface{}{"attributes": names}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: AuditUserAttributesUpdated, UserId: user_id, AppId: app_id, Details: details}); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id":   admin_id,
+		"user_id":    user_id,
+		"app_id":     app_id,
+		"attributes": names,
+	}).Info("user attributes updated by admin")
+	return attrs, nil
+}
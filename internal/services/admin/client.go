@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"ssoq/internal/services/oauth"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ClientStore interface defines methods for managing machine clients
+type ClientStore interface {
+	SaveClient(ctx context.Context, client *model.Client) (int64, error)
+	Client(ctx context.Context, client_id string) (*model.Client, error)
+	Clients(ctx context.Context, app_id int64) ([]model.Client, error)
+	DeleteClient(ctx context.Context, client_id string) error
+}
+
+// CreateClient registers a machine client of an app allowed to request a subset of the app scopes
+// Without publicKey the client authenticates with a generated secret, which is returned only here
+func (a *Admin) CreateClient(ctx context.Context, app_id int64, name string, scopes []string, publicKey string) (*model.Client, string, error) {
+	const op = "admin.CreateClient"
+
+	if name == "" {
+		return nil, "", fmt.Errorf("%s: client name is required: %w", op, ErrInvalidArgument)
+	}
+	app, err := a.appProvider.App(ctx, app_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"op":     op,
+			"error":  err,
+		}).Error("failed to get app from provider")
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := auth.GrantScopes(scopes, app.Scopes); err != nil {
+		return nil, "", fmt.Errorf("%s: scopes are not allowed for the app: %w", op, ErrInvalidArgument)
+	}
+
+	client_id, err := oauth.GenerateClientID()
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	client := &model.Client{ClientId: client_id, AppId: app_id, Name: name, Scopes: scopes}
+	var secret string
+	if publicKey != "" {
+		if _, err := oauth.ParseClientPublicKey(publicKey); err != nil {
+			return nil, "", fmt.Errorf("%s: invalid public key: %v: %w", op, err, ErrInvalidArgument)
+		}
+		client.PublicKey = publicKey
+	} else {
+		secret, client.SecretHash, err = oauth.GenerateClientSecret()
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	client.Id, err = a.clientStore.SaveClient(ctx, client)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithFields(logrus.Fields{
+		"app_id":    app_id,
+		"client_id": client_id,
+	}).Info("client created")
+	return client, secret, nil
+}
+
+// DeleteClient deletes a machine client, it can no longer obtain tokens
+func (a *Admin) DeleteClient(ctx context.Context, client_id string) error {
+	const op = "admin.DeleteClient"
+
+	if err := a.clientStore.DeleteClient(ctx, client_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithField("client_id", client_id).Info("client deleted")
+	return nil
+}
+
+// ListClients returns the machine clients of an app
+func (a *Admin) ListClients(ctx context.Context, app_id int64) ([]model.Client, error) {
+	const op = "admin.ListClients"
+
+	clients, err := a.clientStore.Clients(ctx, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return clients, nil
+}
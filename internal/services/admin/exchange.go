@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ExchangePolicyStore interface defines methods for managing token exchange policies
+type ExchangePolicyStore interface {
+	SaveExchangePolicy(ctx context.Context, policy *model.ExchangePolicy) (int64, error)
+	ExchangePolicies(ctx context.Context, client_id string) ([]model.ExchangePolicy, error)
+	DeleteExchangePolicy(ctx context.Context, policy_id int64) error
+}
+
+// CreateExchangePolicy allows a machine client to exchange access tokens of users of subject_app_id
+// for access tokens of audience_app_id, scopes must be allowed for the audience app
+func (a *Admin) CreateExchangePolicy(ctx context.Context, client_id string, subject_app_id int64, audience_app_id int64, scopes []string) (*model.ExchangePolicy, error) {
+	const op = "admin.CreateExchangePolicy"
+
+	if _, err := a.clientStore.Client(ctx, client_id); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := a.appProvider.App(ctx, subject_app_id); err != nil {
+		return nil, fmt.Errorf("%s: subject app: %w", op, err)
+	}
+	audience, err := a.appProvider.App(ctx, audience_app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: audience app: %w", op, err)
+	}
+	if _, err := auth.GrantScopes(scopes, audience.Scopes); err != nil {
+		return nil, fmt.Errorf("%s: scopes are not allowed for the audience app: %w", op, ErrInvalidArgument)
+	}
+
+	policy := &model.ExchangePolicy{ClientId: client_id, SubjectAppId: subject_app_id, AudienceAppId: audience_app_id, Scopes: scopes}
+	policy.Id, err = a.exchangePolicies.SaveExchangePolicy(ctx, policy)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithFields(logrus.Fields{
+		"client_id":       client_id,
+		"subject_app_id":  subject_app_id,
+		"audience_app_id": audience_app_id,
+		"policy_id":       policy.Id,
+	}).Info("token exchange policy created")
+	return policy, nil
+}
+
+// DeleteExchangePolicy deletes a token exchange policy, tokens already exchanged stay valid until they expire
+func (a *Admin) DeleteExchangePolicy(ctx context.Context, policy_id int64) error {
+	const op = "admin.DeleteExchangePolicy"
+
+	if err := a.exchangePolicies.DeleteExchangePolicy(ctx, policy_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithField("policy_id", policy_id).Info("token exchange policy deleted")
+	return nil
+}
+
+// ListExchangePolicies returns the token exchange policies of a machine client
+func (a *Admin) ListExchangePolicies(ctx context.Context, client_id string) ([]model.ExchangePolicy, error) {
+	const op = "admin.ListExchangePolicies"
+
+	policies, err := a.exchangePolicies.ExchangePolicies(ctx, client_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return policies, nil
+}
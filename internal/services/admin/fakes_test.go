@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"context"
+	"io"
+	"ssoq/internal/model"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// fakeUsers is a UserStore keeping users in a map
+type fakeUsers struct {
+	users map[int64]*model.User
+}
+
+func (f *fakeUsers) Users(ctx context.Context, filter model.UserFilter, cursor int64, limit int) ([]model.User, error) {
+	return nil, nil
+}
+
+func (f *fakeUsers) GetUserByID(ctx context.Context, id int64) (*model.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeUsers) UpdateUser(ctx context.Context, user_id int64, email string, username string) error {
+	f.users[user_id].Email, f.users[user_id].Username = email, username
+	return nil
+}
+
+func (f *fakeUsers) SetUserStatus(ctx context.Context, user_id int64, status string, reason string) error {
+	f.users[user_id].Status = status
+	return nil
+}
+
+// fakeSessions records the users signed out everywhere
+type fakeSessions struct {
+	loggedOut []int64
+}
+
+func (f *fakeSessions) LogoutAll(ctx context.Context, user_id int64) error {
+	f.loggedOut = append(f.loggedOut, user_id)
+	return nil
+}
+
+// fakeUnlocker clears the failed logins of the users it shares with fakeUsers
+type fakeUnlocker struct {
+	users    *fakeUsers
+	unlocked []int64
+}
+
+func (f *fakeUnlocker) UnlockUser(ctx context.Context, user_id int64) error {
+	f.unlocked = append(f.unlocked, user_id)
+	if user, ok := f.users.users[user_id]; ok {
+		user.FailedAttempts, user.LastFailedAt, user.LockedUntil = 0, time.Time{}, time.Time{}
+	}
+	return nil
+}
+
+// fakeAuditLog keeps the saved audit events
+type fakeAuditLog struct {
+	events []*model.AuditEvent
+}
+
+func (f *fakeAuditLog) SaveAuditEvent(ctx context.Context, event *model.AuditEvent) error {
+	f.events = append(f.events, event)
+	return nil
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuditImpersonation is the audit log event of a started impersonation
+const AuditImpersonation = "impersonation_started"
+
+// ImpersonationTokenIssuer interface defines how tokens acting as a user are issued to administrators
+type ImpersonationTokenIssuer interface {
+	IssueImpersonationToken(ctx context.Context, admin_id int64, user_id int64, app *model.App, scopes []string, expiresAt time.Time) (string, error)
+}
+
+// ImpersonationStore interface defines methods for recording impersonations
+type ImpersonationStore interface {
+	SaveImpersonation(ctx context.Context, impersonation *model.Impersonation) (int64, error)
+}
+
+// AuditLogger interface defines methods for writing the audit log
+type AuditLogger interface {
+	SaveAuditEvent(ctx context.Context, event *model.AuditEvent) error
+}
+
+// StartImpersonation issues a short-lived access token of a user for an app to the administrator admin_id
+// The token cannot be refreshed, and the impersonation is recorded in the user's security history before it is returned
+func (a *Admin) StartImpersonation(ctx context.Context, admin_id int64, user_id int64, app_id int64, reason string, scopes []string) (*model.Impersonation, string, error) {
+	const op = "admin.StartImpersonation"
+
+	if reason == "" {
+		return nil, "", fmt.Errorf("%s: reason is required: %w", op, ErrInvalidArgument)
+	}
+	if admin_id == user_id {
+		return nil, "", fmt.Errorf("%s: administrators cannot impersonate themselves: %w", op, ErrInvalidArgument)
+	}
+	app, err := a.appProvider.App(ctx, app_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"op":     op,
+			"error":  err,
+		}).Error("failed to get app from provider")
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	granted, err := auth.GrantScopes(scopes, app.Scopes)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: scopes are not allowed for the app: %w", op, ErrInvalidArgument)
+	}
+
+	impersonation := &model.Impersonation{
+		AdminId:   admin_id,
+		UserId:    user_id,
+		AppId:     app_id,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(a.impersonationTTL),
+	}
+	token, err := a.tokenIssuer.IssueImpersonationToken(ctx, admin_id, user_id, app, granted, impersonation.ExpiresAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	impersonation.Id, err = a.impersonations.SaveImpersonation(ctx, impersonation)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+	err = a.auditLog.SaveAuditEvent(ctx, &model.AuditEvent{
+		Event:  AuditImpersonation,
+		Actor:  fmt.Sprintf("user:%d", admin_id),
+		UserId: user_id,
+		AppId:  app_id,
+		Details: map[string]interface{}{
+			"impersonation_id": impersonation.Id,
+			"reason":           reason,
+			"expires_at":       impersonation.ExpiresAt.Unix(),
+		},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id":         admin_id,
+		"user_id":          user_id,
+		"app_id":           app_id,
+		"impersonation_id": impersonation.Id,
+	}).Warn("impersonation started")
+	return impersonation, token, nil
+}
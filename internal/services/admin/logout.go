@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuditForceLogout is the audit log event of a user signed out everywhere by an administrator
+const AuditForceLogout = "force_logout"
+
+// SessionRevoker interface defines how every session of a user is revoked
+type SessionRevoker interface {
+	LogoutAll(ctx context.Context, user_id int64) error
+}
+
+// ForceLogout signs the user out of every app on behalf of the administrator admin_id
+// Outstanding access and refresh tokens of the user are rejected afterwards
+func (a *Admin) ForceLogout(ctx context.Context, admin_id int64, user_id int64) error {
+	const op = "admin.ForceLogout"
+
+	if err := a.sessions.LogoutAll(ctx, user_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	err := a.auditLog.SaveAuditEvent(ctx, &model.AuditEvent{
+		Event:  AuditForceLogout,
+		Actor:  fmt.Sprintf("user:%d", admin_id),
+		UserId: user_id,
+	})
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"admin_id": admin_id,
+			"user_id":  user_id,
+			"op":       op,
+			"error":    err,
+		}).Error("failed to write audit log")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"user_id":  user_id,
+	}).Warn("user logged out everywhere by admin")
+	return nil
+}
@@ -0,0 +1,263 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidArgument is returned when an admin operation is called with incomplete or inconsistent input
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// Admin represents the administration service that manages roles, permissions, their assignments and machine clients
+type Admin struct {
+	log              *logrus.Logger
+	roleStore        RoleStore
+	appProvider      AppProvider
+	appMembership    AppMembership
+	clientStore      ClientStore
+	exchangePolicies ExchangePolicyStore
+	tokenIssuer      ImpersonationTokenIssuer
+	impersonations   ImpersonationStore
+	auditLog         AuditLogger
+	sessions         SessionRevoker
+	lockouts         LoginUnlocker
+	userStore        UserStore
+	appStore         AppStore
+	attributeStore   AttributeStore
+	impersonationTTL time.Duration
+	secretGrace      time.Duration
+}
+
+// RoleStore interface defines methods for managing roles and permissions
+type RoleStore interface {
+	SaveRole(ctx context.Context, app_id int64, name string, description string) (int64, error)
+	DeleteRole(ctx context.Context, role_id int64) error
+	Role(ctx context.Context, role_id int64) (*model.Role, error)
+	Roles(ctx context.Context, app_id int64) ([]model.Role, error)
+	UserRoles(ctx context.Context, user_id int64, app_id int64) ([]model.Role, error)
+	SavePermission(ctx context.Context, app_id int64, name string, description string) (int64, error)
+	DeletePermission(ctx context.Context, permission_id int64) error
+	Permissions(ctx context.Context, app_id int64) ([]model.Permission, error)
+	SetRolePermissions(ctx context.Context, role_id int64, permissions []string) error
+	AddUserRole(ctx context.Context, user_id int64, role_id int64) error
+	RemoveUserRole(ctx context.Context, user_id int64, role_id int64) error
+}
+
+// AppProvider interface defines methods for retrieving application data
+type AppProvider interface {
+	App(ctx context.Context, app_id int64) (*model.App, error)
+}
+
+// AppMembership interface defines methods for checking which apps a user belongs to
+type AppMembership interface {
+	HasUserApp(ctx context.Context, user_id int64, app_id int64) (bool, error)
+}
+
+// New creates a new instance of the Admin service with the provided dependencies
+// impersonationTTL is the lifetime of tokens issued by StartImpersonation
+// secretGrace is how long RotateAppSecret keeps the replaced secret valid unless told otherwise
+func New(log *logrus.Logger, roleStore RoleStore, appProvider AppProvider, appMembership AppMembership, clientStore ClientStore, exchangePolicies ExchangePolicyStore, tokenIssuer ImpersonationTokenIssuer, impersonations ImpersonationStore, auditLog AuditLogger, sessions SessionRevoker, lockouts LoginUnlocker, userStore UserStore, appStore AppStore, attributeStore AttributeStore, impersonationTTL time.Duration, secretGrace time.Duration) *Admin {
+	return &Admin{
+		log:              log,
+		roleStore:        roleStore,
+		appProvider:      appProvider,
+		appMembership:    appMembership,
+		clientStore:      clientStore,
+		exchangePolicies: exchangePolicies,
+		tokenIssuer:      tokenIssuer,
+		impersonations:   impersonations,
+		auditLog:         auditLog,
+		sessions:         sessions,
+		lockouts:         lockouts,
+		userStore:        userStore,
+		appStore:         appStore,
+		attributeStore:   attributeStore,
+		impersonationTTL: impersonationTTL,
+		secretGrace:      secretGrace,
+	}
+}
+
+// CreateRole creates a role in an app and sets its permissions, which must already exist in the app
+func (a *Admin) CreateRole(ctx context.Context, app_id int64, name string, description string, permissions []string) (*model.Role, error) {
+	const op = "admin.CreateRole"
+
+	if name == "" {
+		return nil, fmt.Errorf("%s: role name is required: %w", op, ErrInvalidArgument)
+	}
+	if _, err := a.appProvider.App(ctx, app_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"op":     op,
+			"error":  err,
+		}).Error("failed to get app from provider")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	role_id, err := a.roleStore.SaveRole(ctx, app_id, name, description)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(permissions) > 0 {
+		if err := a.roleStore.SetRolePermissions(ctx, role_id, permissions); err != nil {
+			// Do not leave a half configured role behind
+			if delErr := a.roleStore.DeleteRole(ctx, role_id); delErr != nil {
+				a.log.WithFields(logrus.Fields{
+					"role_id": role_id,
+					"op":      op,
+					"error":   delErr,
+				}).Error("failed to delete role after setting its permissions failed")
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"app_id":  app_id,
+		"role_id": role_id,
+		"role":    name,
+	}).Info("role created")
+	return a.roleStore.Role(ctx, role_id)
+}
+
+// DeleteRole deletes a role, users holding it lose its permissions on their next token
+func (a *Admin) DeleteRole(ctx context.Context, role_id int64) error {
+	const op = "admin.DeleteRole"
+
+	if err := a.roleStore.DeleteRole(ctx, role_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithField("role_id", role_id).Info("role deleted")
+	return nil
+}
+
+// ListRoles returns the roles defined in an app
+func (a *Admin) ListRoles(ctx context.Context, app_id int64) ([]model.Role, error) {
+	const op = "admin.ListRoles"
+
+	roles, err := a.roleStore.Roles(ctx, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return roles, nil
+}
+
+// SetRolePermissions replaces the permissions of a role
+func (a *Admin) SetRolePermissions(ctx context.Context, role_id int64, permissions []string) (*model.Role, error) {
+	const op = "admin.SetRolePermissions"
+
+	if err := a.roleStore.SetRolePermissions(ctx, role_id, permissions); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithFields(logrus.Fields{
+		"role_id":     role_id,
+		"permissions": permissions,
+	}).Info("role permissions updated")
+	return a.roleStore.Role(ctx, role_id)
+}
+
+// CreatePermission defines a permission in an app
+func (a *Admin) CreatePermission(ctx context.Context, app_id int64, name string, description string) (*model.Permission, error) {
+	const op = "admin.CreatePermission"
+
+	if name == "" {
+		return nil, fmt.Errorf("%s: permission name is required: %w", op, ErrInvalidArgument)
+	}
+	if _, err := a.appProvider.App(ctx, app_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"op":     op,
+			"error":  err,
+		}).Error("failed to get app from provider")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := a.roleStore.SavePermission(ctx, app_id, name, description)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithFields(logrus.Fields{
+		"app_id":        app_id,
+		"permission_id": id,
+		"permission":    name,
+	}).Info("permission created")
+	return &model.Permission{Id: id, AppId: app_id, Name: name, Description: description}, nil
+}
+
+// DeletePermission deletes a permission and removes it from every role
+func (a *Admin) DeletePermission(ctx context.Context, permission_id int64) error {
+	const op = "admin.DeletePermission"
+
+	if err := a.roleStore.DeletePermission(ctx, permission_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithField("permission_id", permission_id).Info("permission deleted")
+	return nil
+}
+
+// ListPermissions returns the permissions defined in an app
+func (a *Admin) ListPermissions(ctx context.Context, app_id int64) ([]model.Permission, error) {
+	const op = "admin.ListPermissions"
+
+	permissions, err := a.roleStore.Permissions(ctx, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return permissions, nil
+}
+
+// AssignRole assigns a role to a user, the user must be a member of the role's app
+func (a *Admin) AssignRole(ctx context.Context, user_id int64, role_id int64) error {
+	const op = "admin.AssignRole"
+
+	role, err := a.roleStore.Role(ctx, role_id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	member, err := a.appMembership.HasUserApp(ctx, user_id, role.AppId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !member {
+		return fmt.Errorf("%s: user is not a member of the role's app: %w", op, ErrInvalidArgument)
+	}
+
+	if err := a.roleStore.AddUserRole(ctx, user_id, role_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithFields(logrus.Fields{
+		"user_id": user_id,
+		"role_id": role_id,
+		"app_id":  role.AppId,
+	}).Info("role assigned")
+	return nil
+}
+
+// UnassignRole removes a role from a user
+func (a *Admin) UnassignRole(ctx context.Context, user_id int64, role_id int64) error {
+	const op = "admin.UnassignRole"
+
+	if err := a.roleStore.RemoveUserRole(ctx, user_id, role_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.log.WithFields(logrus.Fields{
+		"user_id": user_id,
+		"role_id": role_id,
+	}).Info("role unassigned")
+	return nil
+}
+
+// ListUserRoles returns the roles a user holds within an app
+func (a *Admin) ListUserRoles(ctx context.Context, user_id int64, app_id int64) ([]model.Role, error) {
+	const op = "admin.ListUserRoles"
+
+	roles, err := a.roleStore.UserRoles(ctx, user_id, app_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return roles, nil
+}
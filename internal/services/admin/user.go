@@ -0,0 +1,255 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"ssoq/internal/model"
+	"ssoq/internal/storage"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnsupported is returned for operations on features this deployment does not have
+var ErrUnsupported = errors.New("operation is not supported")
+
+const (
+	// AuditUserUpdated is the audit log event of a user whose profile was changed by an administrator
+	AuditUserUpdated = "user_updated"
+	// AuditUserDisabled is the audit log event of a user disabled by an administrator
+	AuditUserDisabled = "user_disabled"
+	// AuditUserEnabled is the audit log event of a user enabled again by an administrator
+	AuditUserEnabled = "user_enabled"
+	// AuditUserDeleted is the audit log event of a user deleted by an administrator
+	AuditUserDeleted = "user_deleted"
+	// AuditUserStatusChanged is the audit log event of any other status set by an administrator
+	AuditUserStatusChanged = "user_status_changed"
+)
+
+const (
+	defaultUsersPageSize = 50
+	maxUsersPageSize     = 500
+)
+
+// UserStore interface defines methods for listing and managing user accounts
+type UserStore interface {
+	Users(ctx context.Context, filter model.UserFilter, cursor int64, limit int) ([]model.User, error)
+	GetUserByID(ctx context.Context, id int64) (*model.User, error)
+	UpdateUser(ctx context.Context, user_id int64, email string, username string) error
+	SetUserStatus(ctx context.Context, user_id int64, status string, reason string) error
+}
+
+// LoginUnlocker interface defines how the lock set by failed logins is cleared
+type LoginUnlocker interface {
+	UnlockUser(ctx context.Context, user_id int64) error
+}
+
+// ListUsers returns a page of users matching filter after the user id cursor, 0 starts from the beginning
+// The returned cursor continues the listing, it is 0 on the last page
+func (a *Admin) ListUsers(ctx context.Context, filter model.UserFilter, cursor int64, limit int) ([]model.User, int64, error) {
+	const op = "admin.ListUsers"
+
+	if limit <= 0 {
+		limit = defaultUsersPageSize
+	}
+	if limit > maxUsersPageSize {
+		limit = maxUsersPageSize
+	}
+	// One extra row tells whether another page follows
+	users, err := a.userStore.Users(ctx, filter, cursor, limit+1)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", op, err)
+	}
+	var next int64
+	if len(users) > limit {
+		users = users[:limit]
+		next = users[limit-1].Id
+	}
+	return users, next, nil
+}
+
+// GetUser returns a user by id
+func (a *Admin) GetUser(ctx context.Context, user_id int64) (*model.User, error) {
+	const op = "admin.GetUser"
+
+	user, err := a.userStore.GetUserByID(ctx, user_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return user, nil
+}
+
+// UpdateUser changes the email and username of a user on behalf of the administrator admin_id
+// An empty email or username keeps the current value, the email is trimmed and lower-cased before it is stored
+func (a *Admin) UpdateUser(ctx context.Context, admin_id int64, user_id int64, email string, username string) (*model.User, error) {
+	const op = "admin.UpdateUser"
+
+	if email != "" {
+		normalized, err := normalizeEmail(email)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		email = normalized
+	}
+	user, err := a.GetUser(ctx, user_id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	details := map[string]interface{}{}
+	if email != "" && email != user.Email {
+		details["email"] = map[string]interface{}{"old": user.Email, "new": email}
+		user.Email = email
+	}
+	if username != "" && username != user.Username {
+		details["username"] = map[string]interface{}{"old": user.Username, "new": username}
+		user.Username = username
+	}
+	if len(details) == 0 {
+		return user, nil
+	}
+
+	if err := a.userStore.UpdateUser(ctx, user_id, user.Email, user.Username); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: AuditUserUpdated, UserId: user_id, Details: details}); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"user_id":  user_id,
+	}).Info("user updated by admin")
+	return user, nil
+}
+
+// normalizeEmail trims and lower-cases email and accepts only a bare address such as user@example.com
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", fmt.Errorf("invalid email %q: %w", email, ErrInvalidArgument)
+	}
+	return email, nil
+}
+
+// DisableUser blocks the logins of a user and revokes all of its sessions and tokens
+func (a *Admin) DisableUser(ctx context.Context, admin_id int64, user_id int64, reason string) error {
+	const op = "admin.DisableUser"
+
+	if err := a.changeUserStatus(ctx, admin_id, user_id, model.UserStatusDisabled, reason, AuditUserDisabled); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// EnableUser makes a disabled, locked or unverified user active again
+// It also lifts the temporary lock of too many failed logins, so it is the way to unlock a user locked out by mistake
+func (a *Admin) EnableUser(ctx context.Context, admin_id int64, user_id int64) error {
+	const op = "admin.EnableUser"
+
+	if err := a.changeUserStatus(ctx, admin_id, user_id, model.UserStatusActive, "", AuditUserEnabled); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// SetUserStatus sets the status of a user with the reason shown to other administrators
+// Every status but active revokes the sessions and tokens of the user, deleted is only set by DeleteUser
+func (a *Admin) SetUserStatus(ctx context.Context, admin_id int64, user_id int64, status string, reason string) error {
+	const op = "admin.SetUserStatus"
+
+	switch status {
+	case model.UserStatusActive, model.UserStatusDisabled, model.UserStatusLocked, model.UserStatusPendingVerification:
+	default:
+		return fmt.Errorf("%s: unknown user status %q: %w", op, status, ErrInvalidArgument)
+	}
+	if err := a.changeUserStatus(ctx, admin_id, user_id, status, reason, AuditUserStatusChanged); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteUser signs a user out of every app and soft-deletes the account
+// The row is kept for audit, its email can be registered again once the retention period has passed
+func (a *Admin) DeleteUser(ctx context.Context, admin_id int64, user_id int64, reason string) error {
+	const op = "admin.DeleteUser"
+
+	if err := a.changeUserStatus(ctx, admin_id, user_id, model.UserStatusDeleted, reason, AuditUserDeleted); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// changeUserStatus sets the status of a user and writes event to the audit log
+// A user becoming active gets its failed logins cleared, any other status signs the user out everywhere
+func (a *Admin) changeUserStatus(ctx context.Context, admin_id int64, user_id int64, status string, reason string, event string) error {
+	if admin_id == user_id && status != model.UserStatusActive {
+		return fmt.Errorf("administrators cannot change the status of their own account: %w", ErrInvalidArgument)
+	}
+	user, err := a.GetUser(ctx, user_id)
+	if err != nil {
+		return err
+	}
+	if err := a.userStore.SetUserStatus(ctx, user_id, status, reason); err != nil {
+		return err
+	}
+	if status == model.UserStatusActive {
+		if err := a.lockouts.UnlockUser(ctx, user_id); err != nil {
+			return err
+		}
+	} else if err := a.sessions.LogoutAll(ctx, user_id); err != nil {
+		return err
+	}
+	details := map[string]interface{}{"status": status, "previous_status": user.Status}
+	if status == model.UserStatusActive && (user.FailedAttempts > 0 || !user.LockedUntil.IsZero()) {
+		details["failed_attempts"] = user.FailedAttempts
+	}
+	if reason != "" {
+		details["reason"] = reason
+	}
+	if status == model.UserStatusDeleted {
+		details["email"], details["app_id"] = user.Email, user.AppId
+	}
+	if err := a.audit(ctx, admin_id, &model.AuditEvent{Event: event, UserId: user_id, Details: details}); err != nil {
+		return err
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"admin_id": admin_id,
+		"user_id":  user_id,
+		"status":   status,
+	}).Warn("user status changed by admin")
+	return nil
+}
+
+// ResetMFA removes the second factors of a user
+// Multi-factor authentication is not implemented yet, so it always fails with ErrUnsupported
+func (a *Admin) ResetMFA(ctx context.Context, admin_id int64, user_id int64) error {
+	const op = "admin.ResetMFA"
+
+	if _, err := a.GetUser(ctx, user_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return fmt.Errorf("%s: multi-factor authentication is not enabled: %w", op, ErrUnsupported)
+}
+
+// audit writes an audit log event of the administrator admin_id
+func (a *Admin) audit(ctx context.Context, admin_id int64, event *model.AuditEvent) error {
+	event.Actor = fmt.Sprintf("user:%d", admin_id)
+	if err := a.auditLog.SaveAuditEvent(ctx, event); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"admin_id": admin_id,
+			"user_id":  event.UserId,
+			"app_id":   event.AppId,
+			"event":    event.Event,
+			"error":    err,
+		}).Error("failed to write audit log")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"ssoq/internal/model"
+	"testing"
+	"time"
+)
+
+// newUserAdmin returns an Admin managing the users of fakeUsers, with the fakes it records to
+func newUserAdmin(users map[int64]*model.User) (*Admin, *fakeSessions, *fakeUnlocker, *fakeAuditLog) {
+	store := &fakeUsers{users: users}
+	sessions := &fakeSessions{}
+	unlocker := &fakeUnlocker{users: store}
+	auditLog := &fakeAuditLog{}
+	a := New(testLogger(), nil, nil, nil, nil, nil, nil, nil, auditLog, sessions, unlocker, store, nil, nil, time.Minute, time.Hour)
+	return a, sessions, unlocker, auditLog
+}
+
+func TestChangeUserStatusLockout(t *testing.T) {
+	const adminID, userID = 1, 2
+	lockedUntil := time.Now().Add(10 * time.Minute)
+
+	tests := []struct {
+		name              string
+		change            func(a *Admin) error
+		wantStatus        string
+		wantUnlocked      bool
+		wantLoggedOut     bool
+		wantAttempts      int
+		wantAuditAttempts bool
+	}{
+		{
+			name:              "enable clears failed logins",
+			change:            func(a *Admin) error { return a.EnableUser(context.Background(), adminID, userID) },
+			wantStatus:        model.UserStatusActive,
+			wantUnlocked:      true,
+			wantAttempts:      0,
+			wantAuditAttempts: true,
+		},
+		{
+			name: "active status clears failed logins",
+			change: func(a *Admin) error {
+				return a.SetUserStatus(context.Background(), adminID, userID, model.UserStatusActive, "locked out by mistake")
+			},
+			wantStatus:        model.UserStatusActive,
+			wantUnlocked:      true,
+			wantAttempts:      0,
+			wantAuditAttempts: true,
+		},
+		{
+			name:          "disable keeps failed logins",
+			change:        func(a *Admin) error { return a.DisableUser(context.Background(), adminID, userID, "abuse") },
+			wantStatus:    model.UserStatusDisabled,
+			wantLoggedOut: true,
+			wantAttempts:  5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := map[int64]*model.User{
+				userID: {Id: userID, Email: "user@example.com", Status: model.UserStatusLocked, FailedAttempts: 5, LastFailedAt: time.Now(), LockedUntil: lockedUntil},
+			}
+			a, sessions, unlocker, auditLog := newUserAdmin(users)
+
+			if err := tt.change(a); err != nil {
+				t.Fatalf("change status error = %v", err)
+			}
+			user := users[userID]
+			if user.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", user.Status, tt.wantStatus)
+			}
+			if got := slices.Contains(unlocker.unlocked, userID); got != tt.wantUnlocked {
+				t.Errorf("UnlockUser called = %v, want %v", got, tt.wantUnlocked)
+			}
+			if got := slices.Contains(sessions.loggedOut, userID); got != tt.wantLoggedOut {
+				t.Errorf("LogoutAll called = %v, want %v", got, tt.wantLoggedOut)
+			}
+			if user.FailedAttempts != tt.wantAttempts {
+				t.Errorf("failed attempts = %d, want %d", user.FailedAttempts, tt.wantAttempts)
+			}
+			if tt.wantUnlocked && !user.LockedUntil.IsZero() {
+				t.Errorf("locked until = %v, want cleared", user.LockedUntil)
+			}
+			if len(auditLog.events) != 1 {
+				t.Fatalf("audit events = %d, want 1", len(auditLog.events))
+			}
+			_, recorded := auditLog.events[0].Details["failed_attempts"]
+			if recorded != tt.wantAuditAttempts {
+				t.Errorf("audit failed_attempts recorded = %v, want %v", recorded, tt.wantAuditAttempts)
+			}
+		})
+	}
+}
+
+func TestUpdateUserEmail(t *testing.T) {
+	const adminID, userID = 1, 2
+
+	tests := []struct {
+		name      string
+		email     string
+		wantEmail string
+		wantErr   error
+	}{
+		{name: "bare address", email: "new@example.com", wantEmail: "new@example.com"},
+		{name: "trimmed and lower-cased", email: "  New.User@Example.COM ", wantEmail: "new.user@example.com"},
+		{name: "empty keeps email", email: "", wantEmail: "user@example.com"},
+		{name: "missing domain", email: "new", wantErr: ErrInvalidArgument},
+		{name: "display name", email: "New User <new@example.com>", wantErr: ErrInvalidArgument},
+		{name: "inner space", email: "new user@example.com", wantErr: ErrInvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := map[int64]*model.User{
+				userID: {Id: userID, Email: "user@example.com", Username: "user", Status: model.UserStatusActive},
+			}
+			a, _, _, _ := newUserAdmin(users)
+
+			_, err := a.UpdateUser(context.Background(), adminID, userID, tt.email, "renamed")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("UpdateUser() error = %v, want %v", err, tt.wantErr)
+				}
+				if users[userID].Email != "user@example.com" || users[userID].Username != "user" {
+					t.Errorf("user = %+v, want unchanged after a rejected update", users[userID])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UpdateUser() error = %v", err)
+			}
+			if users[userID].Email != tt.wantEmail {
+				t.Errorf("stored email = %q, want %q", users[userID].Email, tt.wantEmail)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package attributes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"unicode/utf8"
+)
+
+// Writers of attributes, listed per attribute in the x-write keyword of the schema
+const (
+	WriterAdmin = "admin"
+	WriterUser  = "user"
+)
+
+var (
+	// ErrInvalidSchema is returned when an attribute schema uses keywords or values that are not supported
+	ErrInvalidSchema = errors.New("invalid attribute schema")
+	// ErrInvalidAttributes is returned when attributes do not match the schema of their app
+	ErrInvalidAttributes = errors.New("invalid attributes")
+	// ErrNotWritable is returned when an attribute may not be written by the caller
+	ErrNotWritable = errors.New("attribute is not writable")
+)
+
+// reservedClaims are the claims of access tokens that attributes cannot be mapped to
+var reservedClaims = []string{
+	"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "user_id", "username", "email", "app_id", "client_id",
+	"purpose", "roles", "permissions", "scope", "token_version", "sid", "act", "impersonator_id",
+}
+
+// annotations are schema keywords that are accepted and ignored
+var annotations = []string{"$schema", "$id", "$comment", "title", "description", "examples", "default"}
+
+// Schema is the parsed attribute schema of an app
+// It is a subset of JSON Schema: an object with flat properties, extended with x-write and x-claim
+type Schema struct {
+	properties map[string]*property
+	required   []string
+}
+
+type property struct {
+	Type      string        `json:"type"`
+	Enum      []interface{} `json:"enum"`
+	MinLength *int          `json:"minLength"`
+	MaxLength *int          `json:"maxLength"`
+	Pattern   string        `json:"pattern"`
+	Minimum   *float64      `json:"minimum"`
+	Maximum   *float64      `json:"maximum"`
+	Items     *struct {
+		Type string        `json:"type"`
+		Enum []interface{} `json:"enum"`
+	} `json:"items"`
+	MaxItems *int `json:"maxItems"`
+	// Write lists who may change the attribute, administrators only when it is empty
+	Write []string `json:"x-write"`
+	// Claim is the access token claim the attribute is copied to, none when it is empty
+	Claim string `json:"x-claim"`
+
+	pattern *regexp.Regexp
+}
+
+var propertyKeywords = []string{"type", "enum", "minLength", "maxLength", "pattern", "minimum", "maximum", "items", "maxItems", "x-write", "x-claim"}
+
+// Parse parses an attribute schema, an empty document is a schema without attributes
+func Parse(doc []byte) (*Schema, error) {
+	schema := &Schema{properties: map[string]*property{}}
+	if len(bytes.TrimSpace(doc)) == 0 {
+		return schema, nil
+	}
+
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	if err := checkKeywords(root, append([]string{"type", "properties", "required", "additionalProperties"}, annotations...)); err != nil {
+		return nil, err
+	}
+	if raw, ok := root["type"]; ok && string(raw) != `"object"` {
+		return nil, fmt.Errorf("%w: type of the schema must be \"object\"", ErrInvalidSchema)
+	}
+	// Undeclared attributes are always rejected, so they never bypass the write permissions
+	if raw, ok := root["additionalProperties"]; ok && string(raw) != "false" {
+		return nil, fmt.Errorf("%w: additionalProperties must be false", ErrInvalidSchema)
+	}
+
+	var properties map[string]json.RawMessage
+	if raw, ok := root["properties"]; ok {
+		if err := json.Unmarshal(raw, &properties); err != nil {
+			return nil, fmt.Errorf("%w: properties: %v", ErrInvalidSchema, err)
+		}
+	}
+	claims := map[string]string{}
+	for name, raw := range properties {
+		p, err := parseProperty(name, raw)
+		if err != nil {
+			return nil, err
+		}
+		if p.Claim != "" {
+			if other, ok := claims[p.Claim]; ok {
+				return nil, fmt.Errorf("%w: %s and %s map to the same claim %q", ErrInvalidSchema, other, name, p.Claim)
+			}
+			claims[p.Claim] = name
+		}
+		schema.properties[name] = p
+	}
+	if raw, ok := root["required"]; ok {
+		if err := json.Unmarshal(raw, &schema.required); err != nil {
+			return nil, fmt.Errorf("%w: required: %v", ErrInvalidSchema, err)
+		}
+		for _, name := range schema.required {
+			if _, ok := schema.properties[name]; !ok {
+				return nil, fmt.Errorf("%w: required attribute %q is not declared", ErrInvalidSchema, name)
+			}
+		}
+	}
+	return schema, nil
+}
+
+func parseProperty(name string, raw json.RawMessage) (*property, error) {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keywords); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidSchema, name, err)
+	}
+	if err := checkKeywords(keywords, append(slices.Clone(propertyKeywords), annotations...)); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	var p property
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidSchema, name, err)
+	}
+	switch p.Type {
+	case "string", "integer", "number", "boolean":
+	case "array":
+		if p.Items == nil || !slices.Contains([]string{"string", "integer", "number", "boolean"}, p.Items.Type) {
+			return nil, fmt.Errorf("%w: %s: items of an array must have a scalar type", ErrInvalidSchema, name)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s: unsupported type %q", ErrInvalidSchema, name, p.Type)
+	}
+	if p.Pattern != "" {
+		pattern, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: pattern: %v", ErrInvalidSchema, name, err)
+		}
+		p.pattern = pattern
+	}
+	for _, writer := range p.Write {
+		if writer != WriterAdmin && writer != WriterUser {
+			return nil, fmt.Errorf("%w: %s: unknown writer %q in x-write", ErrInvalidSchema, name, writer)
+		}
+	}
+	if slices.Contains(reservedClaims, p.Claim) {
+		return nil, fmt.Errorf("%w: %s: claim %q is reserved", ErrInvalidSchema, name, p.Claim)
+	}
+	return &p, nil
+}
+
+// checkKeywords rejects keywords that are not allowed, unsupported JSON Schema keywords would otherwise be silently ignored
+func checkKeywords(keywords map[string]json.RawMessage, allowed []string) error {
+	for keyword := range keywords {
+		if !slices.Contains(allowed, keyword) {
+			return fmt.Errorf("%w: unsupported keyword %q", ErrInvalidSchema, keyword)
+		}
+	}
+	return nil
+}
+
+// Validate checks attributes against the schema
+func (s *Schema) Validate(attrs map[string]interface{}) error {
+	for _, name := range s.required {
+		if attrs[name] == nil {
+			return fmt.Errorf("%w: %s is required", ErrInvalidAttributes, name)
+		}
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	// Sorted so the reported error does not depend on map order
+	sort.Strings(names)
+	for _, name := range names {
+		p, ok := s.properties[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is not declared in the schema", ErrInvalidAttributes, name)
+		}
+		if err := p.validate(attrs[name]); err != nil {
+			return fmt.Errorf("%w: %s %v", ErrInvalidAttributes, name, err)
+		}
+	}
+	return nil
+}
+
+func (p *property) validate(value interface{}) error {
+	if p.Type == "array" {
+		items, ok := value.([]interface{})
+		if !ok {
+			return errors.New("must be an array")
+		}
+		if p.MaxItems != nil && len(items) > *p.MaxItems {
+			return fmt.Errorf("must have at most %d items", *p.MaxItems)
+		}
+		for _, item := range items {
+			if err := checkScalar(p.Items.Type, p.Items.Enum, item); err != nil {
+				return fmt.Errorf("item %v", err)
+			}
+		}
+		return nil
+	}
+	if err := checkScalar(p.Type, p.Enum, value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if p.MinLength != nil && n < *p.MinLength {
+			return fmt.Errorf("must have at least %d characters", *p.MinLength)
+		}
+		if p.MaxLength != nil && n > *p.MaxLength {
+			return fmt.Errorf("must have at most %d characters", *p.MaxLength)
+		}
+		if p.pattern != nil && !p.pattern.MatchString(v) {
+			return fmt.Errorf("must match %s", p.Pattern)
+		}
+	case float64:
+		if p.Minimum != nil && v < *p.Minimum {
+			return fmt.Errorf("must be at least %v", *p.Minimum)
+		}
+		if p.Maximum != nil && v > *p.Maximum {
+			return fmt.Errorf("must be at most %v", *p.Maximum)
+		}
+	}
+	return nil
+}
+
+// checkScalar checks the JSON type of value, numbers are float64 as decoded by encoding/json
+func checkScalar(typ string, enum []interface{}, value interface{}) error {
+	ok := false
+	switch v := value.(type) {
+	case string:
+		ok = typ == "string"
+	case bool:
+		ok = typ == "boolean"
+	case float64:
+		ok = typ == "number" || typ == "integer" && v == math.Trunc(v)
+	}
+	if !ok {
+		return fmt.Errorf("must be of type %s", typ)
+	}
+	if len(enum) > 0 && !slices.Contains(enum, value) {
+		return errors.New("is not one of the allowed values")
+	}
+	return nil
+}
+
+// Apply returns current with patch applied on behalf of writer, a nil value in patch removes the attribute
+// Only the patched attributes are validated, so values stored under an earlier schema do not block updates
+// of other attributes: a nil value also removes an attribute the schema no longer declares, and required
+// attributes must be present unless writer cannot write them
+func (s *Schema) Apply(current map[string]interface{}, patch map[string]interface{}, writer string) (map[string]interface{}, error) {
+	next := make(map[string]interface{}, len(current)+len(patch))
+	for name, value := range current {
+		next[name] = value
+	}
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	// Sorted so the reported error does not depend on map order
+	sort.Strings(names)
+	for _, name := range names {
+		value := patch[name]
+		p, ok := s.properties[name]
+		if !ok {
+			if value == nil {
+				delete(next, name)
+				continue
+			}
+			return nil, fmt.Errorf("%w: %s is not declared in the schema", ErrInvalidAttributes, name)
+		}
+		if !p.writable(writer) {
+			return nil, fmt.Errorf("%w: %s", ErrNotWritable, name)
+		}
+		if value == nil {
+			delete(next, name)
+			continue
+		}
+		if err := p.validate(value); err != nil {
+			return nil, fmt.Errorf("%w: %s %v", ErrInvalidAttributes, name, err)
+		}
+		next[name] = value
+	}
+	for _, name := range s.required {
+		if next[name] == nil && s.properties[name].writable(writer) {
+			return nil, fmt.Errorf("%w: %s is required", ErrInvalidAttributes, name)
+		}
+	}
+	return next, nil
+}
+
+func (p *property) writable(writer string) bool {
+	if len(p.Write) == 0 {
+		return writer == WriterAdmin
+	}
+	return slices.Contains(p.Write, writer)
+}
+
+// Claims returns the access token claims of the attributes mapped with x-claim
+func (s *Schema) Claims(attrs map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{}
+	for name, p := range s.properties {
+		if value, ok := attrs[name]; ok && p.Claim != "" {
+			claims[p.Claim] = value
+		}
+	}
+	return claims
+}
@@ -0,0 +1,179 @@
+package attributes
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["department"],
+	"properties": {
+		"department": {"type": "string", "enum": ["sales", "support"], "x-claim": "dept"},
+		"nickname": {"type": "string", "minLength": 2, "maxLength": 5, "x-write": ["user", "admin"]},
+		"badge": {"type": "string", "pattern": "^[A-Z]{3}[0-9]+$"},
+		"level": {"type": "integer", "minimum": 1, "maximum": 3},
+		"score": {"type": "number"},
+		"active": {"type": "boolean"},
+		"tags": {"type": "array", "items": {"type": "string", "enum": ["a", "b"]}, "maxItems": 2}
+	}
+}`
+
+func mustParse(t *testing.T, doc string) *Schema {
+	t.Helper()
+	schema, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return schema
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{name: "empty document", doc: "  "},
+		{name: "full schema", doc: testSchema},
+		{name: "annotations", doc: `{"$schema": "x", "title": "t", "properties": {"a": {"type": "string", "description": "d"}}}`},
+		{name: "not json", doc: `{`, wantErr: true},
+		{name: "not an object", doc: `{"type": "array"}`, wantErr: true},
+		{name: "additional properties allowed", doc: `{"additionalProperties": true}`, wantErr: true},
+		{name: "unsupported root keyword", doc: `{"oneOf": []}`, wantErr: true},
+		{name: "unsupported property keyword", doc: `{"properties": {"a": {"type": "string", "format": "email"}}}`, wantErr: true},
+		{name: "unsupported type", doc: `{"properties": {"a": {"type": "object"}}}`, wantErr: true},
+		{name: "array without items", doc: `{"properties": {"a": {"type": "array"}}}`, wantErr: true},
+		{name: "array of arrays", doc: `{"properties": {"a": {"type": "array", "items": {"type": "array"}}}}`, wantErr: true},
+		{name: "invalid pattern", doc: `{"properties": {"a": {"type": "string", "pattern": "("}}}`, wantErr: true},
+		{name: "unknown writer", doc: `{"properties": {"a": {"type": "string", "x-write": ["app"]}}}`, wantErr: true},
+		{name: "reserved claim", doc: `{"properties": {"a": {"type": "string", "x-claim": "sub"}}}`, wantErr: true},
+		{name: "duplicate claim", doc: `{"properties": {"a": {"type": "string", "x-claim": "c"}, "b": {"type": "string", "x-claim": "c"}}}`, wantErr: true},
+		{name: "undeclared required", doc: `{"required": ["a"]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSchema) {
+				t.Errorf("Parse() error = %v, want ErrInvalidSchema", err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := mustParse(t, testSchema)
+	tests := []struct {
+		name    string
+		attrs   map[string]interface{}
+		wantErr bool
+	}{
+		{name: "required only", attrs: map[string]interface{}{"department": "sales"}},
+		{name: "every attribute", attrs: map[string]interface{}{
+			"department": "support", "nickname": "bob", "badge": "ABC12", "level": float64(2),
+			"score": 1.5, "active": true, "tags": []interface{}{"a", "b"},
+		}},
+		{name: "missing required", attrs: map[string]interface{}{"nickname": "bob"}, wantErr: true},
+		{name: "undeclared", attrs: map[string]interface{}{"department": "sales", "shoe": "42"}, wantErr: true},
+		{name: "not in enum", attrs: map[string]interface{}{"department": "legal"}, wantErr: true},
+		{name: "wrong type", attrs: map[string]interface{}{"department": "sales", "active": "yes"}, wantErr: true},
+		{name: "too short", attrs: map[string]interface{}{"department": "sales", "nickname": "b"}, wantErr: true},
+		{name: "too long", attrs: map[string]interface{}{"department": "sales", "nickname": "bobbyb"}, wantErr: true},
+		{name: "length counts characters", attrs: map[string]interface{}{"department": "sales", "nickname": "ёжик"}},
+		{name: "pattern mismatch", attrs: map[string]interface{}{"department": "sales", "badge": "abc12"}, wantErr: true},
+		{name: "fractional integer", attrs: map[string]interface{}{"department": "sales", "level": 1.5}, wantErr: true},
+		{name: "below minimum", attrs: map[string]interface{}{"department": "sales", "level": float64(0)}, wantErr: true},
+		{name: "above maximum", attrs: map[string]interface{}{"department": "sales", "level": float64(4)}, wantErr: true},
+		{name: "too many items", attrs: map[string]interface{}{"department": "sales", "tags": []interface{}{"a", "b", "a"}}, wantErr: true},
+		{name: "item not in enum", attrs: map[string]interface{}{"department": "sales", "tags": []interface{}{"c"}}, wantErr: true},
+		{name: "array expected", attrs: map[string]interface{}{"department": "sales", "tags": "a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate(tt.attrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidAttributes) {
+				t.Errorf("Validate() error = %v, want ErrInvalidAttributes", err)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	schema := mustParse(t, testSchema)
+	tests := []struct {
+		name    string
+		current map[string]interface{}
+		patch   map[string]interface{}
+		writer  string
+		want    map[string]interface{}
+		wantErr error
+	}{
+		{name: "admin sets an attribute", current: map[string]interface{}{"department": "sales"},
+			patch: map[string]interface{}{"level": float64(2)}, writer: WriterAdmin,
+			want: map[string]interface{}{"department": "sales", "level": float64(2)}},
+		{name: "user sets a user writable attribute", current: map[string]interface{}{"department": "sales"},
+			patch: map[string]interface{}{"nickname": "bob"}, writer: WriterUser,
+			want: map[string]interface{}{"department": "sales", "nickname": "bob"}},
+		{name: "user cannot set an admin attribute", current: map[string]interface{}{"department": "sales"},
+			patch: map[string]interface{}{"level": float64(2)}, writer: WriterUser, wantErr: ErrNotWritable},
+		{name: "null removes an attribute", current: map[string]interface{}{"department": "sales", "nickname": "bob"},
+			patch: map[string]interface{}{"nickname": nil}, writer: WriterUser,
+			want: map[string]interface{}{"department": "sales"}},
+		{name: "invalid value", current: map[string]interface{}{"department": "sales"},
+			patch: map[string]interface{}{"level": float64(9)}, writer: WriterAdmin, wantErr: ErrInvalidAttributes},
+		{name: "undeclared attribute", current: map[string]interface{}{"department": "sales"},
+			patch: map[string]interface{}{"shoe": "42"}, writer: WriterAdmin, wantErr: ErrInvalidAttributes},
+		{name: "null removes an attribute the schema dropped", current: map[string]interface{}{"department": "sales", "shoe": "42"},
+			patch: map[string]interface{}{"shoe": nil}, writer: WriterUser,
+			want: map[string]interface{}{"department": "sales"}},
+		{name: "stale values do not block other attributes", current: map[string]interface{}{"department": "legal", "shoe": "42"},
+			patch: map[string]interface{}{"nickname": "bob"}, writer: WriterUser,
+			want: map[string]interface{}{"department": "legal", "shoe": "42", "nickname": "bob"}},
+		{name: "admin must keep required attributes", current: map[string]interface{}{"department": "sales"},
+			patch: map[string]interface{}{"department": nil}, writer: WriterAdmin, wantErr: ErrInvalidAttributes},
+		{name: "admin must fill required attributes", current: map[string]interface{}{},
+			patch: map[string]interface{}{"level": float64(1)}, writer: WriterAdmin, wantErr: ErrInvalidAttributes},
+		{name: "user is not asked for attributes it cannot write", current: map[string]interface{}{},
+			patch: map[string]interface{}{"nickname": "bob"}, writer: WriterUser,
+			want: map[string]interface{}{"nickname": "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schema.Apply(tt.current, tt.patch, tt.writer)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Apply() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDoesNotModifyCurrent(t *testing.T) {
+	schema := mustParse(t, testSchema)
+	current := map[string]interface{}{"department": "sales", "nickname": "bob"}
+	if _, err := schema.Apply(current, map[string]interface{}{"nickname": nil}, WriterUser); err != nil {
+		t.Fatal(err)
+	}
+	if current["nickname"] != "bob" {
+		t.Error("Apply() modified the current attributes")
+	}
+}
+
+func TestClaims(t *testing.T) {
+	schema := mustParse(t, testSchema)
+	got := schema.Claims(map[string]interface{}{"department": "sales", "nickname": "bob"})
+	want := map[string]interface{}{"dept": "sales"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Claims() = %v, want %v", got, want)
+	}
+}
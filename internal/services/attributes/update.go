@@ -0,0 +1,47 @@
+package attributes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+	"ssoq/internal/storage"
+)
+
+// updateAttempts bounds the retries of an update that raced with another update of the same user
+const updateAttempts = 3
+
+// Store interface defines methods for reading and replacing the attributes of app members
+type Store interface {
+	UserAttributes(ctx context.Context, user_id int64, app_id int64) (map[string]interface{}, error)
+	SetUserAttributes(ctx context.Context, user_id int64, app_id int64, attrs map[string]interface{}, previous map[string]interface{}) error
+}
+
+// Update applies patch to the attributes of the user in app on behalf of writer and returns the new attributes
+// An update racing with another one is retried on the new attributes, so changes of different attributes are merged
+func Update(ctx context.Context, store Store, app *model.App, user_id int64, patch map[string]interface{}, writer string) (map[string]interface{}, error) {
+	const op = "attributes.Update"
+
+	schema, err := Parse(app.AttributeSchema)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	for attempt := 1; ; attempt++ {
+		current, err := store.UserAttributes(ctx, user_id, app.Id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		next, err := schema.Apply(current, patch, writer)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		err = store.SetUserAttributes(ctx, user_id, app.Id, next, current)
+		if errors.Is(err, storage.ErrUserChanged) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return next, nil
+	}
+}
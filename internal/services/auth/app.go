@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"slices"
+	"ssoq/internal/model"
+)
+
+// GrantTypePassword is the grant of gRPC logins with an email and password
+const GrantTypePassword = "password"
+
+// GrantAllowed reports whether app may use grant, apps without grant types allow every grant
+func GrantAllowed(app *model.App, grant string) bool {
+	return len(app.GrantTypes) == 0 || slices.Contains(app.GrantTypes, grant)
+}
+
+// checkApp returns ErrAppDisabled for a disabled app and ErrGrantNotAllowed when grant is set and not allowed for it
+func checkApp(app *model.App, grant string) error {
+	if !app.DisabledAt.IsZero() {
+		return ErrAppDisabled
+	}
+	if grant != "" && !GrantAllowed(app, grant) {
+		return ErrGrantNotAllowed
+	}
+	return nil
+}
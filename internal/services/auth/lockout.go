@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LockoutPolicy describes how failed logins slow down and lock an account
+type LockoutPolicy struct {
+	// Threshold is the number of consecutive failures that locks the account, 0 disables locking
+	Threshold int
+	// BaseDelay is the wait required after the first failure, doubled after every next one
+	BaseDelay time.Duration
+	// MaxDelay caps the progressive delay
+	MaxDelay time.Duration
+	// Duration is how long the account stays locked once Threshold is reached
+	Duration time.Duration
+	// FailureTime is the minimum duration of a failed login, it covers the time spent recording the failure
+	// so a wrong password of a known email answers no later than an unknown email
+	FailureTime time.Duration
+}
+
+// delay returns the minimum time between the last failure and the next attempt
+func (p LockoutPolicy) delay(failedAttempts int) time.Duration {
+	if p.BaseDelay <= 0 || failedAttempts <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < failedAttempts; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// ThrottledError is the error of a login attempted while the account is locked out, it wraps ErrLoginThrottled
+type ThrottledError struct {
+	// RetryAfter is how long until the next attempt is accepted
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return ErrLoginThrottled.Error()
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+// lockedOut reports whether the user may not attempt a login at now, because of a lock or a progressive delay,
+// and until when
+func (a *Auth) lockedOut(user *model.User, now time.Time) (time.Time, bool) {
+	if !user.LockedUntil.IsZero() {
+		// Once the lock has expired the counter starts over with the next failure
+		return user.LockedUntil, now.Before(user.LockedUntil)
+	}
+	delay := a.lockout.delay(user.FailedAttempts)
+	if delay <= 0 {
+		return time.Time{}, false
+	}
+	until := user.LastFailedAt.Add(delay)
+	return until, now.Before(until)
+}
+
+// padFailure waits until a failed login of op started at start has taken FailureTime
+// A failure already slower than FailureTime is logged, its duration may tell a known email from an unknown one
+func (a *Auth) padFailure(op string, start time.Time) {
+	elapsed := time.Since(start)
+	if wait := a.lockout.FailureTime - elapsed; wait > 0 {
+		time.Sleep(wait)
+		return
+	}
+	if a.lockout.FailureTime > 0 {
+		a.log.WithFields(logrus.Fields{
+			"op":           op,
+			"elapsed":      elapsed,
+			"failure_time": a.lockout.FailureTime,
+		}).Warn("failed login took longer than failure time, increase lockout failure time")
+	}
+}
+
+// registerFailedLogin records a failed password check and logs when it locks the account
+func (a *Auth) registerFailedLogin(ctx context.Context, user *model.User) {
+	attempts, lockedUntil, err := a.loginAttemptTracker.RegisterFailedLogin(ctx, user.Id, a.lockout.Threshold, a.lockout.Duration)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user.Id,
+			"error":   err,
+		}).Error("failed to register failed login")
+		return
+	}
+	if !lockedUntil.IsZero() {
+		a.log.WithFields(logrus.Fields{
+			"user_id":         user.Id,
+			"failed_attempts": attempts,
+			"locked_until":    lockedUntil,
+		}).Warn("account locked after too many failed logins")
+	}
+}
+
+// UnlockUser clears the failed login counter and lock of a user, administrators do it by enabling the user
+func (a *Auth) UnlockUser(ctx context.Context, user_id int64) error {
+	const op = "auth.UnlockUser"
+
+	if err := a.loginAttemptTracker.ResetFailedLogins(ctx, user_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to unlock user")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithField("user_id", user_id).Info("user unlocked")
+	return nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+	"testing"
+	"time"
+)
+
+func TestLockoutDelay(t *testing.T) {
+	policy := LockoutPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		name           string
+		policy         LockoutPolicy
+		failedAttempts int
+		want           time.Duration
+	}{
+		{name: "no failures", policy: policy, failedAttempts: 0, want: 0},
+		{name: "first failure", policy: policy, failedAttempts: 1, want: time.Second},
+		{name: "second failure doubles", policy: policy, failedAttempts: 2, want: 2 * time.Second},
+		{name: "fourth failure", policy: policy, failedAttempts: 4, want: 8 * time.Second},
+		{name: "capped at max delay", policy: policy, failedAttempts: 5, want: 10 * time.Second},
+		{name: "many failures stay capped", policy: policy, failedAttempts: 100, want: 10 * time.Second},
+		{name: "no cap", policy: LockoutPolicy{BaseDelay: time.Second}, failedAttempts: 6, want: 32 * time.Second},
+		{name: "delay disabled", policy: LockoutPolicy{MaxDelay: time.Minute}, failedAttempts: 3, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.failedAttempts); got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.failedAttempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockedOut(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := &Auth{lockout: LockoutPolicy{Threshold: 5, BaseDelay: time.Second, MaxDelay: time.Minute, Duration: 15 * time.Minute}}
+
+	tests := []struct {
+		name       string
+		user       model.User
+		wantLocked bool
+		wantUntil  time.Time
+	}{
+		{
+			name: "no failures",
+			user: model.User{},
+		},
+		{
+			name:       "inside backoff",
+			user:       model.User{FailedAttempts: 3, LastFailedAt: now.Add(-3 * time.Second)},
+			wantLocked: true,
+			wantUntil:  now.Add(time.Second),
+		},
+		{
+			name:      "backoff passed",
+			user:      model.User{FailedAttempts: 3, LastFailedAt: now.Add(-4 * time.Second)},
+			wantUntil: now,
+		},
+		{
+			name:       "threshold lock",
+			user:       model.User{FailedAttempts: 5, LastFailedAt: now.Add(-2 * time.Minute), LockedUntil: now.Add(13 * time.Minute)},
+			wantLocked: true,
+			wantUntil:  now.Add(13 * time.Minute),
+		},
+		{
+			name:      "lock cooled down",
+			user:      model.User{FailedAttempts: 5, LastFailedAt: now.Add(-20 * time.Minute), LockedUntil: now.Add(-5 * time.Minute)},
+			wantUntil: now.Add(-5 * time.Minute),
+		},
+		{
+			// An expired lock ends the backoff as well, the counter starts over with the next failure
+			name:      "expired lock ignores backoff",
+			user:      model.User{FailedAttempts: 8, LastFailedAt: now.Add(-time.Second), LockedUntil: now.Add(-time.Millisecond)},
+			wantUntil: now.Add(-time.Millisecond),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, locked := a.lockedOut(&tt.user, now)
+			if locked != tt.wantLocked {
+				t.Errorf("lockedOut() locked = %v, want %v", locked, tt.wantLocked)
+			}
+			if !until.Equal(tt.wantUntil) {
+				t.Errorf("lockedOut() until = %v, want %v", until, tt.wantUntil)
+			}
+		})
+	}
+}
+
+func TestThrottledError(t *testing.T) {
+	err := fmt.Errorf("auth.Authenticate: %w", &ThrottledError{RetryAfter: 30 * time.Second})
+
+	if !errors.Is(err, ErrLoginThrottled) {
+		t.Errorf("errors.Is(%v, ErrLoginThrottled) = false", err)
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("errors.Is(%v, ErrInvalidCredentials) = true", err)
+	}
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || throttled.RetryAfter != 30*time.Second {
+		t.Errorf("errors.As(%v) = %+v, want RetryAfter 30s", err, throttled)
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogoutNotifier interface defines how apps are told that a user logged out
+type LogoutNotifier interface {
+	Notify(user_id int64, session_id int64, apps []model.App)
+}
+
+// LogoutUser removes the user's session and notifies the apps of the user through their back-channel logout URIs
+// It returns the apps of the user so a browser can also be sent to their front-channel logout URIs
+func (a *Auth) LogoutUser(ctx context.Context, user_id int64) ([]model.App, error) {
+	const op = "auth.LogoutUser"
+
+	if err := a.tokenProvider.DeleteToken(ctx, user_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to delete token from provider")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	apps, err := a.appMembership.UserApps(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to list apps of the user")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	a.logoutNotifier.Notify(user_id, 0, apps)
+	return apps, nil
+}
+
+// LogoutAll signs the user out everywhere: it revokes every session and bumps the user's token version,
+// so outstanding access and refresh tokens of every app are rejected, and notifies the apps of the user
+func (a *Auth) LogoutAll(ctx context.Context, user_id int64) error {
+	const op = "auth.LogoutAll"
+
+	version, err := a.tokenProvider.RevokeUserSessions(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to revoke user sessions")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	apps, err := a.appMembership.UserApps(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to list apps of the user")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.logoutNotifier.Notify(user_id, 0, apps)
+
+	a.log.WithFields(logrus.Fields{
+		"user_id":       user_id,
+		"token_version": version,
+	}).Warn("user logged out everywhere")
+	return nil
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AppMembership interface defines methods for managing which apps a user may sign in to
+type AppMembership interface {
+	AddUserApp(ctx context.Context, user_id int64, app_id int64) error
+	RemoveUserApp(ctx context.Context, user_id int64, app_id int64) error
+	HasUserApp(ctx context.Context, user_id int64, app_id int64) (bool, error)
+	UserApps(ctx context.Context, user_id int64) ([]model.App, error)
+}
+
+// checkAppAccess returns ErrAppAccessDenied when the user is not a member of the app
+func (a *Auth) checkAppAccess(ctx context.Context, user_id int64, app_id int64) error {
+	ok, err := a.appMembership.HasUserApp(ctx, user_id, app_id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAppAccessDenied
+	}
+	return nil
+}
+
+// GrantAppAccess makes the user a member of the app so it can obtain tokens for it
+func (a *Auth) GrantAppAccess(ctx context.Context, user_id int64, app_id int64) error {
+	const op = "auth.GrantAppAccess"
+
+	if a.tenancy == TenancyPerApp {
+		return fmt.Errorf("%s: identities are isolated per app in per_app tenancy mode", op)
+	}
+
+	if _, err := a.appProvider.App(ctx, app_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"op":     op,
+			"error":  err,
+		}).Error("failed to get app from provider")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	user, err := a.userProvider.GetUserByID(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to get user by ID")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if user == nil {
+		return fmt.Errorf("%s: user not found", op)
+	}
+
+	if err := a.appMembership.AddUserApp(ctx, user_id, app_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"app_id":  app_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to grant app access")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"user_id": user_id,
+		"app_id":  app_id,
+	}).Info("app access granted")
+	return nil
+}
+
+// RevokeAppAccess removes the user from the app, its refresh tokens for the app stop working
+// The app is notified through its back-channel logout URI
+func (a *Auth) RevokeAppAccess(ctx context.Context, user_id int64, app_id int64) error {
+	const op = "auth.RevokeAppAccess"
+
+	app, err := a.appProvider.App(ctx, app_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"op":     op,
+			"error":  err,
+		}).Error("failed to get app from provider")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.appMembership.RemoveUserApp(ctx, user_id, app_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"app_id":  app_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to revoke app access")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"user_id": user_id,
+		"app_id":  app_id,
+	}).Info("app access revoked")
+	a.logoutNotifier.Notify(user_id, 0, []model.App{*app})
+	return nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword peppers the password with the current key and hashes it with bcrypt
+// It returns the hash together with the pepper version it was created with
+func (a *Auth) hashPassword(password string) (string, int, error) {
+	version := a.pepper.Current()
+	peppered, err := a.pepper.Apply(password, version)
+	if err != nil {
+		return "", 0, err
+	}
+	hash, err := bcrypt.GenerateFromPassword(peppered, bcrypt.DefaultCost)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(hash), version, nil
+}
+
+// comparePassword checks the password against the user's hash using the pepper version stored with it
+func (a *Auth) comparePassword(hash []byte, pepperVersion int, password string) error {
+	peppered, err := a.pepper.Apply(password, pepperVersion)
+	if err != nil {
+		return err
+	}
+	return bcrypt.CompareHashAndPassword(hash, peppered)
+}
+
+// rehashIfNeeded rehashes the password with the current pepper key when the stored hash uses an older one
+// Failures are only logged, the user is already authenticated at this point
+func (a *Auth) rehashIfNeeded(ctx context.Context, user_id int64, pepperVersion int, password string) {
+	if pepperVersion == a.pepper.Current() {
+		return
+	}
+	hash, version, err := a.hashPassword(password)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"error":   err,
+		}).Warn("failed to rehash password with current pepper")
+		return
+	}
+	if err := a.passwordUpdater.UpdatePassword(ctx, user_id, hash, version); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"error":   err,
+		}).Warn("failed to save rehashed password")
+		return
+	}
+	a.log.WithFields(logrus.Fields{
+		"user_id":     user_id,
+		"old_version": pepperVersion,
+		"new_version": version,
+	}).Info("password rehashed with current pepper")
+}
+
+// compareDummyPassword runs the same pepper and bcrypt work as comparePassword against a hash no password matches
+func (a *Auth) compareDummyPassword(password string) {
+	peppered, err := a.pepper.Apply(password, a.pepper.Current())
+	if err != nil {
+		peppered = []byte(password)
+	}
+	_ = bcrypt.CompareHashAndPassword(a.dummyHash, peppered)
+}
+
+// VerifyPassword re-authenticates a signed in user before a sensitive change, such as a new email
+// It applies the lockout policy like a login, so a stolen access token cannot be used to guess the password
+func (a *Auth) VerifyPassword(ctx context.Context, user_id int64, password string) error {
+	const op = "auth.VerifyPassword"
+
+	start := time.Now()
+	user, err := a.userProvider.GetUserByID(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+			"error":   err,
+		}).Error("failed to get user by ID")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if user == nil {
+		a.compareDummyPassword(password)
+		a.padFailure(op, start)
+		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+	if until, locked := a.lockedOut(user, time.Now()); locked {
+		a.compareDummyPassword(password)
+		a.padFailure(op, start)
+		return fmt.Errorf("%s: %w", op, &ThrottledError{RetryAfter: time.Until(until)})
+	}
+	if err := a.comparePassword(user.Password, user.PepperVersion, password); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+		}).Warn("invalid password provided for re-authentication")
+		a.registerFailedLogin(ctx, user)
+		a.padFailure(op, start)
+		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+	if user.FailedAttempts > 0 || !user.LockedUntil.IsZero() {
+		if err := a.loginAttemptTracker.ResetFailedLogins(ctx, user.Id); err != nil {
+			a.log.WithFields(logrus.Fields{
+				"user_id": user.Id,
+				"error":   err,
+			}).Warn("failed to reset failed logins")
+		}
+	}
+	return nil
+}
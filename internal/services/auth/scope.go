@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+// ParseScope splits a space separated OAuth2 scope string into distinct scopes
+func ParseScope(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// GrantScopes returns the requested scopes allowed for the app, all allowed scopes when none are requested
+// It returns ErrInvalidScope when scopes were requested but none of them is allowed
+func GrantScopes(requested []string, allowed []string) ([]string, error) {
+	if len(requested) == 0 {
+		return slices.Clone(allowed), nil
+	}
+	granted := make([]string, 0, len(requested))
+	for _, s := range requested {
+		if slices.Contains(allowed, s) {
+			granted = append(granted, s)
+		}
+	}
+	if len(granted) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return granted, nil
+}
+
+// narrowScopes returns the requested subset of an existing grant, the whole grant when none is requested
+// Requesting a scope outside of the grant returns ErrInvalidScope, a refresh can never widen the grant
+func narrowScopes(requested []string, grant []string, allowed []string) ([]string, error) {
+	for _, s := range requested {
+		if !slices.Contains(grant, s) {
+			return nil, ErrInvalidScope
+		}
+	}
+	if len(requested) == 0 {
+		requested = grant
+	}
+	// Scopes removed from the app since the grant are not issued anymore
+	scopes := make([]string, 0, len(requested))
+	for _, s := range requested {
+		if slices.Contains(allowed, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		want  []string
+	}{
+		{name: "empty", scope: "", want: nil},
+		{name: "single", scope: "openid", want: []string{"openid"}},
+		{name: "extra whitespace", scope: "  openid \t profile\n", want: []string{"openid", "profile"}},
+		{name: "duplicates", scope: "email openid email", want: []string{"email", "openid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseScope(tt.scope); !slices.Equal(got, tt.want) {
+				t.Errorf("ParseScope(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantScopes(t *testing.T) {
+	allowed := []string{"openid", "profile", "email"}
+	tests := []struct {
+		name      string
+		requested []string
+		want      []string
+		wantErr   error
+	}{
+		{name: "nothing requested grants everything allowed", requested: nil, want: allowed},
+		{name: "subset", requested: []string{"email", "openid"}, want: []string{"email", "openid"}},
+		{name: "unknown scopes are dropped", requested: []string{"openid", "admin"}, want: []string{"openid"}},
+		{name: "only unknown scopes", requested: []string{"admin"}, wantErr: ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GrantScopes(tt.requested, allowed)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GrantScopes() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("GrantScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantScopesDoesNotAliasAllowed(t *testing.T) {
+	allowed := []string{"openid", "profile"}
+	got, err := GrantScopes(nil, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got[0] = "changed"
+	if allowed[0] != "openid" {
+		t.Error("GrantScopes() returned the allowed slice itself")
+	}
+}
+
+func TestNarrowScopes(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		grant     []string
+		allowed   []string
+		want      []string
+		wantErr   error
+	}{
+		{name: "nothing requested keeps the grant", requested: nil, grant: []string{"openid", "email"},
+			allowed: []string{"openid", "email", "profile"}, want: []string{"openid", "email"}},
+		{name: "narrowed", requested: []string{"email"}, grant: []string{"openid", "email"},
+			allowed: []string{"openid", "email"}, want: []string{"email"}},
+		{name: "widening is refused", requested: []string{"openid", "profile"}, grant: []string{"openid"},
+			allowed: []string{"openid", "profile"}, wantErr: ErrInvalidScope},
+		{name: "scopes removed from the app are dropped", requested: nil, grant: []string{"openid", "email"},
+			allowed: []string{"openid"}, want: []string{"openid"}},
+		{name: "every granted scope removed", requested: []string{"email"}, grant: []string{"email"},
+			allowed: nil, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := narrowScopes(tt.requested, tt.grant, tt.allowed)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("narrowScopes() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("narrowScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
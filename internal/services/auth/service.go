@@ -2,9 +2,12 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	providerjwt "ssoq/internal/jwt"
 	"ssoq/internal/model"
+	"ssoq/internal/pepper"
+	"ssoq/internal/storage"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,60 +15,142 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	// ErrAccountLocked is returned when an administrator has locked the account
+	ErrAccountLocked = errors.New("account is locked")
+	// ErrAccountDisabled is returned when an administrator has disabled the account
+	ErrAccountDisabled = errors.New("account is disabled")
+	// ErrAccountNotVerified is returned when the account is waiting for verification
+	ErrAccountNotVerified = errors.New("account is not verified")
+	// ErrInvalidCredentials is returned for an unknown email and a wrong password alike,
+	// so the response never tells whether the email exists
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrLoginThrottled is returned when failed logins have locked the account or its progressive delay has not passed,
+	// the returned error is a *ThrottledError telling when to retry
+	ErrLoginThrottled = errors.New("too many failed login attempts, retry later")
+	// ErrAppAccessDenied is returned when the user is not a member of the requested app
+	ErrAppAccessDenied = errors.New("user has no access to this app")
+	// ErrAppDisabled is returned when an administrator has disabled the app
+	ErrAppDisabled = errors.New("app is disabled")
+	// ErrGrantNotAllowed is returned when the app is not allowed to use the grant type
+	ErrGrantNotAllowed = errors.New("grant type is not allowed for this app")
+	// ErrInvalidToken is returned when a token is malformed, expired, badly signed or of the wrong purpose
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrPermissionDenied is returned when a verified token lacks a required permission
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrInvalidScope is returned when the requested scopes are not allowed for the app or widen the grant
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
 // Auth represents the authentication service that handles user authentication operations
 type Auth struct {
-	log           *logrus.Logger
-	userSaver     UserSaver
-	userProvider UserProvider
-	appProvider   AppProvider
-	tokenSaver    TokenSaver
-	tokenProvider TokenProvider
-	tokenTTL      time.Duration
+	log                 *logrus.Logger
+	userSaver           UserSaver
+	userProvider        UserProvider
+	appProvider         AppProvider
+	tokenSaver          TokenSaver
+	tokenProvider       TokenProvider
+	passwordUpdater     PasswordUpdater
+	loginAttemptTracker LoginAttemptTracker
+	appMembership       AppMembership
+	roleProvider        RoleProvider
+	attributeProvider   AttributeProvider
+	logoutNotifier      LogoutNotifier
+	pepper              *pepper.Pepper
+	dummyHash           []byte
+	lockout             LockoutPolicy
+	tenancy             TenancyMode
+	admin               AdminPolicy
+	tokenTTL            time.Duration
 }
 
 // UserSaver interface defines methods for saving user data
 type UserSaver interface {
-	SaveUser(ctx context.Context, email string, password string, username string, app_id int64) (int64, error)
+	SaveUser(ctx context.Context, tenant_app_id int64, email string, password string, pepperVersion int, username string, app_id int64) (int64, error)
+}
+
+// PasswordUpdater interface defines methods for replacing stored password hashes
+type PasswordUpdater interface {
+	UpdatePassword(ctx context.Context, user_id int64, password string, pepperVersion int) error
 }
 
 // UserProvider interface defines methods for retrieving user data
 type UserProvider interface {
-	GetUser(ctx context.Context, email string) (*model.User, error)
+	GetUser(ctx context.Context, tenant_app_id int64, email string) (*model.User, error)
 	GetUserByID(ctx context.Context, id int64) (*model.User, error)
 }
 
+// LoginAttemptTracker interface defines methods for counting failed logins
+type LoginAttemptTracker interface {
+	RegisterFailedLogin(ctx context.Context, user_id int64, threshold int, lockFor time.Duration) (int, time.Time, error)
+	ResetFailedLogins(ctx context.Context, user_id int64) error
+}
+
+// RoleProvider interface defines methods for retrieving the roles a user holds within an app
+type RoleProvider interface {
+	UserAuthorization(ctx context.Context, user_id int64, app_id int64) ([]string, []string, error)
+}
+
+// AttributeProvider interface defines methods for retrieving the attributes a user holds within an app
+type AttributeProvider interface {
+	UserAttributes(ctx context.Context, user_id int64, app_id int64) (map[string]interface{}, error)
+}
+
 // AppProvider interface defines methods for retrieving application data
 type AppProvider interface {
 	App(ctx context.Context, app_id int64) (*model.App, error)
 }
 
-// TokenSaver interface defines methods for saving tokens
+// TokenSaver interface defines methods for opening sessions, each holding a refresh token
 type TokenSaver interface {
-	SaveToken(ctx context.Context, user_id int64, token string) error
+	NewSessionID(ctx context.Context) (int64, error)
+	SaveSession(ctx context.Context, session *model.Session) error
 }
 
-// TokenProvider interface defines methods for managing tokens
+// TokenProvider interface defines methods for rotating and revoking the refresh tokens of sessions
 type TokenProvider interface {
 	DeleteToken(ctx context.Context, user_id int64) error
-	GetToken(ctx context.Context, user_id int64) (string, error)
+	RotateSessionToken(ctx context.Context, session_id int64, oldToken string, newToken string) error
+	SessionIDByToken(ctx context.Context, user_id int64, token string) (int64, error)
+	SessionExists(ctx context.Context, user_id int64, session_id int64) (bool, error)
+	DeleteSession(ctx context.Context, user_id int64, session_id int64) (int64, error)
+	RevokeUserSessions(ctx context.Context, user_id int64) (int, error)
 }
 
 // NewAuth creates a new instance of the Auth service with the provided dependencies
-func NewAuth(log *logrus.Logger, userSaver UserSaver, userProvider UserProvider, appProvider AppProvider, tokenSaver TokenSaver, tokenProvider TokenProvider, tokenTTL time.Duration) *Auth {
+// pepper may be nil, in which case passwords are hashed without a server-side key
+func NewAuth(log *logrus.Logger, userSaver UserSaver, userProvider UserProvider, appProvider AppProvider, tokenSaver TokenSaver, tokenProvider TokenProvider, passwordUpdater PasswordUpdater, loginAttemptTracker LoginAttemptTracker, appMembership AppMembership, roleProvider RoleProvider, attributeProvider AttributeProvider, logoutNotifier LogoutNotifier, pepper *pepper.Pepper, lockout LockoutPolicy, tenancy TenancyMode, admin AdminPolicy, tokenTTL time.Duration) *Auth {
+	// The dummy hash uses the same cost as real hashes so comparing against it takes as long
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("timing-safe-dummy-password"), bcrypt.DefaultCost)
+	if err != nil {
+		log.WithField("error", err).Error("failed to generate dummy password hash")
+	}
 	return &Auth{
-		log:           log,
-		userSaver:     userSaver,
-		userProvider:  userProvider,
-		appProvider:   appProvider,
-		tokenSaver:    tokenSaver,
-		tokenProvider: tokenProvider,
-		tokenTTL:      tokenTTL,
+		log:                 log,
+		userSaver:           userSaver,
+		userProvider:        userProvider,
+		appProvider:         appProvider,
+		tokenSaver:          tokenSaver,
+		tokenProvider:       tokenProvider,
+		passwordUpdater:     passwordUpdater,
+		loginAttemptTracker: loginAttemptTracker,
+		appMembership:       appMembership,
+		roleProvider:        roleProvider,
+		attributeProvider:   attributeProvider,
+		logoutNotifier:      logoutNotifier,
+		pepper:              pepper,
+		dummyHash:           dummyHash,
+		lockout:             lockout,
+		tenancy:             tenancy,
+		admin:               admin,
+		tokenTTL:            tokenTTL,
 	}
 }
 
 // Login authenticates a user with email and password, and returns access and refresh tokens if successful
 // It validates credentials, checks user existence, verifies password, and generates JWT tokens
-func (a *Auth) Login(ctx context.Context, email string, password string, app_id int64) (bool, string, string, error) {
+// scope is a space separated list of requested scopes, the tokens get the ones allowed for the app
+func (a *Auth) Login(ctx context.Context, email string, password string, app_id int64, scope string) (bool, string, string, error) {
 	if email == "" || password == "" {
 		a.log.WithFields(logrus.Fields{
 			"email":  email,
@@ -74,20 +159,8 @@ func (a *Auth) Login(ctx context.Context, email string, password string, app_id
 		return false, "", "", fmt.Errorf("email and password are required")
 	}
 
-	user, err := a.userProvider.GetUser(ctx, email)
+	user, err := a.Authenticate(ctx, email, password, app_id)
 	if err != nil {
-		a.log.WithFields(logrus.Fields{
-			"email": email,
-			"error": err,
-		}).Error("failed to get user from provider")
-		return false, "", "", err
-	}
-	if user == nil {
-		a.log.WithField("email", email).Warn("user not found during login")
-		return false, "", "", nil
-	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		a.log.WithField("email", email).Warn("invalid password provided")
 		return false, "", "", err
 	}
 	app, err := a.appProvider.App(ctx, app_id)
@@ -98,21 +171,27 @@ func (a *Auth) Login(ctx context.Context, email string, password string, app_id
 		}).Error("failed to get app from provider")
 		return false, "", "", fmt.Errorf("appProvider.App: %w", err)
 	}
-
-	access_token, refresh_token, err := providerjwt.GenerateToken(app, user, a.tokenTTL)
-	if err != nil {
+	if err := checkApp(app, GrantTypePassword); err != nil {
 		a.log.WithFields(logrus.Fields{
 			"user_id": user.Id,
 			"app_id":  app_id,
 			"error":   err,
-		}).Error("failed to generate tokens")
+		}).Warn("login rejected for app")
 		return false, "", "", err
 	}
-	if err := a.tokenSaver.SaveToken(ctx, user.Id, refresh_token); err != nil {
+
+	scopes, err := GrantScopes(ParseScope(scope), app.Scopes)
+	if err != nil {
 		a.log.WithFields(logrus.Fields{
 			"user_id": user.Id,
-			"error":   err,
-		}).Error("failed to save refresh token")
+			"app_id":  app_id,
+			"scope":   scope,
+		}).Warn("requested scopes are not allowed for app")
+		return false, "", "", err
+	}
+
+	access_token, refresh_token, _, err := a.IssueTokens(ctx, user, app, scopes)
+	if err != nil {
 		return false, "", "", err
 	}
 	a.log.WithFields(logrus.Fields{
@@ -123,6 +202,130 @@ func (a *Auth) Login(ctx context.Context, email string, password string, app_id
 	return true, access_token, refresh_token, nil
 }
 
+// Authenticate verifies the email and password of a user of app_id without issuing tokens
+// It applies the lockout policy, keeps unknown emails and wrong passwords indistinguishable and checks app membership
+func (a *Auth) Authenticate(ctx context.Context, email string, password string, app_id int64) (*model.User, error) {
+	const op = "auth.Authenticate"
+
+	start := time.Now()
+	user, err := a.userProvider.GetUser(ctx, a.namespace(app_id), email)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"email": email,
+			"error": err,
+		}).Error("failed to get user from provider")
+		return nil, err
+	}
+	// A deleted user keeps its email until the retention period ends, it logs in like an unknown one
+	if user == nil || user.Status == model.UserStatusDeleted {
+		// Spend the same bcrypt work as for a wrong password so timing does not reveal the email exists
+		a.compareDummyPassword(password)
+		a.log.WithField("email", email).Warn("user not found during login")
+		a.padFailure(op, start)
+		return nil, ErrInvalidCredentials
+	}
+	// The password is not checked while the account is locked out. The answer tells the client when to retry,
+	// so it reveals that a throttled email exists: the lockout policy accepts that in exchange for a usable error
+	if until, locked := a.lockedOut(user, time.Now()); locked {
+		a.compareDummyPassword(password)
+		a.log.WithFields(logrus.Fields{
+			"user_id":      user.Id,
+			"locked_until": until,
+		}).Warn("login rejected by lockout policy")
+		a.padFailure(op, start)
+		return nil, &ThrottledError{RetryAfter: time.Until(until)}
+	}
+	if err := a.comparePassword(user.Password, user.PepperVersion, password); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"email": email,
+			"error": err,
+		}).Warn("invalid password provided")
+		// Recorded before answering so the next attempt sees the failure, the pad hides the time it takes
+		a.registerFailedLogin(ctx, user)
+		a.padFailure(op, start)
+		return nil, ErrInvalidCredentials
+	}
+	// Checked after the password so the state of an account is only revealed to its owner
+	if err := checkAccount(user); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user.Id,
+			"status":  user.Status,
+		}).Warn("login rejected for account status")
+		return nil, err
+	}
+	if user.FailedAttempts > 0 || !user.LockedUntil.IsZero() {
+		if err := a.loginAttemptTracker.ResetFailedLogins(ctx, user.Id); err != nil {
+			a.log.WithFields(logrus.Fields{
+				"user_id": user.Id,
+				"error":   err,
+			}).Warn("failed to reset failed logins")
+		}
+	}
+	a.rehashIfNeeded(ctx, user.Id, user.PepperVersion, password)
+	if err := a.checkAppAccess(ctx, user.Id, app_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user.Id,
+			"app_id":  app_id,
+			"error":   err,
+		}).Warn("login rejected for app")
+		return nil, err
+	}
+	return user, nil
+}
+
+// IssueTokens opens a session for an authenticated user and generates its access and refresh token pair
+// scopes must already be granted for the app, see GrantScopes, the client of the session is taken from ctx
+// It also returns the id of the session, ID tokens carry it as sid
+func (a *Auth) IssueTokens(ctx context.Context, user *model.User, app *model.App, scopes []string) (string, string, int64, error) {
+	if err := checkAccount(user); err != nil {
+		return "", "", 0, err
+	}
+	if err := checkApp(app, ""); err != nil {
+		return "", "", 0, err
+	}
+	authz, err := a.authorization(ctx, user.Id, app)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user.Id,
+			"app_id":  app.Id,
+			"error":   err,
+		}).Error("failed to get user roles")
+		return "", "", 0, err
+	}
+	authz.Scopes, authz.GrantScopes = scopes, scopes
+	authz.SessionID, err = a.tokenSaver.NewSessionID(ctx)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	access_token, refresh_token, err := providerjwt.GenerateToken(app, user, authz, a.tokenTTL)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user.Id,
+			"app_id":  app.Id,
+			"error":   err,
+		}).Error("failed to generate tokens")
+		return "", "", 0, err
+	}
+	client := ClientInfoFromContext(ctx)
+	err = a.tokenSaver.SaveSession(ctx, &model.Session{
+		Id:           authz.SessionID,
+		UserId:       user.Id,
+		AppId:        app.Id,
+		RefreshToken: refresh_token,
+		UserAgent:    client.UserAgent,
+		IP:           client.IP,
+	})
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user.Id,
+			"error":   err,
+		}).Error("failed to save session")
+		return "", "", 0, err
+	}
+	return access_token, refresh_token, authz.SessionID, nil
+}
+
 // Register creates a new user with the provided email, password, username and app_id
 // It validates input parameters, encrypts the password, and saves the user to the database
 func (a *Auth) Register(ctx context.Context, email string, password string, username string, app_id int64) (bool, int64, error) {
@@ -140,7 +343,7 @@ func (a *Auth) Register(ctx context.Context, email string, password string, user
 		return false, 0, fmt.Errorf("password is too short")
 	}
 
-	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	encryptedPassword, pepperVersion, err := a.hashPassword(password)
 	if err != nil {
 		a.log.WithFields(logrus.Fields{
 			"email": email,
@@ -148,7 +351,7 @@ func (a *Auth) Register(ctx context.Context, email string, password string, user
 		}).Error("failed to encrypt password")
 		return false, 0, err
 	}
-	user_id, err := a.userSaver.SaveUser(ctx, email, string(encryptedPassword), username, app_id)
+	user_id, err := a.userSaver.SaveUser(ctx, a.namespace(app_id), email, encryptedPassword, pepperVersion, username, app_id)
 	if err != nil {
 		a.log.WithFields(logrus.Fields{
 			"email":  email,
@@ -167,7 +370,7 @@ func (a *Auth) Register(ctx context.Context, email string, password string, user
 }
 
 // Logout invalidates a user's refresh token, effectively logging them out
-// It verifies the token, extracts user information, and removes the token from storage
+// It verifies the token and ends its session, tokens issued before sessions carried an id end every session
 func (a *Auth) Logout(ctx context.Context, providedToken string, app_id int64) (bool, error) {
 	const op = "auth.Logout"
 
@@ -210,13 +413,11 @@ func (a *Auth) Logout(ctx context.Context, providedToken string, app_id int64) (
 	}
 	userID := int64(userIDFloat)
 
-	if err := a.tokenProvider.DeleteToken(ctx, userID); err != nil {
-		a.log.WithFields(logrus.Fields{
-			"user_id": userID,
-			"app_id":  app_id,
-			"op":      op,
-			"error":   err,
-		}).Error("failed to delete token from provider")
+	if session_id := claimInt64(claims, "sid"); session_id != 0 {
+		if err := a.RevokeSession(ctx, userID, session_id); err != nil && !errors.Is(err, storage.ErrSessionNotFound) {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+	} else if _, err := a.LogoutUser(ctx, userID); err != nil {
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -229,7 +430,8 @@ func (a *Auth) Logout(ctx context.Context, providedToken string, app_id int64) (
 
 // RefreshToken generates new access and refresh tokens using an existing refresh token
 // It validates the provided token, verifies it against the database, and generates new token pair
-func (a *Auth) RefreshToken(ctx context.Context, providedToken string, app_id int64) (string, string, error) {
+// scope may narrow the access token to a subset of the original grant, the refresh token keeps the grant
+func (a *Auth) RefreshToken(ctx context.Context, providedToken string, app_id int64, scope string) (string, string, error) {
 	const op = "auth.RefreshToken"
 
 	app, err := a.appProvider.App(ctx, app_id)
@@ -241,6 +443,14 @@ func (a *Auth) RefreshToken(ctx context.Context, providedToken string, app_id in
 		}).Error("failed to get app from provider")
 		return "", "", fmt.Errorf("%s: %w", op, err)
 	}
+	if err := checkApp(app, "refresh_token"); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"error":  err,
+			"op":     op,
+		}).Warn("token refresh rejected for app")
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
 
 	token, err := providerjwt.ParseToken(providedToken, app)
 	if err != nil {
@@ -280,19 +490,40 @@ func (a *Auth) RefreshToken(ctx context.Context, providedToken string, app_id in
 		return "", "", fmt.Errorf("%s: invalid token purpose", op)
 	}
 
-	// Compare with token in DB
-	dbToken, err := a.tokenProvider.GetToken(ctx, userID)
+	// Refresh tokens issued before sessions carried an id are matched by value
+	session_id := claimInt64(claims, "sid")
+	if session_id == 0 {
+		session_id, err = a.tokenProvider.SessionIDByToken(ctx, userID, providedToken)
+		if err != nil {
+			a.log.WithFields(logrus.Fields{
+				"user_id": userID,
+				"app_id":  app_id,
+				"op":      op,
+				"error":   err,
+			}).Error("token is revoked or invalid")
+			return "", "", fmt.Errorf("%s: token is revoked or invalid", op)
+		}
+	}
+
+	user, err := a.userProvider.GetUserByID(ctx, userID)
 	if err != nil {
 		a.log.WithFields(logrus.Fields{
 			"user_id": userID,
 			"app_id":  app_id,
 			"op":      op,
 			"error":   err,
-		}).Error("failed to get token from provider")
+		}).Error("failed to get user by ID")
 		return "", "", fmt.Errorf("%s: %w", op, err)
 	}
-
-	if dbToken == "" || dbToken != providedToken {
+	if user == nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": userID,
+			"app_id":  app_id,
+			"op":      op,
+		}).Error("user not found for token refresh")
+		return "", "", fmt.Errorf("%s: user not found", op)
+	}
+	if tokenVersion(claims) != user.TokenVersion {
 		a.log.WithFields(logrus.Fields{
 			"user_id": userID,
 			"app_id":  app_id,
@@ -300,28 +531,51 @@ func (a *Auth) RefreshToken(ctx context.Context, providedToken string, app_id in
 		}).Error("token is revoked or invalid")
 		return "", "", fmt.Errorf("%s: token is revoked or invalid", op)
 	}
+	if err := checkAccount(user); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": userID,
+			"app_id":  app_id,
+			"status":  user.Status,
+			"op":      op,
+		}).Warn("token refresh rejected for account status")
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.checkAppAccess(ctx, user.Id, app_id); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": userID,
+			"app_id":  app_id,
+			"op":      op,
+			"error":   err,
+		}).Warn("token refresh rejected for app")
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
 
-	user, err := a.userProvider.GetUserByID(ctx, userID)
+	authz, err := a.authorization(ctx, user.Id, app)
 	if err != nil {
 		a.log.WithFields(logrus.Fields{
 			"user_id": userID,
 			"app_id":  app_id,
 			"op":      op,
 			"error":   err,
-		}).Error("failed to get user by ID")
+		}).Error("failed to get user roles")
 		return "", "", fmt.Errorf("%s: %w", op, err)
 	}
-	if user == nil {
+	grant, _ := claims["scope"].(string)
+	authz.GrantScopes = ParseScope(grant)
+	authz.Scopes, err = narrowScopes(ParseScope(scope), authz.GrantScopes, app.Scopes)
+	if err != nil {
 		a.log.WithFields(logrus.Fields{
 			"user_id": userID,
 			"app_id":  app_id,
 			"op":      op,
-		}).Error("user not found for token refresh")
-		return "", "", fmt.Errorf("%s: user not found", op)
+			"scope":   scope,
+		}).Warn("requested scopes widen the original grant")
+		return "", "", fmt.Errorf("%s: %w", op, err)
 	}
 
 	// Generate new pair
-	accessToken, newRefreshToken, err := providerjwt.GenerateToken(app, user, a.tokenTTL)
+	authz.SessionID = session_id
+	accessToken, newRefreshToken, err := providerjwt.GenerateToken(app, user, authz, a.tokenTTL)
 	if err != nil {
 		a.log.WithFields(logrus.Fields{
 			"user_id": userID,
@@ -332,8 +586,17 @@ func (a *Auth) RefreshToken(ctx context.Context, providedToken string, app_id in
 		return "", "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	// Update token in DB (Rotation)
-	if err := a.tokenSaver.SaveToken(ctx, user.Id, newRefreshToken); err != nil {
+	// Rotation only succeeds while the presented token is still the session's current one
+	if err := a.tokenProvider.RotateSessionToken(ctx, session_id, providedToken, newRefreshToken); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			a.log.WithFields(logrus.Fields{
+				"user_id":    userID,
+				"app_id":     app_id,
+				"session_id": session_id,
+				"op":         op,
+			}).Error("token is revoked or invalid")
+			return "", "", fmt.Errorf("%s: token is revoked or invalid", op)
+		}
 		a.log.WithFields(logrus.Fields{
 			"user_id": user.Id,
 			"app_id":  app_id,
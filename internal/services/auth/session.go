@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// ClientInfo describes the client a session is opened from, it is shown to the user in the session list
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+type clientInfoContextKey struct{}
+
+// WithClientInfo returns a context carrying the client of the request, set by the transport
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoContextKey{}, info)
+}
+
+// ClientInfoFromContext returns the client stored by WithClientInfo, empty when the transport set none
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoContextKey{}).(ClientInfo)
+	return info
+}
+
+// RevokeSession ends a single session of the user, e.g. a lost device
+// Its refresh token stops working and the app of the session is notified through its back-channel logout URI
+func (a *Auth) RevokeSession(ctx context.Context, user_id int64, session_id int64) error {
+	const op = "auth.RevokeSession"
+
+	app_id, err := a.tokenProvider.DeleteSession(ctx, user_id, session_id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// Sessions opened before they recorded their app cannot be attributed to one
+	if app_id != 0 {
+		app, err := a.appProvider.App(ctx, app_id)
+		if err != nil {
+			a.log.WithFields(logrus.Fields{
+				"app_id": app_id,
+				"op":     op,
+				"error":  err,
+			}).Error("failed to get app from provider")
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		a.logoutNotifier.Notify(user_id, session_id, []model.App{*app})
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"user_id":    user_id,
+		"session_id": session_id,
+		"app_id":     app_id,
+	}).Info("session revoked")
+	return nil
+}
+
+// claimInt64 returns a numeric claim, 0 when it is missing
+func claimInt64(claims jwt.MapClaims, key string) int64 {
+	v, _ := claims[key].(float64)
+	return int64(v)
+}
@@ -0,0 +1,18 @@
+package auth
+
+import "ssoq/internal/model"
+
+// checkAccount returns the error of a user whose status does not allow logging in or using tokens
+// Users created before statuses were introduced have an empty status and are active
+func checkAccount(user *model.User) error {
+	switch user.Status {
+	case model.UserStatusActive, "":
+		return nil
+	case model.UserStatusLocked:
+		return ErrAccountLocked
+	case model.UserStatusPendingVerification:
+		return ErrAccountNotVerified
+	default:
+		return ErrAccountDisabled
+	}
+}
@@ -0,0 +1,32 @@
+package auth
+
+import "fmt"
+
+// TenancyMode defines how user identities are scoped between apps
+type TenancyMode string
+
+const (
+	// TenancyGlobal shares one identity per email across all apps
+	TenancyGlobal TenancyMode = "global"
+	// TenancyPerApp isolates identities per app, the same email may exist in several apps
+	TenancyPerApp TenancyMode = "per_app"
+)
+
+// ParseTenancyMode validates a tenancy mode read from the config, an empty value means global
+func ParseTenancyMode(mode string) (TenancyMode, error) {
+	switch TenancyMode(mode) {
+	case "", TenancyGlobal:
+		return TenancyGlobal, nil
+	case TenancyPerApp:
+		return TenancyPerApp, nil
+	}
+	return "", fmt.Errorf("unknown tenancy mode %q", mode)
+}
+
+// namespace returns the tenant_app_id users of app_id are looked up in
+func (a *Auth) namespace(app_id int64) int64 {
+	if a.tenancy == TenancyPerApp {
+		return app_id
+	}
+	return 0
+}
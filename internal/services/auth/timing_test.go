@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"ssoq/internal/model"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const knownEmail = "known@example.com"
+
+// timingUsers returns a single user with a real bcrypt hash, like the database would
+type timingUsers struct {
+	user *model.User
+}
+
+func (u *timingUsers) GetUser(ctx context.Context, tenant_app_id int64, email string) (*model.User, error) {
+	if email != knownEmail {
+		return nil, nil
+	}
+	user := *u.user
+	return &user, nil
+}
+
+func (u *timingUsers) GetUserByID(ctx context.Context, id int64) (*model.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+// slowTracker simulates the database write of a failed login, the only extra work of a known email
+type slowTracker struct {
+	latency time.Duration
+	failed  int
+}
+
+func (t *slowTracker) RegisterFailedLogin(ctx context.Context, user_id int64, threshold int, lockFor time.Duration) (int, time.Time, error) {
+	time.Sleep(t.latency)
+	t.failed++
+	return t.failed, time.Time{}, nil
+}
+
+func (t *slowTracker) ResetFailedLogins(ctx context.Context, user_id int64) error {
+	return nil
+}
+
+func newTimingAuth(t *testing.T, tracker *slowTracker, failureTime time.Duration) *Auth {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	users := &timingUsers{user: &model.User{Id: 1, Email: knownEmail, Password: hash, Status: model.UserStatusActive}}
+	// No progressive delay, so every attempt of the known email reaches the password check
+	lockout := LockoutPolicy{FailureTime: failureTime}
+	return NewAuth(log, nil, users, nil, nil, nil, nil, tracker, nil, nil, nil, nil, nil, lockout, TenancyGlobal, AdminPolicy{}, time.Hour)
+}
+
+// measureLogins alternates failed logins of the known and of unknown emails and returns their durations in seconds
+func measureLogins(t *testing.T, a *Auth, samples int) ([]float64, []float64) {
+	t.Helper()
+	measure := func(email string) float64 {
+		start := time.Now()
+		_, err := a.Authenticate(context.Background(), email, "wrong password", 1)
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Authenticate(%s) error = %v, want ErrInvalidCredentials", email, err)
+		}
+		return time.Since(start).Seconds()
+	}
+	// Warm up so the first samples do not pay for lazy initialization
+	measure(knownEmail)
+	measure("warmup@example.com")
+
+	known := make([]float64, 0, samples)
+	unknown := make([]float64, 0, samples)
+	for i := 0; i < samples; i++ {
+		// Alternate the order so drift affects both series equally
+		if i%2 == 0 {
+			known = append(known, measure(knownEmail))
+			unknown = append(unknown, measure("unknown@example.com"))
+		} else {
+			unknown = append(unknown, measure("unknown@example.com"))
+			known = append(known, measure(knownEmail))
+		}
+	}
+	return known, unknown
+}
+
+func TestAuthenticateTimingParity(t *testing.T) {
+	if os.Getenv("SSO_TIMING_TEST") != "1" {
+		t.Skip("wall-clock timing test, set SSO_TIMING_TEST=1 to run it")
+	}
+	const (
+		samples = 20
+		// maxT is the largest Welch t statistic accepted as no difference
+		maxT = 4.0
+		// minTrackerLatency is the least simulated write time, the write also takes at least one bcrypt comparison
+		// so it stays far above the jitter of bcrypt and an unpadded failure is always detected
+		minTrackerLatency = 50 * time.Millisecond
+		// calibrationRuns bcrypt comparisons are timed, their median sizes the pad
+		calibrationRuns = 5
+	)
+
+	// Calibrate the pad on this machine: it must cover bcrypt plus the simulated database write
+	calibration := newTimingAuth(t, &slowTracker{}, 0)
+	bcryptTimes := make([]time.Duration, calibrationRuns)
+	for i := range bcryptTimes {
+		start := time.Now()
+		calibration.compareDummyPassword("calibration")
+		bcryptTimes[i] = time.Since(start)
+	}
+	slices.Sort(bcryptTimes)
+	bcryptTime := bcryptTimes[len(bcryptTimes)/2]
+	trackerLatency := max(minTrackerLatency, bcryptTime)
+	failureTime := 2*bcryptTime + 2*trackerLatency
+
+	tests := []struct {
+		name        string
+		failureTime time.Duration
+		// distinguishable is whether the known email is expected to be measurably slower
+		distinguishable bool
+	}{
+		{name: "padded", failureTime: failureTime, distinguishable: false},
+		// The control proves the test can see the recording of the failure when nothing hides it
+		{name: "unpadded", failureTime: 0, distinguishable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &slowTracker{latency: trackerLatency}
+			known, unknown := measureLogins(t, newTimingAuth(t, tracker, tt.failureTime), samples)
+			if tracker.failed == 0 {
+				t.Fatal("failed logins of the known email were not recorded")
+			}
+
+			stat := welch(known, unknown)
+			t.Logf("known mean %.2fms, unknown mean %.2fms, welch t = %.2f",
+				mean(known)*1000, mean(unknown)*1000, stat)
+			if got := math.Abs(stat) > maxT; got != tt.distinguishable {
+				t.Errorf("distinguishable = %v (t = %.2f), want %v", got, stat, tt.distinguishable)
+			}
+		})
+	}
+}
+
+func mean(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func variance(xs []float64) float64 {
+	m := mean(xs)
+	var sum float64
+	for _, x := range xs {
+		sum += (x - m) * (x - m)
+	}
+	return sum / float64(len(xs)-1)
+}
+
+// welch returns Welch's t statistic for the difference of means of a and b
+func welch(a, b []float64) float64 {
+	se := math.Sqrt(variance(a)/float64(len(a)) + variance(b)/float64(len(b)))
+	if se == 0 {
+		return 0
+	}
+	return (mean(a) - mean(b)) / se
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	providerjwt "ssoq/internal/jwt"
+	"ssoq/internal/model"
+	"ssoq/internal/services/attributes"
+	"ssoq/internal/storage"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminPolicy describes which access tokens are accepted by the admin API
+// An admin is a user of AppID whose token carries Permission
+type AdminPolicy struct {
+	AppID      int64
+	Permission string
+}
+
+// Principal is the subject of a verified access token
+type Principal struct {
+	UserID      int64
+	AppID       int64
+	Roles       []string
+	Permissions []string
+	Scopes      []string
+	// Actor is the act claim of a token obtained by token exchange, nil for tokens issued to the user
+	Actor map[string]interface{}
+	// ImpersonatorID is the administrator acting as the user, 0 when the token is not an impersonation
+	ImpersonatorID int64
+	// SessionID is the session the token was issued for, 0 for delegated tokens
+	SessionID int64
+	ExpiresAt time.Time
+}
+
+// HasScope reports whether the token was granted the named scope
+func (p *Principal) HasScope(name string) bool {
+	return slices.Contains(p.Scopes, name)
+}
+
+// HasPermission reports whether the token grants the named permission
+func (p *Principal) HasPermission(name string) bool {
+	return slices.Contains(p.Permissions, name)
+}
+
+// authorization loads the roles, permissions and attribute claims embedded in access tokens of a user for an app
+func (a *Auth) authorization(ctx context.Context, user_id int64, app *model.App) (providerjwt.Authorization, error) {
+	roles, permissions, err := a.roleProvider.UserAuthorization(ctx, user_id, app.Id)
+	if err != nil {
+		return providerjwt.Authorization{}, err
+	}
+	authz := providerjwt.Authorization{Roles: roles, Permissions: permissions}
+	if len(app.AttributeSchema) == 0 {
+		return authz, nil
+	}
+	// Schemas are validated when they are set, so a parse error means the stored schema is corrupt
+	schema, err := attributes.Parse(app.AttributeSchema)
+	if err != nil {
+		return providerjwt.Authorization{}, err
+	}
+	attrs, err := a.attributeProvider.UserAttributes(ctx, user_id, app.Id)
+	if err != nil && !errors.Is(err, storage.ErrNotMember) {
+		return providerjwt.Authorization{}, err
+	}
+	authz.Claims = schema.Claims(attrs)
+	return authz, nil
+}
+
+// VerifyAccessToken checks the signature, expiry and purpose of an access token issued for app_id
+// Tokens issued before the user's last LogoutAll are rejected by their token version,
+// tokens of a session that was revoked or has expired are rejected by their sid
+func (a *Auth) VerifyAccessToken(ctx context.Context, providedToken string, app_id int64) (*Principal, error) {
+	const op = "auth.VerifyAccessToken"
+
+	app, err := a.appProvider.App(ctx, app_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"error":  err,
+			"op":     op,
+		}).Error("failed to get app from provider")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := checkApp(app, ""); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	token, err := providerjwt.ParseToken(providedToken, app)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"app_id": app_id,
+			"error":  err,
+			"op":     op,
+		}).Warn("invalid access token")
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != "access" {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	userIDFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	if appIDFloat, ok := claims["app_id"].(float64); !ok || int64(appIDFloat) != app_id {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	scope, _ := claims["scope"].(string)
+	actor, _ := claims["act"].(map[string]interface{})
+	principal := &Principal{
+		UserID:      int64(userIDFloat),
+		AppID:       app_id,
+		Roles:       claimStrings(claims, "roles"),
+		Permissions: claimStrings(claims, "permissions"),
+		Scopes:      ParseScope(scope),
+		Actor:       actor,
+		SessionID:   claimInt64(claims, "sid"),
+	}
+	if impersonator, ok := claims["impersonator_id"].(float64); ok {
+		principal.ImpersonatorID = int64(impersonator)
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		principal.ExpiresAt = exp.Time
+	}
+
+	user, err := a.userProvider.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": principal.UserID,
+			"error":   err,
+			"op":      op,
+		}).Error("failed to get user by ID")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if user == nil || tokenVersion(claims) != user.TokenVersion || checkAccount(user) != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": principal.UserID,
+			"app_id":  app_id,
+			"op":      op,
+		}).Warn("revoked access token")
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	// Delegated tokens carry no session, they end with their own short lifetime
+	if principal.SessionID != 0 {
+		live, err := a.tokenProvider.SessionExists(ctx, principal.UserID, principal.SessionID)
+		if err != nil {
+			a.log.WithFields(logrus.Fields{
+				"user_id":    principal.UserID,
+				"session_id": principal.SessionID,
+				"error":      err,
+				"op":         op,
+			}).Error("failed to check session")
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if !live {
+			a.log.WithFields(logrus.Fields{
+				"user_id":    principal.UserID,
+				"session_id": principal.SessionID,
+				"app_id":     app_id,
+				"op":         op,
+			}).Warn("access token of an ended session")
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+	}
+	return principal, nil
+}
+
+// AuthorizeAdmin verifies an access token of the admin app and requires the admin permission
+func (a *Auth) AuthorizeAdmin(ctx context.Context, providedToken string) (*Principal, error) {
+	const op = "auth.AuthorizeAdmin"
+
+	if a.admin.AppID == 0 {
+		return nil, fmt.Errorf("%s: admin API is disabled: %w", op, ErrPermissionDenied)
+	}
+	principal, err := a.VerifyAccessToken(ctx, providedToken, a.admin.AppID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	// Delegated tokens never grant admin access, so impersonating an administrator cannot escalate privileges
+	if principal.Actor != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": principal.UserID,
+			"op":      op,
+		}).Warn("delegated access token used for the admin API")
+		return nil, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+	if !principal.HasPermission(a.admin.Permission) {
+		a.log.WithFields(logrus.Fields{
+			"user_id": principal.UserID,
+			"op":      op,
+		}).Warn("admin permission missing in access token")
+		return nil, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+	return principal, nil
+}
+
+// AuthorizeAdminUser checks that a user authenticated without a token, e.g. by a client certificate mapped to it,
+// is still an active administrator: a user of the admin app holding the admin permission
+func (a *Auth) AuthorizeAdminUser(ctx context.Context, user_id int64) error {
+	const op = "auth.AuthorizeAdminUser"
+
+	if a.admin.AppID == 0 {
+		return fmt.Errorf("%s: admin API is disabled: %w", op, ErrPermissionDenied)
+	}
+	user, err := a.userProvider.GetUserByID(ctx, user_id)
+	if err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"error":   err,
+			"op":      op,
+		}).Error("failed to get user by ID")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if user == nil {
+		return fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+	if err := checkAccount(user); err != nil {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"status":  user.Status,
+			"op":      op,
+		}).Warn("admin account is not active")
+		return fmt.Errorf("%s: %w: %w", op, ErrPermissionDenied, err)
+	}
+	if err := a.checkAppAccess(ctx, user_id, a.admin.AppID); err != nil {
+		if errors.Is(err, ErrAppAccessDenied) {
+			return fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	_, permissions, err := a.roleProvider.UserAuthorization(ctx, user_id, a.admin.AppID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !slices.Contains(permissions, a.admin.Permission) {
+		a.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"op":      op,
+		}).Warn("admin permission missing for user")
+		return fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+	return nil
+}
+
+// tokenVersion returns the token_version claim, tokens issued before versions were introduced have version 0
+func tokenVersion(claims jwt.MapClaims) int {
+	version, _ := claims["token_version"].(float64)
+	return int(version)
+}
+
+// claimStrings returns a string array claim, decoded JSON arrays are []interface{}
+func claimStrings(claims jwt.MapClaims, key string) []string {
+	raw, ok := claims[key].([]interface{})
+	if !ok {
+		return nil
+	}
+	res := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// VerifyBearerToken verifies an access token of any app, the app is taken from the token's app_id claim
+// The claim is only trusted after the signature has been checked with that app's secret
+func (a *Auth) VerifyBearerToken(ctx context.Context, providedToken string) (*Principal, error) {
+	const op = "auth.VerifyBearerToken"
+
+	unverified, _, err := jwt.NewParser().ParseUnverified(providedToken, jwt.MapClaims{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	claims, ok := unverified.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	appIDFloat, ok := claims["app_id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	return a.VerifyAccessToken(ctx, providedToken, int64(appIDFloat))
+}
@@ -0,0 +1,155 @@
+package logout
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	providerjwt "ssoq/internal/jwt"
+	"ssoq/internal/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// retryBaseDelay is the delay before the first retry of a failed delivery, it doubles with every attempt
+const retryBaseDelay = time.Second
+
+// Notifier posts OpenID Connect back-channel logout tokens to the apps of a user that logged out
+// Deliveries run in the background and are retried with exponential backoff
+type Notifier struct {
+	log        *logrus.Logger
+	signingKey *providerjwt.SigningKey
+	issuer     string
+	client     *http.Client
+	attempts   int
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// New creates a new instance of the Notifier signing logout tokens with signingKey for issuer
+// A delivery is attempted at most attempts times, each request is bounded by timeout
+func New(log *logrus.Logger, signingKey *providerjwt.SigningKey, issuer string, attempts int, timeout time.Duration) *Notifier {
+	if attempts < 1 {
+		attempts = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Notifier{
+		log:        log,
+		signingKey: signingKey,
+		issuer:     issuer,
+		client:     &http.Client{Timeout: timeout},
+		attempts:   attempts,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Notify starts the delivery of a logout token of the user to every app with a back-channel logout URI
+// session_id is the single session that ended, 0 when every session of the user ended
+// It does not wait for the deliveries
+func (n *Notifier) Notify(user_id int64, session_id int64, apps []model.App) {
+	for _, app := range apps {
+		if app.BackchannelLogoutURI == "" {
+			continue
+		}
+		n.wg.Add(1)
+		go func(app_id int64, uri string) {
+			defer n.wg.Done()
+			n.deliver(user_id, session_id, app_id, uri)
+		}(app.Id, app.BackchannelLogoutURI)
+	}
+}
+
+// Stop cancels the pending retries and waits for the running deliveries
+func (n *Notifier) Stop() {
+	n.cancel()
+	n.wg.Wait()
+	n.log.Info("logout notifier stopped")
+}
+
+// deliver posts a logout token to uri until the app accepts it, the attempts run out or the notifier stops
+func (n *Notifier) deliver(user_id int64, session_id int64, app_id int64, uri string) {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := n.post(user_id, session_id, app_id, uri)
+		if err == nil {
+			n.log.WithFields(logrus.Fields{
+				"user_id": user_id,
+				"app_id":  app_id,
+				"attempt": attempt,
+			}).Info("back-channel logout delivered")
+			return
+		}
+
+		retry := attempt < n.attempts && !isPermanent(err) && n.ctx.Err() == nil
+		n.log.WithFields(logrus.Fields{
+			"user_id": user_id,
+			"app_id":  app_id,
+			"attempt": attempt,
+			"retry":   retry,
+			"error":   err,
+		}).Warn("back-channel logout failed")
+		if !retry {
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// post signs a fresh logout token, so retries are never rejected as expired or replayed, and posts it to uri
+func (n *Notifier) post(user_id int64, session_id int64, app_id int64, uri string) error {
+	var sid string
+	if session_id != 0 {
+		sid = strconv.FormatInt(session_id, 10)
+	}
+	token, err := n.signingKey.GenerateLogoutToken(n.issuer, strconv.FormatInt(user_id, 10), sid, strconv.FormatInt(app_id, 10))
+	if err != nil {
+		return err
+	}
+	form := url.Values{"logout_token": {token}}
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("logout endpoint responded with status %d", res.StatusCode)
+	// The app rejected the token itself, sending it again cannot succeed
+	if res.StatusCode == http.StatusBadRequest {
+		return permanentError{err}
+	}
+	return err
+}
+
+// permanentError marks a delivery failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func isPermanent(err error) bool {
+	_, ok := err.(permanentError)
+	return ok
+}
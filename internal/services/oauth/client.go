@@ -0,0 +1,186 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	providerjwt "ssoq/internal/jwt"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// ClientAssertionJWT is the client_assertion_type of private_key_jwt client authentication (RFC 7523)
+const ClientAssertionJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// maxAssertionTTL bounds the lifetime of client assertions, they are not tracked for replay
+const maxAssertionTTL = 5 * time.Minute
+
+// GenerateClientID returns a new random client_id of a machine client
+func GenerateClientID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "svc_" + hex.EncodeToString(b), nil
+}
+
+// GenerateClientSecret returns a new random client secret and the hash to store instead of it
+func GenerateClientSecret() (string, string, error) {
+	secret, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return secret, hashToken(secret), nil
+}
+
+// GenerateAppSecret returns a new random app secret, apps sign their tokens with it so it is stored as is
+func GenerateAppSecret() (string, error) {
+	return randomToken()
+}
+
+// ParseClientPublicKey parses the PEM encoded RSA or ECDSA public key of a private_key_jwt client
+func ParseClientPublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block in public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// clientCredentials handles the client_credentials grant of machine clients
+func (o *OAuth) clientCredentials(ctx context.Context, req *TokenRequest) (*TokenResponse, error) {
+	client, err := o.authenticateMachineClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	app, err := o.appProvider.App(ctx, client.AppId)
+	if err != nil {
+		return nil, err
+	}
+	if !app.DisabledAt.IsZero() {
+		return nil, newError(ErrCodeInvalidClient, "client is disabled")
+	}
+	if err := checkGrant(app, "client_credentials"); err != nil {
+		return nil, err
+	}
+	scopes, err := auth.GrantScopes(auth.ParseScope(req.Scope), client.Scopes)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidScope, "requested scope is not allowed for this client")
+	}
+
+	access_token, err := providerjwt.GenerateClientToken(app, client, scopes, o.tokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"client_id": client.ClientId,
+		"app_id":    app.Id,
+	}).Info("client credentials token issued")
+	return &TokenResponse{
+		AccessToken: access_token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(o.tokenTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// authenticateMachineClient authenticates a machine client by its secret or by a private_key_jwt assertion
+func (o *OAuth) authenticateMachineClient(ctx context.Context, req *TokenRequest) (*model.Client, error) {
+	client_id := req.ClientID
+	if client_id == "" && req.ClientAssertion != "" {
+		// The client_id may be omitted when the assertion identifies the client
+		if unverified, _, err := jwt.NewParser().ParseUnverified(req.ClientAssertion, jwt.MapClaims{}); err == nil {
+			client_id, _ = unverified.Claims.GetIssuer()
+		}
+	}
+	if client_id == "" {
+		return nil, newError(ErrCodeInvalidClient, "client authentication is required")
+	}
+
+	client, err := o.clientProvider.Client(ctx, client_id)
+	if err != nil {
+		o.log.WithFields(logrus.Fields{
+			"client_id": client_id,
+			"error":     err,
+		}).Warn("failed to get machine client")
+		return nil, newError(ErrCodeInvalidClient, "client authentication failed")
+	}
+
+	switch {
+	case req.ClientAssertion != "":
+		if req.ClientAssertionType != ClientAssertionJWT {
+			return nil, newError(ErrCodeInvalidClient, "client_assertion_type is not supported")
+		}
+		if err := o.verifyClientAssertion(client, req.ClientAssertion); err != nil {
+			o.log.WithFields(logrus.Fields{
+				"client_id": client_id,
+				"error":     err,
+			}).Warn("invalid client assertion")
+			return nil, newError(ErrCodeInvalidClient, "client authentication failed")
+		}
+	case req.ClientSecret != "":
+		if client.SecretHash == "" ||
+			subtle.ConstantTimeCompare([]byte(hashToken(req.ClientSecret)), []byte(client.SecretHash)) != 1 {
+			o.log.WithField("client_id", client_id).Warn("invalid machine client secret")
+			return nil, newError(ErrCodeInvalidClient, "client authentication failed")
+		}
+	default:
+		return nil, newError(ErrCodeInvalidClient, "client authentication is required")
+	}
+	return client, nil
+}
+
+// verifyClientAssertion checks a private_key_jwt assertion against the registered public key of the client
+// The assertion must be issued by and about the client, addressed to the token endpoint and short-lived
+func (o *OAuth) verifyClientAssertion(client *model.Client, assertion string) error {
+	if client.PublicKey == "" {
+		return errors.New("client has no registered public key")
+	}
+	key, err := ParseClientPublicKey(client.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	token, err := jwt.Parse(assertion, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(client.ClientId),
+		jwt.WithSubject(client.ClientId),
+		jwt.WithAudience(o.issuer, o.issuer+"/token"),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return err
+	}
+	exp, err := token.Claims.GetExpirationTime()
+	if err != nil {
+		return err
+	}
+	if time.Until(exp.Time) > maxAssertionTTL {
+		return errors.New("client assertion lifetime is too long")
+	}
+	return nil
+}
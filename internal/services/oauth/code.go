@@ -0,0 +1,209 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuthorizeRequest holds the parameters of an authorization endpoint request
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// ValidateClient checks the client and redirect_uri of an authorization request
+// Errors returned here must be shown to the user, never sent to the unverified redirect_uri
+func (o *OAuth) ValidateClient(ctx context.Context, req *AuthorizeRequest) (*model.App, error) {
+	app, err := o.client(ctx, req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGrant(app, "authorization_code"); err != nil {
+		return nil, err
+	}
+	if req.RedirectURI == "" || !slices.Contains(app.RedirectURIs, req.RedirectURI) {
+		o.log.WithFields(logrus.Fields{
+			"app_id":       app.Id,
+			"redirect_uri": req.RedirectURI,
+		}).Warn("redirect_uri is not registered for client")
+		return nil, newError(ErrCodeInvalidRequest, "redirect_uri is not registered for this client")
+	}
+	return app, nil
+}
+
+// ValidateRequest checks the remaining parameters of an authorization request of a validated client
+// Errors returned here are sent back to the client through the redirect_uri
+func (o *OAuth) ValidateRequest(req *AuthorizeRequest, app *model.App) error {
+	if req.ResponseType != "code" {
+		return newError(ErrCodeUnsupportedResponse, "only the code response type is supported")
+	}
+	if req.CodeChallenge == "" {
+		return newError(ErrCodeInvalidRequest, "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return newError(ErrCodeInvalidRequest, "code_challenge_method must be S256")
+	}
+	if _, err := auth.GrantScopes(auth.ParseScope(req.Scope), app.Scopes); err != nil {
+		return newError(ErrCodeInvalidScope, "requested scope is not allowed for this client")
+	}
+	return nil
+}
+
+// Authorize checks the user's credentials and issues an authorization code for a validated request
+// Credential errors of the authentication service are returned unchanged so the login page can show them
+// It also returns the browser session recording the sign in, see EndSession
+func (o *OAuth) Authorize(ctx context.Context, req *AuthorizeRequest, app *model.App, email string, password string) (string, string, error) {
+	user, err := o.authenticator.Authenticate(ctx, email, password, app.Id)
+	if err != nil {
+		return "", "", err
+	}
+	scopes, err := auth.GrantScopes(auth.ParseScope(req.Scope), app.Scopes)
+	if err != nil {
+		return "", "", newError(ErrCodeInvalidScope, "requested scope is not allowed for this client")
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	err = o.codeStore.SaveAuthorizationCode(ctx, &model.AuthorizationCode{
+		CodeHash:      hashToken(code),
+		AppId:         app.Id,
+		UserId:        user.Id,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      time.Now(),
+		ExpiresAt:     time.Now().Add(o.codeTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	session, err := o.browserSession(user.Id)
+	if err != nil {
+		return "", "", err
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"user_id": user.Id,
+		"app_id":  app.Id,
+	}).Info("authorization code issued")
+	return code, session, nil
+}
+
+// exchangeCode handles the authorization_code grant
+func (o *OAuth) exchangeCode(ctx context.Context, req *TokenRequest) (*TokenResponse, error) {
+	if req.Code == "" || req.RedirectURI == "" || req.CodeVerifier == "" {
+		return nil, newError(ErrCodeInvalidRequest, "code, redirect_uri and code_verifier are required")
+	}
+	app, err := o.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGrant(app, "authorization_code"); err != nil {
+		return nil, err
+	}
+
+	code, err := o.codeStore.ConsumeAuthorizationCode(ctx, hashToken(req.Code))
+	if err != nil {
+		return nil, newError(ErrCodeInvalidGrant, "authorization code is invalid or was already used")
+	}
+	if code.AppId != app.Id || code.RedirectURI != req.RedirectURI {
+		o.log.WithFields(logrus.Fields{
+			"app_id":      app.Id,
+			"code_app_id": code.AppId,
+		}).Warn("authorization code presented by another client or redirect_uri")
+		return nil, newError(ErrCodeInvalidGrant, "authorization code was issued to another client")
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return nil, newError(ErrCodeInvalidGrant, "authorization code has expired")
+	}
+	if !verifyPKCE(req.CodeVerifier, code.CodeChallenge) {
+		o.log.WithField("app_id", app.Id).Warn("pkce verification failed")
+		return nil, newError(ErrCodeInvalidGrant, "code_verifier does not match code_challenge")
+	}
+
+	user, err := o.userProvider.GetUserByID(ctx, code.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, newError(ErrCodeInvalidGrant, "user no longer exists")
+	}
+	access_token, refresh_token, session_id, err := o.authenticator.IssueTokens(ctx, user, app, code.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	var id_token string
+	if slices.Contains(code.Scopes, ScopeOpenID) {
+		id_token, err = o.idToken(user, app, code.Scopes, code.Nonce, code.AuthTime, access_token, session_id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"user_id": user.Id,
+		"app_id":  app.Id,
+	}).Info("authorization code exchanged for tokens")
+	return &TokenResponse{
+		AccessToken:  access_token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(o.tokenTTL.Seconds()),
+		RefreshToken: refresh_token,
+		Scope:        strings.Join(code.Scopes, " "),
+		IDToken:      id_token,
+	}, nil
+}
+
+// verifyPKCE checks an RFC 7636 S256 code_verifier against the stored code_challenge
+func verifyPKCE(verifier string, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// randomToken returns 32 random bytes encoded as unpadded base64url
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a token, tokens are only stored hashed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsCredentialError reports whether err is a user facing error of the credential check
+func IsCredentialError(err error) bool {
+	return errors.Is(err, auth.ErrInvalidCredentials) || errors.Is(err, auth.ErrLoginThrottled) || errors.Is(err, auth.ErrAccountLocked) ||
+		errors.Is(err, auth.ErrAppAccessDenied) ||
+		errors.Is(err, auth.ErrAccountDisabled) || errors.Is(err, auth.ErrAccountNotVerified)
+}
@@ -0,0 +1,171 @@
+package oauth
+
+import (
+	"context"
+	providerjwt "ssoq/internal/jwt"
+	"ssoq/internal/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{name: "matching verifier", verifier: testVerifier, challenge: testChallenge, want: true},
+		{name: "longest verifier", verifier: strings.Repeat("a", 128), challenge: "aDbPE7rEAOkQUHHNavRwhN-srU5eMCyUv-0k4BOvtz4", want: true},
+		{name: "other verifier", verifier: strings.Repeat("b", 43), challenge: testChallenge, want: false},
+		{name: "plain method is not accepted", verifier: testVerifier, challenge: testVerifier, want: false},
+		{name: "padded challenge", verifier: testVerifier, challenge: testChallenge + "=", want: false},
+		{name: "empty challenge", verifier: testVerifier, challenge: "", want: false},
+		// Verifiers outside of the RFC 7636 length bounds are refused even when their hash matches
+		{name: "too short", verifier: strings.Repeat("a", 42), challenge: "elOGB_2quSlplZKfRRVlu7gULhhEEXMiqv0rPXawGv8", want: false},
+		{name: "too long", verifier: strings.Repeat("a", 129), challenge: "wSywJKLlVRzKDgj86PHF4xRVXMP-9jKe6ZSj23UhZq4", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyPKCE(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("verifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+const (
+	testVerifier  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQ"
+	testChallenge = "RqIZl4LIgn8KxW9QO-nTnv7pf0CnNrksx9fF-CXP2FE"
+	testCode      = "authorization-code"
+	testRedirect  = "https://app.example.com/callback"
+)
+
+func TestExchangeCode(t *testing.T) {
+	tests := []struct {
+		name string
+		// code is the stored authorization code, nil when none is stored
+		code *model.AuthorizationCode
+		req  TokenRequest
+		want string
+	}{
+		{name: "valid code and verifier", code: &model.AuthorizationCode{AppId: 1, UserId: 7},
+			req: TokenRequest{ClientID: "1", Code: testCode, RedirectURI: testRedirect, CodeVerifier: testVerifier}},
+		{name: "missing verifier", code: &model.AuthorizationCode{AppId: 1, UserId: 7},
+			req: TokenRequest{ClientID: "1", Code: testCode, RedirectURI: testRedirect}, want: ErrCodeInvalidRequest},
+		{name: "wrong verifier", code: &model.AuthorizationCode{AppId: 1, UserId: 7},
+			req:  TokenRequest{ClientID: "1", Code: testCode, RedirectURI: testRedirect, CodeVerifier: strings.Repeat("b", 43)},
+			want: ErrCodeInvalidGrant},
+		{name: "unknown code", code: nil,
+			req: TokenRequest{ClientID: "1", Code: testCode, RedirectURI: testRedirect, CodeVerifier: testVerifier}, want: ErrCodeInvalidGrant},
+		{name: "code of another client", code: &model.AuthorizationCode{AppId: 2, UserId: 7},
+			req: TokenRequest{ClientID: "1", Code: testCode, RedirectURI: testRedirect, CodeVerifier: testVerifier}, want: ErrCodeInvalidGrant},
+		{name: "other redirect_uri", code: &model.AuthorizationCode{AppId: 1, UserId: 7},
+			req:  TokenRequest{ClientID: "1", Code: testCode, RedirectURI: "https://evil.example.com/", CodeVerifier: testVerifier},
+			want: ErrCodeInvalidGrant},
+		{name: "expired code", code: &model.AuthorizationCode{AppId: 1, UserId: 7, ExpiresAt: time.Now().Add(-time.Second)},
+			req: TokenRequest{ClientID: "1", Code: testCode, RedirectURI: testRedirect, CodeVerifier: testVerifier}, want: ErrCodeInvalidGrant},
+		{name: "wrong client secret", code: &model.AuthorizationCode{AppId: 1, UserId: 7},
+			req:  TokenRequest{ClientID: "1", ClientSecret: "wrong", Code: testCode, RedirectURI: testRedirect, CodeVerifier: testVerifier},
+			want: ErrCodeInvalidClient},
+		{name: "deleted user", code: &model.AuthorizationCode{AppId: 1, UserId: 8},
+			req: TokenRequest{ClientID: "1", Code: testCode, RedirectURI: testRedirect, CodeVerifier: testVerifier}, want: ErrCodeInvalidGrant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes := fakeCodes{}
+			if tt.code != nil {
+				code := *tt.code
+				code.CodeHash = hashToken(testCode)
+				code.RedirectURI = testRedirect
+				code.Scopes = []string{"profile"}
+				code.CodeChallenge = testChallenge
+				if code.ExpiresAt.IsZero() {
+					code.ExpiresAt = time.Now().Add(time.Minute)
+				}
+				codes[code.CodeHash] = &code
+			}
+			o := newCodeOAuth(codes, &fakeAuthenticator{})
+
+			req := tt.req
+			req.GrantType = "authorization_code"
+			res, err := o.Token(context.Background(), &req)
+			wantOAuthError(t, err, tt.want)
+			if tt.want == "" && (res.AccessToken != "access-token" || res.Scope != "profile") {
+				t.Errorf("Token() = %+v, want the issued tokens", res)
+			}
+		})
+	}
+}
+
+// TestAuthorizationCodeSingleUse walks the code flow from the login form to the tokens, a code is accepted once
+func TestAuthorizationCodeSingleUse(t *testing.T) {
+	ctx := context.Background()
+	authenticator := &fakeAuthenticator{user: &model.User{Id: 7, Email: "user@example.com"}, password: "password"}
+	o := newCodeOAuth(fakeCodes{}, authenticator)
+	// Signing in also sets the browser session, which is signed with the ID token key
+	providerjwt.SetLogger(testLogger())
+	key, err := providerjwt.LoadSigningKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.signingKey = key
+	app, err := o.ValidateClient(ctx, &AuthorizeRequest{ClientID: "1", RedirectURI: testRedirect})
+	if err != nil {
+		t.Fatalf("ValidateClient() error = %v", err)
+	}
+	req := &AuthorizeRequest{ResponseType: "code", ClientID: "1", RedirectURI: testRedirect, Scope: "profile",
+		CodeChallenge: testChallenge, CodeChallengeMethod: "S256"}
+	if err := o.ValidateRequest(req, app); err != nil {
+		t.Fatalf("ValidateRequest() error = %v", err)
+	}
+	if _, _, err := o.Authorize(ctx, req, app, "user@example.com", "wrong"); err == nil {
+		t.Fatal("Authorize() with a wrong password succeeded")
+	}
+	code, _, err := o.Authorize(ctx, req, app, "user@example.com", "password")
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	exchange := &TokenRequest{GrantType: "authorization_code", ClientID: "1", Code: code, RedirectURI: testRedirect, CodeVerifier: testVerifier}
+	_, err = o.Token(ctx, exchange)
+	wantOAuthError(t, err, "")
+	_, err = o.Token(ctx, exchange)
+	wantOAuthError(t, err, ErrCodeInvalidGrant)
+}
+
+func TestValidateRequest(t *testing.T) {
+	app := &model.App{Id: 1, Scopes: []string{"profile"}}
+	valid := AuthorizeRequest{ResponseType: "code", Scope: "profile", CodeChallenge: testChallenge, CodeChallengeMethod: "S256"}
+	tests := []struct {
+		name   string
+		modify func(req *AuthorizeRequest)
+		want   string
+	}{
+		{name: "valid", modify: func(req *AuthorizeRequest) {}},
+		{name: "implicit flow", modify: func(req *AuthorizeRequest) { req.ResponseType = "token" }, want: ErrCodeUnsupportedResponse},
+		{name: "missing challenge", modify: func(req *AuthorizeRequest) { req.CodeChallenge = "" }, want: ErrCodeInvalidRequest},
+		{name: "plain method", modify: func(req *AuthorizeRequest) { req.CodeChallengeMethod = "plain" }, want: ErrCodeInvalidRequest},
+		{name: "scope not allowed", modify: func(req *AuthorizeRequest) { req.Scope = "admin" }, want: ErrCodeInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+			wantOAuthError(t, newCodeOAuth(fakeCodes{}, &fakeAuthenticator{}).ValidateRequest(&req, app), tt.want)
+		})
+	}
+}
+
+// newCodeOAuth creates an OAuth service for the authorization code flow of app 1 and its user 7
+// It has no signing key, so it cannot issue ID tokens or browser sessions
+func newCodeOAuth(codes fakeCodes, authenticator *fakeAuthenticator) *OAuth {
+	apps := fakeApps{
+		1: {Id: 1, Secret: "app-secret", Scopes: []string{"profile"}, RedirectURIs: []string{testRedirect}},
+		2: {Id: 2},
+	}
+	users := fakeUsers{7: {Id: 7, Email: "user@example.com"}}
+	return New(testLogger(), authenticator, users, apps, codes, nil, nil, nil, nil, nil, testIssuer,
+		time.Minute, 0, 0, time.Hour)
+}
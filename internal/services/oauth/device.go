@@ -0,0 +1,291 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/url"
+	"slices"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"ssoq/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GrantTypeDeviceCode is the grant_type of token requests polling a device code (RFC 8628)
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Device flow error codes defined by RFC 8628
+const (
+	ErrCodeAuthorizationPending = "authorization_pending"
+	ErrCodeSlowDown             = "slow_down"
+	ErrCodeExpiredToken         = "expired_token"
+)
+
+// slowDownStep is added to the polling interval of a device each time it polls too often
+const slowDownStep = 5
+
+// userCodeAlphabet has no vowels and no look-alike characters so user codes are easy to type and spell no words
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength is the number of characters of a user code, shown as two dash separated halves
+const userCodeLength = 8
+
+// ErrUnknownUserCode is returned by the verification page methods for a user code that is unknown,
+// expired or already decided
+var ErrUnknownUserCode = errors.New("unknown or expired user code")
+
+// DeviceStore interface defines methods for storing device authorizations
+type DeviceStore interface {
+	SaveDeviceCode(ctx context.Context, code *model.DeviceCode) error
+	DeviceCodeByUserCode(ctx context.Context, user_code string) (*model.DeviceCode, error)
+	DecideDeviceCode(ctx context.Context, user_code string, status string, user_id int64) error
+	PollDeviceCode(ctx context.Context, deviceCodeHash string) (*model.DeviceCode, error)
+	SlowDownDeviceCode(ctx context.Context, deviceCodeHash string, seconds int) error
+	DeleteDeviceCode(ctx context.Context, deviceCodeHash string) error
+}
+
+// DeviceAuthorizationResponse is a successful device authorization endpoint response
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               int64
+	Interval                int64
+}
+
+// DeviceAuthorization starts the device flow of a client, the device then polls the token endpoint
+// while the user approves the request on the verification page
+func (o *OAuth) DeviceAuthorization(ctx context.Context, client_id string, secret string, scope string) (*DeviceAuthorizationResponse, error) {
+	app, err := o.authenticateClient(ctx, client_id, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGrant(app, GrantTypeDeviceCode); err != nil {
+		return nil, err
+	}
+	scopes, err := auth.GrantScopes(auth.ParseScope(scope), app.Scopes)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidScope, "requested scope is not allowed for this client")
+	}
+
+	device_code, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	code := &model.DeviceCode{
+		DeviceCodeHash: hashToken(device_code),
+		AppId:          app.Id,
+		Scopes:         scopes,
+		PollInterval:   int(o.devicePollInterval.Seconds()),
+		ExpiresAt:      time.Now().Add(o.deviceCodeTTL),
+	}
+	// A user code collision only means another pending request drew the same code, draw again
+	for attempt := 0; ; attempt++ {
+		code.UserCode, err = randomUserCode()
+		if err != nil {
+			return nil, err
+		}
+		err = o.deviceStore.SaveDeviceCode(ctx, code)
+		if err == nil || !errors.Is(err, storage.ErrUserCodeExists) || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	o.log.WithField("app_id", app.Id).Info("device authorization started")
+	verification_uri := o.issuer + "/device"
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              device_code,
+		UserCode:                formatUserCode(code.UserCode),
+		VerificationURI:         verification_uri,
+		VerificationURIComplete: verification_uri + "?user_code=" + url.QueryEscape(formatUserCode(code.UserCode)),
+		ExpiresIn:               int64(o.deviceCodeTTL.Seconds()),
+		Interval:                int64(code.PollInterval),
+	}, nil
+}
+
+// PendingDevice returns a device authorization awaiting the user's decision and the app that requested it
+func (o *OAuth) PendingDevice(ctx context.Context, user_code string) (*model.DeviceCode, *model.App, error) {
+	code, err := o.deviceStore.DeviceCodeByUserCode(ctx, normalizeUserCode(user_code))
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return nil, nil, ErrUnknownUserCode
+		}
+		return nil, nil, err
+	}
+	if code.Status != model.DeviceCodePending || time.Now().After(code.ExpiresAt) {
+		return nil, nil, ErrUnknownUserCode
+	}
+	app, err := o.appProvider.App(ctx, code.AppId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return code, app, nil
+}
+
+// ApproveDevice checks the user's credentials and links the device authorization to the user
+// Credential errors of the authentication service are returned unchanged so the verification page can show them
+func (o *OAuth) ApproveDevice(ctx context.Context, user_code string, email string, password string) error {
+	code, _, err := o.PendingDevice(ctx, user_code)
+	if err != nil {
+		return err
+	}
+	user, err := o.authenticator.Authenticate(ctx, email, password, code.AppId)
+	if err != nil {
+		return err
+	}
+	if err := o.deviceStore.DecideDeviceCode(ctx, code.UserCode, model.DeviceCodeApproved, user.Id); err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return ErrUnknownUserCode
+		}
+		return err
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"user_id": user.Id,
+		"app_id":  code.AppId,
+	}).Info("device authorization approved")
+	return nil
+}
+
+// DenyDevice rejects a device authorization, the device receives access_denied on its next poll
+func (o *OAuth) DenyDevice(ctx context.Context, user_code string) error {
+	code, _, err := o.PendingDevice(ctx, user_code)
+	if err != nil {
+		return err
+	}
+	if err := o.deviceStore.DecideDeviceCode(ctx, code.UserCode, model.DeviceCodeDenied, 0); err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return ErrUnknownUserCode
+		}
+		return err
+	}
+
+	o.log.WithField("app_id", code.AppId).Info("device authorization denied")
+	return nil
+}
+
+// exchangeDeviceCode handles the device_code grant polled by the device
+func (o *OAuth) exchangeDeviceCode(ctx context.Context, req *TokenRequest) (*TokenResponse, error) {
+	if req.DeviceCode == "" {
+		return nil, newError(ErrCodeInvalidRequest, "device_code is required")
+	}
+	app, err := o.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGrant(app, GrantTypeDeviceCode); err != nil {
+		return nil, err
+	}
+
+	deviceCodeHash := hashToken(req.DeviceCode)
+	code, err := o.deviceStore.PollDeviceCode(ctx, deviceCodeHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return nil, newError(ErrCodeInvalidGrant, "device code is invalid or was already used")
+		}
+		return nil, err
+	}
+	if code.AppId != app.Id {
+		o.log.WithFields(logrus.Fields{
+			"app_id":      app.Id,
+			"code_app_id": code.AppId,
+		}).Warn("device code presented by another client")
+		return nil, newError(ErrCodeInvalidGrant, "device code was issued to another client")
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return nil, newError(ErrCodeExpiredToken, "device code has expired")
+	}
+
+	switch code.Status {
+	case model.DeviceCodePending:
+		if !code.LastPolledAt.IsZero() && time.Since(code.LastPolledAt) < time.Duration(code.PollInterval)*time.Second {
+			if err := o.deviceStore.SlowDownDeviceCode(ctx, deviceCodeHash, slowDownStep); err != nil {
+				return nil, err
+			}
+			return nil, newError(ErrCodeSlowDown, "polling too frequently, increase the interval")
+		}
+		return nil, newError(ErrCodeAuthorizationPending, "the user has not yet approved the request")
+	case model.DeviceCodeDenied:
+		if err := o.deviceStore.DeleteDeviceCode(ctx, deviceCodeHash); err != nil && !errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return nil, err
+		}
+		return nil, newError(ErrCodeAccessDenied, "the user denied the request")
+	}
+
+	// Deleting first makes the approved code single use even with concurrent polls
+	if err := o.deviceStore.DeleteDeviceCode(ctx, deviceCodeHash); err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return nil, newError(ErrCodeInvalidGrant, "device code was already used")
+		}
+		return nil, err
+	}
+	user, err := o.userProvider.GetUserByID(ctx, code.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, newError(ErrCodeInvalidGrant, "user no longer exists")
+	}
+	access_token, refresh_token, session_id, err := o.authenticator.IssueTokens(ctx, user, app, code.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	var id_token string
+	if slices.Contains(code.Scopes, ScopeOpenID) {
+		id_token, err = o.idToken(user, app, code.Scopes, "", code.ApprovedAt, access_token, session_id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"user_id": user.Id,
+		"app_id":  app.Id,
+	}).Info("device code exchanged for tokens")
+	return &TokenResponse{
+		AccessToken:  access_token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(o.tokenTTL.Seconds()),
+		RefreshToken: refresh_token,
+		Scope:        strings.Join(code.Scopes, " "),
+		IDToken:      id_token,
+	}, nil
+}
+
+// randomUserCode returns a random user code of userCodeLength characters of userCodeAlphabet
+func randomUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	b := make([]byte, userCodeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// formatUserCode splits a user code in two halves for display, e.g. BDFG-HJKL
+func formatUserCode(code string) string {
+	return code[:len(code)/2] + "-" + code[len(code)/2:]
+}
+
+// normalizeUserCode accepts user codes typed in lower case or with separators
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
@@ -0,0 +1,183 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"ssoq/internal/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDeviceCode = "device-code"
+
+func TestExchangeDeviceCode(t *testing.T) {
+	tests := []struct {
+		name string
+		// code is the stored device authorization, nil when none is stored
+		code     *model.DeviceCode
+		clientID string
+		want     string
+		// deleted is whether the poll is expected to consume the device authorization
+		deleted      bool
+		wantInterval int
+	}{
+		{name: "unknown code", code: nil, clientID: "1", want: ErrCodeInvalidGrant},
+		{name: "first poll of a pending code", code: &model.DeviceCode{Status: model.DeviceCodePending},
+			clientID: "1", want: ErrCodeAuthorizationPending, wantInterval: 5},
+		{name: "poll after the interval", code: &model.DeviceCode{Status: model.DeviceCodePending, LastPolledAt: time.Now().Add(-time.Minute)},
+			clientID: "1", want: ErrCodeAuthorizationPending, wantInterval: 5},
+		{name: "poll within the interval", code: &model.DeviceCode{Status: model.DeviceCodePending, LastPolledAt: time.Now()},
+			clientID: "1", want: ErrCodeSlowDown, wantInterval: 5 + slowDownStep},
+		{name: "denied", code: &model.DeviceCode{Status: model.DeviceCodeDenied},
+			clientID: "1", want: ErrCodeAccessDenied, deleted: true},
+		{name: "expired", code: &model.DeviceCode{Status: model.DeviceCodeApproved, UserId: 7, ExpiresAt: time.Now().Add(-time.Second)},
+			clientID: "1", want: ErrCodeExpiredToken, wantInterval: 5},
+		{name: "code of another client", code: &model.DeviceCode{Status: model.DeviceCodeApproved, UserId: 7},
+			clientID: "2", want: ErrCodeInvalidGrant, wantInterval: 5},
+		{name: "approved", code: &model.DeviceCode{Status: model.DeviceCodeApproved, UserId: 7},
+			clientID: "1", want: "", deleted: true},
+		{name: "approved for a deleted user", code: &model.DeviceCode{Status: model.DeviceCodeApproved, UserId: 8},
+			clientID: "1", want: ErrCodeInvalidGrant, deleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices := &fakeDevices{codes: map[string]*model.DeviceCode{}}
+			hash := hashToken(testDeviceCode)
+			if tt.code != nil {
+				code := *tt.code
+				code.DeviceCodeHash = hash
+				code.AppId = 1
+				code.Scopes = []string{"profile"}
+				code.PollInterval = 5
+				if code.ExpiresAt.IsZero() {
+					code.ExpiresAt = time.Now().Add(time.Minute)
+				}
+				devices.codes[hash] = &code
+			}
+
+			res, err := newDeviceOAuth(devices, &fakeAuthenticator{}).Token(context.Background(), &TokenRequest{
+				GrantType:  GrantTypeDeviceCode,
+				ClientID:   tt.clientID,
+				DeviceCode: testDeviceCode,
+			})
+			wantOAuthError(t, err, tt.want)
+			if tt.want == "" && (res.AccessToken != "access-token" || res.RefreshToken != "refresh-token" || res.Scope != "profile") {
+				t.Errorf("Token() = %+v, want the issued tokens", res)
+			}
+			if tt.code == nil {
+				return
+			}
+			stored, ok := devices.codes[hash]
+			if ok == tt.deleted {
+				t.Errorf("device code deleted = %v, want %v", !ok, tt.deleted)
+			}
+			if ok && stored.PollInterval != tt.wantInterval {
+				t.Errorf("poll interval = %d, want %d", stored.PollInterval, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestExchangeDeviceCodeRequiresDeviceGrant(t *testing.T) {
+	devices := &fakeDevices{codes: map[string]*model.DeviceCode{}}
+	_, err := newDeviceOAuth(devices, &fakeAuthenticator{}).Token(context.Background(), &TokenRequest{
+		GrantType:  GrantTypeDeviceCode,
+		ClientID:   "3",
+		DeviceCode: testDeviceCode,
+	})
+	wantOAuthError(t, err, ErrCodeUnauthorizedClient)
+}
+
+// TestDeviceFlow walks a device authorization from its start to the tokens, the approved code is single use
+func TestDeviceFlow(t *testing.T) {
+	ctx := context.Background()
+	authenticator := &fakeAuthenticator{user: &model.User{Id: 7, Email: "user@example.com"}, password: "password"}
+	o := newDeviceOAuth(&fakeDevices{codes: map[string]*model.DeviceCode{}}, authenticator)
+
+	started, err := o.DeviceAuthorization(ctx, "1", "", "profile")
+	if err != nil {
+		t.Fatalf("DeviceAuthorization() error = %v", err)
+	}
+	if started.Interval != 5 || started.VerificationURI != "https://sso.example.com/device" {
+		t.Errorf("DeviceAuthorization() = %+v", started)
+	}
+	poll := &TokenRequest{GrantType: GrantTypeDeviceCode, ClientID: "1", DeviceCode: started.DeviceCode}
+	_, err = o.Token(ctx, poll)
+	wantOAuthError(t, err, ErrCodeAuthorizationPending)
+
+	// Users may type the code in lower case and without the dash
+	typed := normalizeUserCode(started.UserCode)
+	if _, _, err := o.PendingDevice(ctx, typed); err != nil {
+		t.Fatalf("PendingDevice() error = %v", err)
+	}
+	if err := o.ApproveDevice(ctx, typed, "user@example.com", "wrong"); err == nil {
+		t.Fatal("ApproveDevice() with a wrong password succeeded")
+	}
+	if err := o.ApproveDevice(ctx, typed, "user@example.com", "password"); err != nil {
+		t.Fatalf("ApproveDevice() error = %v", err)
+	}
+	// A decided code cannot be decided again
+	if err := o.DenyDevice(ctx, typed); !errors.Is(err, ErrUnknownUserCode) {
+		t.Errorf("DenyDevice() after approval error = %v, want ErrUnknownUserCode", err)
+	}
+
+	res, err := o.Token(ctx, poll)
+	wantOAuthError(t, err, "")
+	if res.AccessToken == "" || res.Scope != "profile" {
+		t.Errorf("Token() = %+v", res)
+	}
+	_, err = o.Token(ctx, poll)
+	wantOAuthError(t, err, ErrCodeInvalidGrant)
+}
+
+func TestDeviceAuthorizationScopes(t *testing.T) {
+	devices := &fakeDevices{codes: map[string]*model.DeviceCode{}}
+	_, err := newDeviceOAuth(devices, &fakeAuthenticator{}).DeviceAuthorization(context.Background(), "1", "", "admin")
+	wantOAuthError(t, err, ErrCodeInvalidScope)
+}
+
+func TestUserCodeFormat(t *testing.T) {
+	code, err := randomUserCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != userCodeLength {
+		t.Fatalf("randomUserCode() = %q, want %d characters", code, userCodeLength)
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(userCodeAlphabet, r) {
+			t.Fatalf("randomUserCode() = %q contains %q", code, r)
+		}
+	}
+
+	tests := []struct {
+		typed string
+		want  string
+	}{
+		{typed: "BCDF-GHJK", want: "BCDFGHJK"},
+		{typed: "bcdf-ghjk", want: "BCDFGHJK"},
+		{typed: " bcdf ghjk ", want: "BCDFGHJK"},
+	}
+	for _, tt := range tests {
+		if got := normalizeUserCode(tt.typed); got != tt.want {
+			t.Errorf("normalizeUserCode(%q) = %q, want %q", tt.typed, got, tt.want)
+		}
+	}
+	if got := formatUserCode("BCDFGHJK"); got != "BCDF-GHJK" {
+		t.Errorf("formatUserCode() = %q, want %q", got, "BCDF-GHJK")
+	}
+}
+
+// newDeviceOAuth creates an OAuth service for the device flow of app 1 and its user 7 polling every 5 seconds
+// App 2 is another client, app 3 may not use the device flow
+func newDeviceOAuth(devices *fakeDevices, authenticator *fakeAuthenticator) *OAuth {
+	apps := fakeApps{
+		1: {Id: 1, Scopes: []string{"profile", "email"}},
+		2: {Id: 2},
+		3: {Id: 3, GrantTypes: []string{"authorization_code"}},
+	}
+	users := fakeUsers{7: {Id: 7, Email: "user@example.com"}}
+	return New(testLogger(), authenticator, users, apps, nil, nil, devices, nil, nil, nil, testIssuer,
+		time.Minute, 10*time.Minute, 5*time.Second, time.Hour)
+}
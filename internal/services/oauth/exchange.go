@@ -0,0 +1,180 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"ssoq/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GrantTypeTokenExchange is the grant_type of token exchange requests (RFC 8693)
+const GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// TokenTypeAccessToken is the only token type accepted and issued by token exchange
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// ErrCodeInvalidTarget is returned when the requested audience is not allowed by any policy (RFC 8693)
+const ErrCodeInvalidTarget = "invalid_target"
+
+// Audit log events of token exchange
+const (
+	AuditTokenExchange       = "token_exchange"
+	AuditTokenExchangeDenied = "token_exchange_denied"
+)
+
+// ExchangePolicyProvider interface defines methods for retrieving token exchange policies
+type ExchangePolicyProvider interface {
+	ExchangePolicy(ctx context.Context, client_id string, subject_app_id int64, audience_app_id int64) (*model.ExchangePolicy, error)
+}
+
+// AuditLogger interface defines methods for writing the audit log
+type AuditLogger interface {
+	SaveAuditEvent(ctx context.Context, event *model.AuditEvent) error
+}
+
+// exchangeToken handles the token exchange grant
+// A machine client exchanges an access token of a user (the subject) for an access token of the audience app
+// that records the client, or the user of the actor_token, as actor in the act claim
+func (o *OAuth) exchangeToken(ctx context.Context, req *TokenRequest) (*TokenResponse, error) {
+	if req.SubjectToken == "" || req.Audience == "" {
+		return nil, newError(ErrCodeInvalidRequest, "subject_token and audience are required")
+	}
+	if req.SubjectTokenType != TokenTypeAccessToken {
+		return nil, newError(ErrCodeInvalidRequest, "subject_token_type must be "+TokenTypeAccessToken)
+	}
+	if req.ActorToken != "" && req.ActorTokenType != TokenTypeAccessToken {
+		return nil, newError(ErrCodeInvalidRequest, "actor_token_type must be "+TokenTypeAccessToken)
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeAccessToken {
+		return nil, newError(ErrCodeInvalidRequest, "only access tokens can be requested")
+	}
+	audience_app_id, err := strconv.ParseInt(req.Audience, 10, 64)
+	if err != nil || audience_app_id <= 0 {
+		return nil, newError(ErrCodeInvalidTarget, "audience must be an app id")
+	}
+
+	client, err := o.authenticateMachineClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	subject, err := o.authenticator.VerifyBearerToken(ctx, req.SubjectToken)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidGrant, "subject_token is invalid or expired")
+	}
+
+	act := map[string]interface{}{"sub": client.ClientId, "client_id": client.ClientId}
+	actor := "client:" + client.ClientId
+	if req.ActorToken != "" {
+		actorPrincipal, err := o.authenticator.VerifyBearerToken(ctx, req.ActorToken)
+		if err != nil {
+			return nil, newError(ErrCodeInvalidGrant, "actor_token is invalid or expired")
+		}
+		act = map[string]interface{}{
+			"sub":       strconv.FormatInt(actorPrincipal.UserID, 10),
+			"app_id":    actorPrincipal.AppID,
+			"client_id": client.ClientId,
+		}
+		actor = "user:" + strconv.FormatInt(actorPrincipal.UserID, 10)
+	}
+	// Earlier actors of a chain of exchanges stay nested in the new act claim
+	if subject.Actor != nil {
+		act["act"] = subject.Actor
+	}
+	event := &model.AuditEvent{
+		Event:  AuditTokenExchange,
+		Actor:  actor,
+		UserId: subject.UserID,
+		AppId:  audience_app_id,
+		Details: map[string]interface{}{
+			"client_id":      client.ClientId,
+			"subject_app_id": subject.AppID,
+			"act":            act,
+		},
+	}
+
+	policy, err := o.exchangePolicies.ExchangePolicy(ctx, client.ClientId, subject.AppID, audience_app_id)
+	if err != nil {
+		if errors.Is(err, storage.ErrExchangePolicyNotFound) {
+			o.auditDenied(ctx, event, "no policy allows this exchange")
+			return nil, newError(ErrCodeInvalidTarget, "client may not exchange tokens for this audience")
+		}
+		return nil, err
+	}
+	scopes, err := auth.GrantScopes(auth.ParseScope(req.Scope), policy.Scopes)
+	if err != nil {
+		o.auditDenied(ctx, event, "requested scope is not allowed by the policy")
+		return nil, newError(ErrCodeInvalidScope, "requested scope is not allowed for this audience")
+	}
+	app, err := o.appProvider.App(ctx, audience_app_id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGrant(app, GrantTypeTokenExchange); err != nil {
+		o.auditDenied(ctx, event, "audience app may not use token exchange")
+		return nil, err
+	}
+
+	access_token, err := o.authenticator.IssueDelegatedToken(ctx, subject.UserID, app, scopes, act, subject.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrAppAccessDenied) {
+			o.auditDenied(ctx, event, "user is not a member of the audience app")
+			return nil, newError(ErrCodeInvalidGrant, "user has no access to the audience")
+		}
+		if errors.Is(err, auth.ErrAppDisabled) {
+			o.auditDenied(ctx, event, "audience app is disabled")
+			return nil, newError(ErrCodeInvalidTarget, "audience is disabled")
+		}
+		if errors.Is(err, auth.ErrAccountDisabled) || errors.Is(err, auth.ErrAccountLocked) || errors.Is(err, auth.ErrAccountNotVerified) {
+			o.auditDenied(ctx, event, "user account is not active")
+			return nil, newError(ErrCodeInvalidGrant, "subject account is not active")
+		}
+		return nil, err
+	}
+
+	// Exchanges are only granted once they are recorded
+	event.Details["scope"] = strings.Join(scopes, " ")
+	if err := o.auditLog.SaveAuditEvent(ctx, event); err != nil {
+		return nil, err
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"client_id": client.ClientId,
+		"user_id":   subject.UserID,
+		"app_id":    app.Id,
+		"actor":     actor,
+	}).Info("token exchanged")
+	expiresIn := o.tokenTTL
+	if !subject.ExpiresAt.IsZero() && time.Until(subject.ExpiresAt) < expiresIn {
+		expiresIn = time.Until(subject.ExpiresAt)
+	}
+	return &TokenResponse{
+		AccessToken:     access_token,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(expiresIn.Seconds()),
+		Scope:           strings.Join(scopes, " "),
+	}, nil
+}
+
+// auditDenied records a refused token exchange, a failure to record it is only logged
+func (o *OAuth) auditDenied(ctx context.Context, event *model.AuditEvent, reason string) {
+	denied := *event
+	denied.Event = AuditTokenExchangeDenied
+	denied.Details = map[string]interface{}{"reason": reason}
+	for k, v := range event.Details {
+		denied.Details[k] = v
+	}
+	if err := o.auditLog.SaveAuditEvent(ctx, &denied); err != nil {
+		o.log.WithFields(logrus.Fields{
+			"event": denied.Event,
+			"actor": denied.Actor,
+			"error": err,
+		}).Error("failed to write audit log")
+	}
+}
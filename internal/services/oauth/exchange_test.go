@@ -0,0 +1,176 @@
+package oauth
+
+import (
+	"context"
+	"reflect"
+	"ssoq/internal/services/auth"
+	"testing"
+	"time"
+)
+
+const testClientSecret = "client-secret"
+
+// exchangeFixture holds the fakes behind an OAuth service created by newExchangeOAuth
+type exchangeFixture struct {
+	authenticator *fakeAuthenticator
+	auditLog      *fakeAuditLog
+}
+
+// newExchangeOAuth creates an OAuth service where client svc of app 1 may exchange tokens of app 1
+// for tokens of app 2 with the read scope, and for tokens of app 3 which may not use token exchange
+// The subject token "subject" is of user 7, the actor token "actor" of user 9
+func newExchangeOAuth() (*OAuth, *exchangeFixture) {
+	apps := fakeApps{
+		1: {Id: 1},
+		2: {Id: 2, Scopes: []string{"read", "write"}},
+		3: {Id: 3, GrantTypes: []string{"authorization_code"}},
+	}
+	clients := fakeClients{"svc": {ClientId: "svc", AppId: 1, SecretHash: hashToken(testClientSecret)}}
+	policies := fakePolicies{
+		{ClientId: "svc", SubjectAppId: 1, AudienceAppId: 2, Scopes: []string{"read"}},
+		{ClientId: "svc", SubjectAppId: 1, AudienceAppId: 3, Scopes: []string{"read"}},
+	}
+	f := &exchangeFixture{
+		authenticator: &fakeAuthenticator{principals: map[string]*auth.Principal{
+			"subject": {UserID: 7, AppID: 1, ExpiresAt: time.Now().Add(time.Hour)},
+			"actor":   {UserID: 9, AppID: 1},
+		}},
+		auditLog: &fakeAuditLog{},
+	}
+	o := New(testLogger(), f.authenticator, fakeUsers{}, apps, nil, clients, nil, policies, f.auditLog, nil, testIssuer,
+		time.Minute, 0, 0, time.Hour)
+	return o, f
+}
+
+func exchangeRequest() *TokenRequest {
+	return &TokenRequest{
+		GrantType:        GrantTypeTokenExchange,
+		ClientID:         "svc",
+		ClientSecret:     testClientSecret,
+		SubjectToken:     "subject",
+		SubjectTokenType: TokenTypeAccessToken,
+		Audience:         "2",
+	}
+}
+
+func TestExchangeTokenPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(req *TokenRequest, f *exchangeFixture)
+		want   string
+		// audited is the audit event expected to be written, empty when none is
+		audited string
+	}{
+		{name: "allowed", modify: func(req *TokenRequest, f *exchangeFixture) {}, audited: AuditTokenExchange},
+		{name: "scope within the policy", modify: func(req *TokenRequest, f *exchangeFixture) { req.Scope = "read" },
+			audited: AuditTokenExchange},
+		{name: "missing subject token", modify: func(req *TokenRequest, f *exchangeFixture) { req.SubjectToken = "" },
+			want: ErrCodeInvalidRequest},
+		{name: "missing audience", modify: func(req *TokenRequest, f *exchangeFixture) { req.Audience = "" },
+			want: ErrCodeInvalidRequest},
+		{name: "unsupported subject token type", modify: func(req *TokenRequest, f *exchangeFixture) {
+			req.SubjectTokenType = "urn:ietf:params:oauth:token-type:id_token"
+		}, want: ErrCodeInvalidRequest},
+		{name: "unsupported actor token type", modify: func(req *TokenRequest, f *exchangeFixture) {
+			req.ActorToken = "actor"
+			req.ActorTokenType = "urn:ietf:params:oauth:token-type:jwt"
+		}, want: ErrCodeInvalidRequest},
+		{name: "unsupported requested token type", modify: func(req *TokenRequest, f *exchangeFixture) {
+			req.RequestedTokenType = "urn:ietf:params:oauth:token-type:refresh_token"
+		}, want: ErrCodeInvalidRequest},
+		{name: "audience is not an app id", modify: func(req *TokenRequest, f *exchangeFixture) { req.Audience = "https://api.example.com" },
+			want: ErrCodeInvalidTarget},
+		{name: "wrong client secret", modify: func(req *TokenRequest, f *exchangeFixture) { req.ClientSecret = "wrong" },
+			want: ErrCodeInvalidClient},
+		{name: "invalid subject token", modify: func(req *TokenRequest, f *exchangeFixture) { req.SubjectToken = "forged" },
+			want: ErrCodeInvalidGrant},
+		{name: "invalid actor token", modify: func(req *TokenRequest, f *exchangeFixture) {
+			req.ActorToken = "forged"
+			req.ActorTokenType = TokenTypeAccessToken
+		}, want: ErrCodeInvalidGrant},
+		{name: "no policy for the audience", modify: func(req *TokenRequest, f *exchangeFixture) { req.Audience = "4" },
+			want: ErrCodeInvalidTarget, audited: AuditTokenExchangeDenied},
+		{name: "no policy for the subject app", modify: func(req *TokenRequest, f *exchangeFixture) {
+			f.authenticator.principals["subject"].AppID = 2
+		}, want: ErrCodeInvalidTarget, audited: AuditTokenExchangeDenied},
+		{name: "scope outside the policy", modify: func(req *TokenRequest, f *exchangeFixture) { req.Scope = "write" },
+			want: ErrCodeInvalidScope, audited: AuditTokenExchangeDenied},
+		{name: "audience may not use token exchange", modify: func(req *TokenRequest, f *exchangeFixture) { req.Audience = "3" },
+			want: ErrCodeUnauthorizedClient, audited: AuditTokenExchangeDenied},
+		{name: "user is not a member of the audience", modify: func(req *TokenRequest, f *exchangeFixture) {
+			f.authenticator.delegateErr = auth.ErrAppAccessDenied
+		}, want: ErrCodeInvalidGrant, audited: AuditTokenExchangeDenied},
+		{name: "audience is disabled", modify: func(req *TokenRequest, f *exchangeFixture) {
+			f.authenticator.delegateErr = auth.ErrAppDisabled
+		}, want: ErrCodeInvalidTarget, audited: AuditTokenExchangeDenied},
+		{name: "subject account is disabled", modify: func(req *TokenRequest, f *exchangeFixture) {
+			f.authenticator.delegateErr = auth.ErrAccountDisabled
+		}, want: ErrCodeInvalidGrant, audited: AuditTokenExchangeDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, f := newExchangeOAuth()
+			req := exchangeRequest()
+			tt.modify(req, f)
+
+			res, err := o.Token(context.Background(), req)
+			wantOAuthError(t, err, tt.want)
+			if tt.want == "" {
+				if res.AccessToken != "delegated-token" || res.IssuedTokenType != TokenTypeAccessToken || res.Scope != "read" {
+					t.Errorf("Token() = %+v", res)
+				}
+			}
+			var audited string
+			if len(f.auditLog.events) > 0 {
+				audited = f.auditLog.events[len(f.auditLog.events)-1].Event
+			}
+			if audited != tt.audited {
+				t.Errorf("audit event = %q, want %q", audited, tt.audited)
+			}
+		})
+	}
+}
+
+func TestExchangeTokenActor(t *testing.T) {
+	previous := map[string]interface{}{"sub": "other"}
+	tests := []struct {
+		name         string
+		actorToken   string
+		subjectActor map[string]interface{}
+		want         map[string]interface{}
+	}{
+		{name: "client acts", want: map[string]interface{}{"sub": "svc", "client_id": "svc"}},
+		{name: "user of the actor token acts", actorToken: "actor",
+			want: map[string]interface{}{"sub": "9", "app_id": int64(1), "client_id": "svc"}},
+		{name: "earlier actors stay nested", subjectActor: previous,
+			want: map[string]interface{}{"sub": "svc", "client_id": "svc", "act": previous}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, f := newExchangeOAuth()
+			f.authenticator.principals["subject"].Actor = tt.subjectActor
+			req := exchangeRequest()
+			if tt.actorToken != "" {
+				req.ActorToken = tt.actorToken
+				req.ActorTokenType = TokenTypeAccessToken
+			}
+
+			_, err := o.Token(context.Background(), req)
+			wantOAuthError(t, err, "")
+			if !reflect.DeepEqual(f.authenticator.delegated, tt.want) {
+				t.Errorf("act = %v, want %v", f.authenticator.delegated, tt.want)
+			}
+		})
+	}
+}
+
+// TestExchangeTokenLifetime checks that an exchanged token never outlives the subject token
+func TestExchangeTokenLifetime(t *testing.T) {
+	o, f := newExchangeOAuth()
+	f.authenticator.principals["subject"].ExpiresAt = time.Now().Add(10 * time.Minute)
+	res, err := o.Token(context.Background(), exchangeRequest())
+	wantOAuthError(t, err, "")
+	if res.ExpiresIn > int64((10 * time.Minute).Seconds()) {
+		t.Errorf("expires_in = %d, want at most the remaining lifetime of the subject token", res.ExpiresIn)
+	}
+}
@@ -0,0 +1,216 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"ssoq/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testIssuer = "https://sso.example.com"
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// fakeApps serves apps from a map
+type fakeApps map[int64]*model.App
+
+func (f fakeApps) App(ctx context.Context, app_id int64) (*model.App, error) {
+	app, ok := f[app_id]
+	if !ok {
+		return nil, errors.New("app not found")
+	}
+	return app, nil
+}
+
+// fakeUsers serves users from a map
+type fakeUsers map[int64]*model.User
+
+func (f fakeUsers) GetUserByID(ctx context.Context, id int64) (*model.User, error) {
+	return f[id], nil
+}
+
+// fakeAuthenticator accepts the password of a single user, issues fixed tokens and verifies bearer tokens from a map
+type fakeAuthenticator struct {
+	user       *model.User
+	password   string
+	principals map[string]*auth.Principal
+	// delegateErr is returned by IssueDelegatedToken when set
+	delegateErr error
+	// delegated is the act claim of the last delegated token
+	delegated map[string]interface{}
+}
+
+func (f *fakeAuthenticator) Authenticate(ctx context.Context, email string, password string, app_id int64) (*model.User, error) {
+	if f.user == nil || email != f.user.Email || password != f.password {
+		return nil, auth.ErrInvalidCredentials
+	}
+	return f.user, nil
+}
+
+func (f *fakeAuthenticator) IssueTokens(ctx context.Context, user *model.User, app *model.App, scopes []string) (string, string, int64, error) {
+	return "access-token", "refresh-token", 1, nil
+}
+
+func (f *fakeAuthenticator) RefreshToken(ctx context.Context, token string, app_id int64, scope string) (string, string, error) {
+	return "", "", errors.New("not implemented")
+}
+
+func (f *fakeAuthenticator) VerifyBearerToken(ctx context.Context, token string) (*auth.Principal, error) {
+	principal, ok := f.principals[token]
+	if !ok {
+		return nil, auth.ErrInvalidToken
+	}
+	return principal, nil
+}
+
+func (f *fakeAuthenticator) IssueDelegatedToken(ctx context.Context, user_id int64, app *model.App, scopes []string, act map[string]interface{}, expiresAt time.Time) (string, error) {
+	if f.delegateErr != nil {
+		return "", f.delegateErr
+	}
+	f.delegated = act
+	return "delegated-token", nil
+}
+
+func (f *fakeAuthenticator) RevokeSession(ctx context.Context, user_id int64, session_id int64) error {
+	return nil
+}
+
+// fakeCodes keeps authorization codes in memory, consuming a code deletes it like the database does
+type fakeCodes map[string]*model.AuthorizationCode
+
+func (f fakeCodes) SaveAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error {
+	stored := *code
+	f[code.CodeHash] = &stored
+	return nil
+}
+
+func (f fakeCodes) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*model.AuthorizationCode, error) {
+	code, ok := f[codeHash]
+	if !ok {
+		return nil, errors.New("authorization code not found")
+	}
+	delete(f, codeHash)
+	return code, nil
+}
+
+// fakeDevices keeps device authorizations in memory and mimics the conditional updates of the database
+type fakeDevices struct {
+	codes map[string]*model.DeviceCode
+}
+
+func (f *fakeDevices) SaveDeviceCode(ctx context.Context, code *model.DeviceCode) error {
+	stored := *code
+	stored.Status = model.DeviceCodePending
+	f.codes[code.DeviceCodeHash] = &stored
+	return nil
+}
+
+func (f *fakeDevices) DeviceCodeByUserCode(ctx context.Context, user_code string) (*model.DeviceCode, error) {
+	for _, code := range f.codes {
+		if code.UserCode == user_code {
+			found := *code
+			return &found, nil
+		}
+	}
+	return nil, storage.ErrDeviceCodeNotFound
+}
+
+func (f *fakeDevices) DecideDeviceCode(ctx context.Context, user_code string, status string, user_id int64) error {
+	for _, code := range f.codes {
+		if code.UserCode == user_code && code.Status == model.DeviceCodePending && time.Now().Before(code.ExpiresAt) {
+			code.Status = status
+			code.UserId = user_id
+			if status == model.DeviceCodeApproved {
+				code.ApprovedAt = time.Now()
+			}
+			return nil
+		}
+	}
+	return storage.ErrDeviceCodeNotFound
+}
+
+func (f *fakeDevices) PollDeviceCode(ctx context.Context, deviceCodeHash string) (*model.DeviceCode, error) {
+	code, ok := f.codes[deviceCodeHash]
+	if !ok {
+		return nil, storage.ErrDeviceCodeNotFound
+	}
+	polled := *code
+	code.LastPolledAt = time.Now()
+	return &polled, nil
+}
+
+func (f *fakeDevices) SlowDownDeviceCode(ctx context.Context, deviceCodeHash string, seconds int) error {
+	if code, ok := f.codes[deviceCodeHash]; ok {
+		code.PollInterval += seconds
+	}
+	return nil
+}
+
+func (f *fakeDevices) DeleteDeviceCode(ctx context.Context, deviceCodeHash string) error {
+	if _, ok := f.codes[deviceCodeHash]; !ok {
+		return storage.ErrDeviceCodeNotFound
+	}
+	delete(f.codes, deviceCodeHash)
+	return nil
+}
+
+// fakeClients serves machine clients from a map
+type fakeClients map[string]*model.Client
+
+func (f fakeClients) Client(ctx context.Context, client_id string) (*model.Client, error) {
+	client, ok := f[client_id]
+	if !ok {
+		return nil, errors.New("client not found")
+	}
+	return client, nil
+}
+
+// fakePolicies serves exchange policies from a slice
+type fakePolicies []model.ExchangePolicy
+
+func (f fakePolicies) ExchangePolicy(ctx context.Context, client_id string, subject_app_id int64, audience_app_id int64) (*model.ExchangePolicy, error) {
+	for _, p := range f {
+		if p.ClientId == client_id && p.SubjectAppId == subject_app_id && p.AudienceAppId == audience_app_id {
+			return &p, nil
+		}
+	}
+	return nil, storage.ErrExchangePolicyNotFound
+}
+
+// fakeAuditLog records the events written to it
+type fakeAuditLog struct {
+	events []model.AuditEvent
+}
+
+func (f *fakeAuditLog) SaveAuditEvent(ctx context.Context, event *model.AuditEvent) error {
+	f.events = append(f.events, *event)
+	return nil
+}
+
+// wantOAuthError fails the test unless err is an OAuth error with the given code, an empty code expects no error
+func wantOAuthError(t *testing.T, err error, code string) {
+	t.Helper()
+	if code == "" {
+		if err != nil {
+			t.Fatalf("error = %v, want none", err)
+		}
+		return
+	}
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) {
+		t.Fatalf("error = %v, want OAuth error %s", err, code)
+	}
+	if oauthErr.Code != code {
+		t.Fatalf("error code = %s (%s), want %s", oauthErr.Code, oauthErr.Description, code)
+	}
+}
@@ -0,0 +1,143 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"ssoq/internal/storage"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrLogoutConfirmationRequired is returned by EndSession when the user must confirm the logout on a form
+// It is the case when the ID token hint has expired or the browser has no session of the hint's user
+var ErrLogoutConfirmationRequired = errors.New("logout must be confirmed by the user")
+
+// idTokenHintMaxAge bounds how long after its issuance an ID token is accepted as id_token_hint
+// Sessions end after a day without use, an older hint cannot name a live session anyway
+const idTokenHintMaxAge = 24 * time.Hour
+
+// browserSessionTTL is the lifetime of the browser session cookie set at sign in
+const browserSessionTTL = 24 * time.Hour
+
+// EndSessionRequest holds the parameters of an RP-initiated logout request
+type EndSessionRequest struct {
+	IDTokenHint           string
+	PostLogoutRedirectURI string
+	State                 string
+	// BrowserSession is the browser session cookie of the user agent, empty when it has none
+	BrowserSession string
+	// Confirmed is set when the user submitted the logout confirmation form
+	Confirmed bool
+}
+
+// EndSessionResponse tells the logout page which apps to log out in the browser and where to go next
+type EndSessionResponse struct {
+	// AppName is the name of the app the session was opened in
+	AppName string
+	// FrontchannelURIs are the front-channel logout URIs of the app of the session, loaded in hidden iframes
+	FrontchannelURIs []string
+	// RedirectURI is the post_logout_redirect_uri with state, empty when the request had none
+	RedirectURI string
+}
+
+// browserSession returns the value of the browser session cookie of a user that signed in
+func (o *OAuth) browserSession(user_id int64) (string, error) {
+	return o.signingKey.GenerateBrowserSession(o.issuer, strconv.FormatInt(user_id, 10), browserSessionTTL)
+}
+
+// EndSession ends the session named by the sid claim of id_token_hint (RP-initiated logout)
+// post_logout_redirect_uri must be a registered redirect URI of the app the ID token was issued to
+// The logout runs without confirmation only for an unexpired hint of the user signed in the browser
+func (o *OAuth) EndSession(ctx context.Context, req *EndSessionRequest) (*EndSessionResponse, error) {
+	if req.IDTokenHint == "" {
+		return nil, newError(ErrCodeInvalidRequest, "id_token_hint is required")
+	}
+	claims, err := o.signingKey.ParseIDToken(req.IDTokenHint)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidRequest, "id_token_hint is invalid")
+	}
+	iss, _ := claims.GetIssuer()
+	sub, _ := claims.GetSubject()
+	aud, _ := claims.GetAudience()
+	user_id, err := strconv.ParseInt(sub, 10, 64)
+	if iss != o.issuer || err != nil || len(aud) != 1 {
+		return nil, newError(ErrCodeInvalidRequest, "id_token_hint was not issued by this provider")
+	}
+	app_id, err := strconv.ParseInt(aud[0], 10, 64)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidRequest, "id_token_hint was not issued by this provider")
+	}
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil || time.Since(iat.Time) > idTokenHintMaxAge {
+		return nil, newError(ErrCodeInvalidRequest, "id_token_hint is too old")
+	}
+	sid, _ := claims["sid"].(string)
+	session_id, err := strconv.ParseInt(sid, 10, 64)
+	if err != nil || session_id <= 0 {
+		return nil, newError(ErrCodeInvalidRequest, "id_token_hint does not name a session")
+	}
+	app, err := o.appProvider.App(ctx, app_id)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidRequest, "id_token_hint was issued to an unknown client")
+	}
+
+	res := &EndSessionResponse{AppName: app.Name}
+	if req.PostLogoutRedirectURI != "" {
+		if !slices.Contains(app.RedirectURIs, req.PostLogoutRedirectURI) {
+			return nil, newError(ErrCodeInvalidRequest, "post_logout_redirect_uri is not registered for this client")
+		}
+		res.RedirectURI = req.PostLogoutRedirectURI
+		if req.State != "" {
+			redirect, err := url.Parse(req.PostLogoutRedirectURI)
+			if err != nil {
+				return nil, newError(ErrCodeInvalidRequest, "post_logout_redirect_uri is malformed")
+			}
+			query := redirect.Query()
+			query.Set("state", req.State)
+			redirect.RawQuery = query.Encode()
+			res.RedirectURI = redirect.String()
+		}
+	}
+
+	// A leaked or replayed hint alone must not sign the user out, the browser has to prove it is the user's
+	if !req.Confirmed {
+		exp, err := claims.GetExpirationTime()
+		expired := err != nil || exp == nil || time.Now().After(exp.Time)
+		signedIn, err := o.signingKey.ParseBrowserSession(req.BrowserSession, o.issuer)
+		if expired || err != nil || signedIn != sub {
+			return nil, ErrLogoutConfirmationRequired
+		}
+	}
+
+	// The session may already have ended, the apps are still told so in the browser
+	if err := o.authenticator.RevokeSession(ctx, user_id, session_id); err != nil && !errors.Is(err, storage.ErrSessionNotFound) {
+		return nil, err
+	}
+	if app.FrontchannelLogoutURI != "" {
+		uri, err := url.Parse(app.FrontchannelLogoutURI)
+		if err != nil {
+			o.log.WithFields(logrus.Fields{
+				"app_id": app.Id,
+				"error":  err,
+			}).Warn("malformed front-channel logout URI")
+		} else {
+			query := uri.Query()
+			query.Set("iss", o.issuer)
+			query.Set("sid", sid)
+			uri.RawQuery = query.Encode()
+			res.FrontchannelURIs = append(res.FrontchannelURIs, uri.String())
+		}
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"user_id":    user_id,
+		"app_id":     app.Id,
+		"session_id": session_id,
+		"confirmed":  req.Confirmed,
+	}).Info("session ended")
+	return res, nil
+}
@@ -0,0 +1,124 @@
+package oauth
+
+import (
+	"context"
+	providerjwt "ssoq/internal/jwt"
+	"ssoq/internal/model"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OpenID Connect scopes, apps must register them in apps.scopes to use them
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// Bearer token error codes defined by RFC 6750
+const (
+	ErrCodeInvalidToken      = "invalid_token"
+	ErrCodeInsufficientScope = "insufficient_scope"
+)
+
+// idToken issues the ID token of a grant with the openid scope, nonce is empty when the request had none
+// session_id is the session opened by the grant, it lets the ID token name the session to end at logout
+func (o *OAuth) idToken(user *model.User, app *model.App, scopes []string, nonce string, authTime time.Time, access_token string, session_id int64) (string, error) {
+	return o.signingKey.GenerateIDToken(&providerjwt.IDToken{
+		Issuer:      o.issuer,
+		Subject:     strconv.FormatInt(user.Id, 10),
+		Audience:    strconv.FormatInt(app.Id, 10),
+		Nonce:       nonce,
+		AuthTime:    authTime,
+		AccessToken: access_token,
+		SessionID:   session_id,
+		Extra:       userClaims(user, scopes),
+	}, o.tokenTTL)
+}
+
+// userClaims returns the standard claims about user released for the granted scopes
+func userClaims(user *model.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{}
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeEmail:
+			claims["email"] = user.Email
+		case ScopeProfile:
+			claims["preferred_username"] = user.Username
+			claims["name"] = user.Username
+			if user.DisplayName != "" {
+				claims["name"] = user.DisplayName
+			}
+			if user.Locale != "" {
+				claims["locale"] = user.Locale
+			}
+			if user.Timezone != "" {
+				claims["zoneinfo"] = user.Timezone
+			}
+			if !user.UpdatedAt.IsZero() {
+				claims["updated_at"] = user.UpdatedAt.Unix()
+			}
+		}
+	}
+	return claims
+}
+
+// UserInfo returns the claims about the user of an access token granted the openid scope
+func (o *OAuth) UserInfo(ctx context.Context, token string) (map[string]interface{}, error) {
+	principal, err := o.authenticator.VerifyBearerToken(ctx, token)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidToken, "access token is invalid or expired")
+	}
+	if !principal.HasScope(ScopeOpenID) {
+		return nil, newError(ErrCodeInsufficientScope, "access token was not granted the openid scope")
+	}
+
+	user, err := o.userProvider.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, newError(ErrCodeInvalidToken, "user no longer exists")
+	}
+
+	o.log.WithFields(logrus.Fields{
+		"user_id": user.Id,
+		"app_id":  principal.AppID,
+	}).Debug("userinfo requested")
+	claims := userClaims(user, principal.Scopes)
+	claims["sub"] = strconv.FormatInt(user.Id, 10)
+	return claims, nil
+}
+
+// Discovery returns the OpenID Connect discovery document of the provider
+func (o *OAuth) Discovery() map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":                                o.issuer,
+		"authorization_endpoint":                o.issuer + "/authorize",
+		"token_endpoint":                        o.issuer + "/token",
+		"userinfo_endpoint":                     o.issuer + "/userinfo",
+		"jwks_uri":                              o.issuer + "/.well-known/jwks.json",
+		"device_authorization_endpoint":         o.issuer + "/device_authorization",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 GrantTypes,
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid", "email", "name", "preferred_username", "locale", "zoneinfo", "updated_at"},
+		"token_endpoint_auth_signing_alg_values_supported": []string{"RS256", "PS256", "ES256"},
+		"code_challenge_methods_supported":                 []string{"S256"},
+		"token_endpoint_auth_methods_supported":            []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "none"},
+		"end_session_endpoint":                             o.issuer + "/logout",
+		"backchannel_logout_supported":                     true,
+		"backchannel_logout_session_supported":             true,
+		"frontchannel_logout_supported":                    true,
+		"frontchannel_logout_session_supported":            true,
+	}
+}
+
+// JWKS returns the JSON Web Key Set verifying ID tokens
+func (o *OAuth) JWKS() ([]byte, error) {
+	return o.signingKey.JWKS()
+}
@@ -0,0 +1,253 @@
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	providerjwt "ssoq/internal/jwt"
+	"ssoq/internal/model"
+	"ssoq/internal/services/auth"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OAuth 2.0 error codes defined by RFC 6749
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeInvalidClient        = "invalid_client"
+	ErrCodeInvalidGrant         = "invalid_grant"
+	ErrCodeUnauthorizedClient   = "unauthorized_client"
+	ErrCodeUnsupportedGrantType = "unsupported_grant_type"
+	ErrCodeUnsupportedResponse  = "unsupported_response_type"
+	ErrCodeInvalidScope         = "invalid_scope"
+	ErrCodeAccessDenied         = "access_denied"
+	ErrCodeServerError          = "server_error"
+)
+
+// Error is an OAuth 2.0 error, Code is sent to the client as the "error" parameter
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func newError(code string, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+// OAuth represents the OAuth 2.0 authorization server built on top of the authentication service
+type OAuth struct {
+	log                *logrus.Logger
+	authenticator      Authenticator
+	userProvider       UserProvider
+	appProvider        AppProvider
+	codeStore          CodeStore
+	clientProvider     ClientProvider
+	deviceStore        DeviceStore
+	exchangePolicies   ExchangePolicyProvider
+	auditLog           AuditLogger
+	signingKey         *providerjwt.SigningKey
+	issuer             string
+	codeTTL            time.Duration
+	deviceCodeTTL      time.Duration
+	devicePollInterval time.Duration
+	tokenTTL           time.Duration
+}
+
+// Authenticator interface defines the credential checks and token issuance of the authentication service
+type Authenticator interface {
+	Authenticate(ctx context.Context, email string, password string, app_id int64) (*model.User, error)
+	IssueTokens(ctx context.Context, user *model.User, app *model.App, scopes []string) (string, string, int64, error)
+	RefreshToken(ctx context.Context, token string, app_id int64, scope string) (string, string, error)
+	VerifyBearerToken(ctx context.Context, token string) (*auth.Principal, error)
+	IssueDelegatedToken(ctx context.Context, user_id int64, app *model.App, scopes []string, act map[string]interface{}, expiresAt time.Time) (string, error)
+	RevokeSession(ctx context.Context, user_id int64, session_id int64) error
+}
+
+// UserProvider interface defines methods for retrieving user data
+type UserProvider interface {
+	GetUserByID(ctx context.Context, id int64) (*model.User, error)
+}
+
+// AppProvider interface defines methods for retrieving application data
+type AppProvider interface {
+	App(ctx context.Context, app_id int64) (*model.App, error)
+}
+
+// CodeStore interface defines methods for storing single-use authorization codes
+type CodeStore interface {
+	SaveAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*model.AuthorizationCode, error)
+}
+
+// ClientProvider interface defines methods for retrieving machine clients
+type ClientProvider interface {
+	Client(ctx context.Context, client_id string) (*model.Client, error)
+}
+
+// New creates a new instance of the OAuth service with the provided dependencies
+// codeTTL is the lifetime of authorization codes, tokenTTL is reported as expires_in of access tokens
+// deviceCodeTTL and devicePollInterval are the lifetime and the initial polling interval of device codes
+// signingKey signs OpenID Connect ID tokens issued by issuer
+func New(log *logrus.Logger, authenticator Authenticator, userProvider UserProvider, appProvider AppProvider, codeStore CodeStore, clientProvider ClientProvider, deviceStore DeviceStore, exchangePolicies ExchangePolicyProvider, auditLog AuditLogger, signingKey *providerjwt.SigningKey, issuer string, codeTTL time.Duration, deviceCodeTTL time.Duration, devicePollInterval time.Duration, tokenTTL time.Duration) *OAuth {
+	return &OAuth{
+		log:                log,
+		authenticator:      authenticator,
+		userProvider:       userProvider,
+		appProvider:        appProvider,
+		codeStore:          codeStore,
+		clientProvider:     clientProvider,
+		deviceStore:        deviceStore,
+		exchangePolicies:   exchangePolicies,
+		auditLog:           auditLog,
+		signingKey:         signingKey,
+		issuer:             issuer,
+		codeTTL:            codeTTL,
+		deviceCodeTTL:      deviceCodeTTL,
+		devicePollInterval: devicePollInterval,
+		tokenTTL:           tokenTTL,
+	}
+}
+
+// TokenRequest holds the parameters of a token endpoint request
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	// ClientAssertionType and ClientAssertion carry private_key_jwt authentication of machine clients
+	ClientAssertionType string
+	ClientAssertion     string
+	Code                string
+	RedirectURI         string
+	CodeVerifier        string
+	RefreshToken        string
+	DeviceCode          string
+	// Token exchange parameters of RFC 8693
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	Audience           string
+	RequestedTokenType string
+	Scope              string
+}
+
+// TokenResponse is a successful token endpoint response
+type TokenResponse struct {
+	AccessToken  string
+	TokenType    string
+	ExpiresIn    int64
+	RefreshToken string
+	Scope        string
+	IDToken      string
+	// IssuedTokenType is only set for token exchange
+	IssuedTokenType string
+}
+
+// GrantTypes lists the grant types supported by the token endpoint
+var GrantTypes = []string{"authorization_code", "refresh_token", "client_credentials", GrantTypeDeviceCode, GrantTypeTokenExchange}
+
+// Token handles a token endpoint request according to its grant type
+func (o *OAuth) Token(ctx context.Context, req *TokenRequest) (*TokenResponse, error) {
+	switch req.GrantType {
+	case "authorization_code":
+		return o.exchangeCode(ctx, req)
+	case "refresh_token":
+		return o.refresh(ctx, req)
+	case "client_credentials":
+		return o.clientCredentials(ctx, req)
+	case GrantTypeDeviceCode:
+		return o.exchangeDeviceCode(ctx, req)
+	case GrantTypeTokenExchange:
+		return o.exchangeToken(ctx, req)
+	case "":
+		return nil, newError(ErrCodeInvalidRequest, "grant_type is required")
+	}
+	return nil, newError(ErrCodeUnsupportedGrantType, "grant type is not supported")
+}
+
+// client returns the app identified by client_id
+func (o *OAuth) client(ctx context.Context, client_id string) (*model.App, error) {
+	app_id, err := strconv.ParseInt(client_id, 10, 64)
+	if err != nil || app_id <= 0 {
+		return nil, newError(ErrCodeInvalidClient, "unknown client")
+	}
+	app, err := o.appProvider.App(ctx, app_id)
+	if err != nil {
+		o.log.WithFields(logrus.Fields{
+			"client_id": client_id,
+			"error":     err,
+		}).Warn("failed to get oauth client")
+		return nil, newError(ErrCodeInvalidClient, "unknown client")
+	}
+	if !app.DisabledAt.IsZero() {
+		o.log.WithField("client_id", client_id).Warn("oauth client is disabled")
+		return nil, newError(ErrCodeInvalidClient, "client is disabled")
+	}
+	return app, nil
+}
+
+// checkGrant returns an unauthorized_client error when app may not use grant
+func checkGrant(app *model.App, grant string) error {
+	if !auth.GrantAllowed(app, grant) {
+		return newError(ErrCodeUnauthorizedClient, "client is not allowed to use this grant type")
+	}
+	return nil
+}
+
+// authenticateClient identifies the client of a token request
+// Public clients send no secret and rely on PKCE, a secret that is sent must match the app secret
+func (o *OAuth) authenticateClient(ctx context.Context, client_id string, secret string) (*model.App, error) {
+	app, err := o.client(ctx, client_id)
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" && !matchesAppSecret(app, secret) {
+		o.log.WithField("client_id", client_id).Warn("invalid oauth client secret")
+		return nil, newError(ErrCodeInvalidClient, "client authentication failed")
+	}
+	return app, nil
+}
+
+// matchesAppSecret reports whether secret is the current secret of app or its previous secret during the grace period
+func matchesAppSecret(app *model.App, secret string) bool {
+	match := 0
+	for _, s := range providerjwt.AppSecrets(app, time.Now()) {
+		match |= subtle.ConstantTimeCompare([]byte(secret), []byte(s))
+	}
+	return match == 1
+}
+
+// refresh handles the refresh_token grant using the rotation of the authentication service
+func (o *OAuth) refresh(ctx context.Context, req *TokenRequest) (*TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, newError(ErrCodeInvalidRequest, "refresh_token is required")
+	}
+	app, err := o.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkGrant(app, "refresh_token"); err != nil {
+		return nil, err
+	}
+
+	access_token, refresh_token, err := o.authenticator.RefreshToken(ctx, req.RefreshToken, app.Id, req.Scope)
+	if err != nil {
+		o.log.WithFields(logrus.Fields{
+			"app_id": app.Id,
+			"error":  err,
+		}).Warn("oauth refresh rejected")
+		return nil, newError(ErrCodeInvalidGrant, "refresh token is invalid, expired or revoked")
+	}
+	return &TokenResponse{
+		AccessToken:  access_token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(o.tokenTTL.Seconds()),
+		RefreshToken: refresh_token,
+		Scope:        req.Scope,
+	}, nil
+}
@@ -0,0 +1,35 @@
+package oauth
+
+import (
+	"ssoq/internal/model"
+	"testing"
+	"time"
+)
+
+func TestMatchesAppSecret(t *testing.T) {
+	inGrace := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		secret  string
+		expires time.Time
+		want    bool
+	}{
+		{name: "current secret", secret: "current", expires: inGrace, want: true},
+		{name: "previous secret inside grace period", secret: "previous", expires: inGrace, want: true},
+		{name: "previous secret after grace period", secret: "previous", expires: expired, want: false},
+		{name: "previous secret with zero expiry", secret: "previous", want: false},
+		{name: "unknown secret", secret: "other", expires: inGrace, want: false},
+		{name: "prefix of current secret", secret: "curr", expires: inGrace, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &model.App{Id: 1, Secret: "current", PreviousSecret: "previous", PreviousSecretExpiresAt: tt.expires}
+			if got := matchesAppSecret(app, tt.secret); got != tt.want {
+				t.Errorf("matchesAppSecret(%q) = %v, want %v", tt.secret, got, tt.want)
+			}
+		})
+	}
+}
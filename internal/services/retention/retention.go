@@ -0,0 +1,85 @@
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EmailReleaser interface defines the method releasing the emails of users deleted longer than the retention period ago
+type EmailReleaser interface {
+	ReleaseDeletedEmails(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// Worker periodically releases the emails of soft-deleted users once their retention period has passed
+// The user rows themselves are kept for audit
+type Worker struct {
+	log       *logrus.Logger
+	releaser  EmailReleaser
+	retention time.Duration
+	interval  time.Duration
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// New creates a new instance of the Worker releasing emails retention after the deletion, checking every interval
+func New(log *logrus.Logger, releaser EmailReleaser, retention time.Duration, interval time.Duration) *Worker {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Worker{
+		log:       log,
+		releaser:  releaser,
+		retention: retention,
+		interval:  interval,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start runs the first release right away and then every interval in the background
+func (w *Worker) Start() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			w.release()
+			select {
+			case <-ticker.C:
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}()
+	w.log.WithFields(logrus.Fields{
+		"retention": w.retention,
+		"interval":  w.interval,
+	}).Info("retention worker started")
+}
+
+// Stop stops the worker and waits for a running release
+func (w *Worker) Stop() {
+	w.cancel()
+	w.wg.Wait()
+	w.log.Info("retention worker stopped")
+}
+
+// release releases the emails whose retention period has passed, failures are retried on the next run
+func (w *Worker) release() {
+	released, err := w.releaser.ReleaseDeletedEmails(w.ctx, w.retention)
+	if err != nil {
+		if w.ctx.Err() == nil {
+			w.log.WithField("error", err).Error("failed to release emails of deleted users")
+		}
+		return
+	}
+	if released > 0 {
+		w.log.WithField("users", released).Info("emails of deleted users released")
+	}
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrAppNotFound is returned when an app does not exist
+var ErrAppNotFound = errors.New("app not found")
+
+// SaveApp registers a new app with its secret
+func (s *Storage) SaveApp(ctx context.Context, app *model.App) (int64, error) {
+	const op = "storage.pgsql.SaveApp"
+
+	secret, err := s.secrets.Seal(app.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	var id int64
+	query := `INSERT INTO apps (name, secret, scopes, redirect_uris, backchannel_logout_uri, frontchannel_logout_uri, owner, grant_types)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err = s.db.QueryRowContext(ctx, query, app.Name, secret, pq.Array(app.Scopes), pq.Array(app.RedirectURIs),
+		app.BackchannelLogoutURI, app.FrontchannelLogoutURI, app.Owner, pq.Array(app.GrantTypes)).Scan(&id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_name":  app.Name,
+			"error":     err,
+		}).Error("failed to save app to database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    id,
+		"app_name":  app.Name,
+	}).Info("app saved to database")
+	return id, nil
+}
+
+// UpdateApp replaces the settings of an app, its secret and status are changed separately
+func (s *Storage) UpdateApp(ctx context.Context, app *model.App) error {
+	const op = "storage.pgsql.UpdateApp"
+
+	query := `UPDATE apps SET name = $2, scopes = $3, redirect_uris = $4, backchannel_logout_uri = $5,
+                  frontchannel_logout_uri = $6, owner = $7, grant_types = $8, updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1`
+	res, err := s.db.ExecContext(ctx, query, app.Id, app.Name, pq.Array(app.Scopes), pq.Array(app.RedirectURIs),
+		app.BackchannelLogoutURI, app.FrontchannelLogoutURI, app.Owner, pq.Array(app.GrantTypes))
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    app.Id,
+			"error":     err,
+		}).Error("failed to update app in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrAppNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    app.Id,
+	}).Info("app updated in database")
+	return nil
+}
+
+// Apps returns every app ordered by id
+func (s *Storage) Apps(ctx context.Context) ([]model.App, error) {
+	const op = "storage.pgsql.Apps"
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+appColumns+` FROM apps ORDER BY id`)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to list apps from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	apps := []model.App{}
+	for rows.Next() {
+		app, err := s.scanApp(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		apps = append(apps, *app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return apps, nil
+}
+
+// SetAppDisabled disables or enables an app, disabling an already disabled app keeps its original time
+func (s *Storage) SetAppDisabled(ctx context.Context, app_id int64, disabled bool) error {
+	const op = "storage.pgsql.SetAppDisabled"
+
+	query := `UPDATE apps SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, CURRENT_TIMESTAMP) END,
+                  updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1`
+	res, err := s.db.ExecContext(ctx, query, app_id, disabled)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    app_id,
+			"disabled":  disabled,
+			"error":     err,
+		}).Error("failed to update app status in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrAppNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    app_id,
+		"disabled":  disabled,
+	}).Info("app status updated in database")
+	return nil
+}
+
+// SetAppSecret replaces the secret of an app
+// The replaced secret stays valid until previousExpiresAt, a zero time drops it at once
+func (s *Storage) SetAppSecret(ctx context.Context, app_id int64, secret string, previousExpiresAt time.Time) error {
+	const op = "storage.pgsql.SetAppSecret"
+
+	secret, err := s.secrets.Seal(secret)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The right-hand side of SET sees the row before the update, so previous_secret receives the replaced secret
+	// as stored, sealed with whatever master key sealed it
+	query := `UPDATE apps SET secret = $2,
+                  previous_secret = CASE WHEN $3::TIMESTAMPTZ IS NULL THEN '' ELSE secret END,
+                  previous_secret_expires_at = $3, updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1`
+	expires := sql.NullTime{Time: previousExpiresAt, Valid: !previousExpiresAt.IsZero()}
+	res, err := s.db.ExecContext(ctx, query, app_id, secret, expires)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to update app secret in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrAppNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":           op,
+		"app_id":              app_id,
+		"previous_expires_at": previousExpiresAt,
+	}).Warn("app secret rotated in database")
+	return nil
+}
+
+// ReencryptAppSecrets seals again every app secret that is plaintext or sealed with an older master key
+// Rows are updated one at a time and only if their secrets did not change meanwhile, a row rotated
+// concurrently is skipped and is already sealed with the current key. It returns the number of updated apps
+func (s *Storage) ReencryptAppSecrets(ctx context.Context) (int, error) {
+	const op = "storage.pgsql.ReencryptAppSecrets"
+
+	type row struct {
+		id             int64
+		secret         string
+		previousSecret string
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT id, secret, previous_secret FROM apps ORDER BY id`)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to list app secrets from database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.secret, &r.previousSecret); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		if s.secrets.Stale(r.secret) || s.secrets.Stale(r.previousSecret) {
+			stale = append(stale, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	updated := 0
+	for _, r := range stale {
+		secret, err := s.reseal(r.secret)
+		if err != nil {
+			return updated, fmt.Errorf("%s: app %d: %w", op, r.id, err)
+		}
+		previousSecret, err := s.reseal(r.previousSecret)
+		if err != nil {
+			return updated, fmt.Errorf("%s: app %d: %w", op, r.id, err)
+		}
+		query := `UPDATE apps SET secret = $2, previous_secret = $3
+                  WHERE id = $1 AND secret = $4 AND previous_secret = $5`
+		res, err := s.db.ExecContext(ctx, query, r.id, secret, previousSecret, r.secret, r.previousSecret)
+		if err != nil {
+			s.log.WithFields(logrus.Fields{
+				"operation": op,
+				"app_id":    r.id,
+				"error":     err,
+			}).Error("failed to update app secrets in database")
+			return updated, fmt.Errorf("%s: %w", op, err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			updated++
+		}
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":  op,
+		"master_key": s.secrets.Current(),
+		"apps":       updated,
+	}).Info("app secrets re-encrypted in database")
+	return updated, nil
+}
+
+// reseal opens a stored value and seals it with the current master key
+func (s *Storage) reseal(value string) (string, error) {
+	plaintext, err := s.secrets.Open(value)
+	if err != nil {
+		return "", err
+	}
+	return s.secrets.Seal(plaintext)
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotMember is returned when an operation targets the membership of a user in an app it is not a member of
+var ErrNotMember = errors.New("user is not a member of the app")
+
+// SetAppAttributeSchema replaces the attribute schema of an app, nil removes it
+func (s *Storage) SetAppAttributeSchema(ctx context.Context, app_id int64, schema []byte) error {
+	const op = "storage.pgsql.SetAppAttributeSchema"
+
+	query := `UPDATE apps SET attribute_schema = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+	res, err := s.db.ExecContext(ctx, query, app_id, schema)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to update app attribute schema in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrAppNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    app_id,
+	}).Info("app attribute schema updated in database")
+	return nil
+}
+
+// UserAttributes returns the attributes of a user in an app
+func (s *Storage) UserAttributes(ctx context.Context, user_id int64, app_id int64) (map[string]interface{}, error) {
+	const op = "storage.pgsql.UserAttributes"
+
+	var raw []byte
+	query := `SELECT attributes FROM user_apps WHERE user_id = $1 AND app_id = $2`
+	err := s.db.QueryRowContext(ctx, query, user_id, app_id).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, ErrNotMember)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to get user attributes from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	attrs := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return attrs, nil
+}
+
+// SetUserAttributes replaces the attributes of a user in an app if they still equal previous
+// It returns ErrUserChanged when they were changed meanwhile
+func (s *Storage) SetUserAttributes(ctx context.Context, user_id int64, app_id int64, attrs map[string]interface{}, previous map[string]interface{}) error {
+	const op = "storage.pgsql.SetUserAttributes"
+
+	next, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	prev, err := json.Marshal(previous)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// JSONB equality ignores key order and formatting, so the documents compare by content
+	query := `UPDATE user_apps SET attributes = $3 WHERE user_id = $1 AND app_id = $2 AND attributes = $4::JSONB`
+	res, err := s.db.ExecContext(ctx, query, user_id, app_id, next, prev)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to update user attributes in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrUserChanged)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+		"app_id":    app_id,
+	}).Info("user attributes updated in database")
+	return nil
+}
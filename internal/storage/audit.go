@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SaveAuditEvent appends an event to the audit log
+func (s *Storage) SaveAuditEvent(ctx context.Context, event *model.AuditEvent) error {
+	const op = "storage.pgsql.SaveAuditEvent"
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if event.Details == nil {
+		details = []byte("{}")
+	}
+
+	query := `INSERT INTO audit_log (event, actor, user_id, app_id, details) VALUES ($1, $2, $3, $4, $5)`
+	_, err = s.db.ExecContext(ctx, query, event.Event, event.Actor,
+		sql.NullInt64{Int64: event.UserId, Valid: event.UserId != 0},
+		sql.NullInt64{Int64: event.AppId, Valid: event.AppId != 0}, details)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"event":     event.Event,
+			"actor":     event.Actor,
+			"error":     err,
+		}).Error("failed to save audit event to database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
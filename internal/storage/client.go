@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrClientNotFound is returned when an operation targets a machine client that does not exist
+	ErrClientNotFound = errors.New("client not found")
+	// ErrClientExists is returned when a machine client with the same client_id already exists
+	ErrClientExists = errors.New("client already exists")
+)
+
+const clientColumns = `id, client_id, app_id, name, secret_hash, public_key, scopes, created_at`
+
+func scanClient(scan func(dest ...interface{}) error) (*model.Client, error) {
+	var client model.Client
+	err := scan(&client.Id, &client.ClientId, &client.AppId, &client.Name, &client.SecretHash,
+		&client.PublicKey, pq.Array(&client.Scopes), &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// SaveClient registers a machine client of an app
+func (s *Storage) SaveClient(ctx context.Context, client *model.Client) (int64, error) {
+	const op = "storage.pgsql.SaveClient"
+
+	var id int64
+	query := `INSERT INTO clients (client_id, app_id, name, secret_hash, public_key, scopes)
+              VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	err := s.db.QueryRowContext(ctx, query, client.ClientId, client.AppId, client.Name, client.SecretHash,
+		client.PublicKey, pq.Array(client.Scopes)).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s: %w", op, ErrClientExists)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    client.AppId,
+			"client_id": client.ClientId,
+			"error":     err,
+		}).Error("failed to save client to database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    client.AppId,
+		"client_id": client.ClientId,
+	}).Info("client saved to database")
+	return id, nil
+}
+
+// Client returns a machine client by its client_id
+func (s *Storage) Client(ctx context.Context, client_id string) (*model.Client, error) {
+	const op = "storage.pgsql.Client"
+
+	query := `SELECT ` + clientColumns + ` FROM clients WHERE client_id = $1`
+	client, err := scanClient(s.db.QueryRowContext(ctx, query, client_id).Scan)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%s: %w", op, ErrClientNotFound)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"client_id": client_id,
+			"error":     err,
+		}).Error("failed to get client from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return client, nil
+}
+
+// Clients returns the machine clients of an app
+func (s *Storage) Clients(ctx context.Context, app_id int64) ([]model.Client, error) {
+	const op = "storage.pgsql.Clients"
+
+	query := `SELECT ` + clientColumns + ` FROM clients WHERE app_id = $1 ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query, app_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to list clients from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	clients := []model.Client{}
+	for rows.Next() {
+		client, err := scanClient(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		clients = append(clients, *client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return clients, nil
+}
+
+// DeleteClient deletes a machine client, tokens it already obtained stay valid until they expire
+func (s *Storage) DeleteClient(ctx context.Context, client_id string) error {
+	const op = "storage.pgsql.DeleteClient"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM clients WHERE client_id = $1`, client_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"client_id": client_id,
+			"error":     err,
+		}).Error("failed to delete client from database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrClientNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"client_id": client_id,
+	}).Info("client deleted from database")
+	return nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrDeviceCodeNotFound is returned when a device code does not exist, was already used or can no longer be decided
+	ErrDeviceCodeNotFound = errors.New("device code not found")
+	// ErrUserCodeExists is returned when a generated user code collides with a pending one
+	ErrUserCodeExists = errors.New("user code already exists")
+)
+
+const deviceCodeColumns = `device_code_hash, user_code, app_id, scopes, status, user_id, approved_at, poll_interval, last_polled_at, expires_at`
+
+func scanDeviceCode(row *sql.Row) (*model.DeviceCode, error) {
+	var code model.DeviceCode
+	var user_id sql.NullInt64
+	var approvedAt, lastPolledAt sql.NullTime
+	err := row.Scan(&code.DeviceCodeHash, &code.UserCode, &code.AppId, pq.Array(&code.Scopes), &code.Status,
+		&user_id, &approvedAt, &code.PollInterval, &lastPolledAt, &code.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	code.UserId = user_id.Int64
+	code.ApprovedAt = approvedAt.Time
+	code.LastPolledAt = lastPolledAt.Time
+	return &code, nil
+}
+
+// SaveDeviceCode stores a new device authorization and drops ones expired for a day
+// Recently expired codes are kept so polling devices are told expired_token
+func (s *Storage) SaveDeviceCode(ctx context.Context, code *model.DeviceCode) error {
+	const op = "storage.pgsql.SaveDeviceCode"
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM device_codes WHERE expires_at < CURRENT_TIMESTAMP - INTERVAL '1 day'`); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Warn("failed to delete expired device codes")
+	}
+
+	query := `INSERT INTO device_codes (device_code_hash, user_code, app_id, scopes, status, poll_interval, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := s.db.ExecContext(ctx, query, code.DeviceCodeHash, code.UserCode, code.AppId, pq.Array(code.Scopes),
+		model.DeviceCodePending, code.PollInterval, code.ExpiresAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%s: %w", op, ErrUserCodeExists)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    code.AppId,
+			"error":     err,
+		}).Error("failed to save device code to database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    code.AppId,
+	}).Debug("device code saved to database")
+	return nil
+}
+
+// DeviceCodeByUserCode returns the device authorization a user code belongs to
+func (s *Storage) DeviceCodeByUserCode(ctx context.Context, user_code string) (*model.DeviceCode, error) {
+	const op = "storage.pgsql.DeviceCodeByUserCode"
+
+	query := `SELECT ` + deviceCodeColumns + ` FROM device_codes WHERE user_code = $1`
+	code, err := scanDeviceCode(s.db.QueryRowContext(ctx, query, user_code))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%s: %w", op, ErrDeviceCodeNotFound)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to get device code from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, nil
+}
+
+// DecideDeviceCode approves a pending unexpired device authorization for user_id, or denies it when status is denied
+func (s *Storage) DecideDeviceCode(ctx context.Context, user_code string, status string, user_id int64) error {
+	const op = "storage.pgsql.DecideDeviceCode"
+
+	query := `UPDATE device_codes
+              SET status = $2, user_id = NULLIF($3::BIGINT, 0),
+                  approved_at = CASE WHEN $2 = 'approved' THEN CURRENT_TIMESTAMP END
+              WHERE user_code = $1 AND status = 'pending' AND expires_at > CURRENT_TIMESTAMP`
+	res, err := s.db.ExecContext(ctx, query, user_code, status, user_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"status":    status,
+			"error":     err,
+		}).Error("failed to update device code in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrDeviceCodeNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"status":    status,
+		"user_id":   user_id,
+	}).Info("device code decided")
+	return nil
+}
+
+// PollDeviceCode records a token request of the device and returns the device authorization
+// LastPolledAt of the result is the time of the previous poll, zero on the first one
+func (s *Storage) PollDeviceCode(ctx context.Context, deviceCodeHash string) (*model.DeviceCode, error) {
+	const op = "storage.pgsql.PollDeviceCode"
+
+	query := `UPDATE device_codes d SET last_polled_at = CURRENT_TIMESTAMP
+              FROM device_codes prev
+              WHERE d.device_code_hash = $1 AND prev.device_code_hash = d.device_code_hash
+              RETURNING d.device_code_hash, d.user_code, d.app_id, d.scopes, d.status, d.user_id,
+                        d.approved_at, d.poll_interval, prev.last_polled_at, d.expires_at`
+	code, err := scanDeviceCode(s.db.QueryRowContext(ctx, query, deviceCodeHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%s: %w", op, ErrDeviceCodeNotFound)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to poll device code in database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, nil
+}
+
+// SlowDownDeviceCode increases the polling interval of a device that polls too often
+func (s *Storage) SlowDownDeviceCode(ctx context.Context, deviceCodeHash string, seconds int) error {
+	const op = "storage.pgsql.SlowDownDeviceCode"
+
+	query := `UPDATE device_codes SET poll_interval = poll_interval + $2 WHERE device_code_hash = $1`
+	if _, err := s.db.ExecContext(ctx, query, deviceCodeHash, seconds); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to slow down device code in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteDeviceCode deletes a decided device authorization, only one of concurrent callers succeeds
+func (s *Storage) DeleteDeviceCode(ctx context.Context, deviceCodeHash string) error {
+	const op = "storage.pgsql.DeleteDeviceCode"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM device_codes WHERE device_code_hash = $1`, deviceCodeHash)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to delete device code from database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrDeviceCodeNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrEmailChangeNotFound is returned when a user has no pending email change with the given code or it has expired
+var ErrEmailChangeNotFound = errors.New("email change not found or expired")
+
+// SaveEmailChange records a pending change of the email of a user to email, replacing a previous pending change
+func (s *Storage) SaveEmailChange(ctx context.Context, user_id int64, email string, tokenHash string, expiresAt time.Time) error {
+	const op = "storage.pgsql.SaveEmailChange"
+
+	query := `INSERT INTO email_changes (user_id, email, token_hash, expires_at) VALUES ($1, $2, $3, $4)
+              ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, token_hash = EXCLUDED.token_hash,
+                                                  created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at`
+	if _, err := s.db.ExecContext(ctx, query, user_id, email, tokenHash, expiresAt); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to save email change to database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+	}).Info("email change saved to database")
+	return nil
+}
+
+// ConfirmEmailChange replaces the email of an active user with its pending new email if tokenHash matches
+// The pending change is consumed and the email swapped in one transaction, it returns the old and the new email
+// It fails with ErrUserExists when the new email was taken by another user meanwhile
+func (s *Storage) ConfirmEmailChange(ctx context.Context, user_id int64, tokenHash string) (string, string, error) {
+	const op = "storage.pgsql.ConfirmEmailChange"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var email string
+	query := `DELETE FROM email_changes WHERE user_id = $1 AND token_hash = $2 AND expires_at > NOW() RETURNING email`
+	if err := tx.QueryRowContext(ctx, query, user_id, tokenHash).Scan(&email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrEmailChangeNotFound)
+		}
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	var old string
+	query = `SELECT email FROM users WHERE id = $1 AND status = 'active' FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, user_id).Scan(&old); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	query = `UPDATE users SET email = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, user_id, email); err != nil {
+		if isUniqueViolation(err) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to confirm email change in database")
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+	}).Info("email changed in database")
+	return old, email, nil
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrExchangePolicyNotFound is returned when no token exchange policy matches
+	ErrExchangePolicyNotFound = errors.New("token exchange policy not found")
+	// ErrExchangePolicyExists is returned when a policy for the same client, subject app and audience already exists
+	ErrExchangePolicyExists = errors.New("token exchange policy already exists")
+)
+
+// SaveExchangePolicy creates a token exchange policy
+func (s *Storage) SaveExchangePolicy(ctx context.Context, policy *model.ExchangePolicy) (int64, error) {
+	const op = "storage.pgsql.SaveExchangePolicy"
+
+	var id int64
+	query := `INSERT INTO token_exchange_policies (client_id, subject_app_id, audience_app_id, scopes)
+              VALUES ($1, $2, $3, $4) RETURNING id`
+	err := s.db.QueryRowContext(ctx, query, policy.ClientId, policy.SubjectAppId, policy.AudienceAppId,
+		pq.Array(policy.Scopes)).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s: %w", op, ErrExchangePolicyExists)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"client_id": policy.ClientId,
+			"error":     err,
+		}).Error("failed to save token exchange policy to database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"client_id": policy.ClientId,
+		"policy_id": id,
+	}).Info("token exchange policy saved to database")
+	return id, nil
+}
+
+// ExchangePolicy returns the policy allowing client_id to exchange tokens of subject_app_id for audience_app_id
+func (s *Storage) ExchangePolicy(ctx context.Context, client_id string, subject_app_id int64, audience_app_id int64) (*model.ExchangePolicy, error) {
+	const op = "storage.pgsql.ExchangePolicy"
+
+	var policy model.ExchangePolicy
+	query := `SELECT id, client_id, subject_app_id, audience_app_id, scopes FROM token_exchange_policies
+              WHERE client_id = $1 AND subject_app_id = $2 AND audience_app_id = $3`
+	err := s.db.QueryRowContext(ctx, query, client_id, subject_app_id, audience_app_id).Scan(&policy.Id,
+		&policy.ClientId, &policy.SubjectAppId, &policy.AudienceAppId, pq.Array(&policy.Scopes))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%s: %w", op, ErrExchangePolicyNotFound)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"client_id": client_id,
+			"error":     err,
+		}).Error("failed to get token exchange policy from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &policy, nil
+}
+
+// ExchangePolicies returns the token exchange policies of a machine client
+func (s *Storage) ExchangePolicies(ctx context.Context, client_id string) ([]model.ExchangePolicy, error) {
+	const op = "storage.pgsql.ExchangePolicies"
+
+	query := `SELECT id, client_id, subject_app_id, audience_app_id, scopes FROM token_exchange_policies
+              WHERE client_id = $1 ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query, client_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"client_id": client_id,
+			"error":     err,
+		}).Error("failed to list token exchange policies from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	policies := []model.ExchangePolicy{}
+	for rows.Next() {
+		var p model.ExchangePolicy
+		if err := rows.Scan(&p.Id, &p.ClientId, &p.SubjectAppId, &p.AudienceAppId, pq.Array(&p.Scopes)); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return policies, nil
+}
+
+// DeleteExchangePolicy deletes a token exchange policy
+func (s *Storage) DeleteExchangePolicy(ctx context.Context, policy_id int64) error {
+	const op = "storage.pgsql.DeleteExchangePolicy"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM token_exchange_policies WHERE id = $1`, policy_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"policy_id": policy_id,
+			"error":     err,
+		}).Error("failed to delete token exchange policy from database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrExchangePolicyNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"policy_id": policy_id,
+	}).Info("token exchange policy deleted from database")
+	return nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SaveImpersonation records an impersonation session
+func (s *Storage) SaveImpersonation(ctx context.Context, impersonation *model.Impersonation) (int64, error) {
+	const op = "storage.pgsql.SaveImpersonation"
+
+	var id int64
+	query := `INSERT INTO impersonations (admin_id, user_id, app_id, reason, expires_at)
+              VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err := s.db.QueryRowContext(ctx, query, impersonation.AdminId, impersonation.UserId, impersonation.AppId,
+		impersonation.Reason, impersonation.ExpiresAt).Scan(&id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"admin_id":  impersonation.AdminId,
+			"user_id":   impersonation.UserId,
+			"error":     err,
+		}).Error("failed to save impersonation to database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":        op,
+		"admin_id":         impersonation.AdminId,
+		"user_id":          impersonation.UserId,
+		"impersonation_id": id,
+	}).Info("impersonation saved to database")
+	return id, nil
+}
+
+// Impersonations returns the impersonations of a user, newest first
+func (s *Storage) Impersonations(ctx context.Context, user_id int64) ([]model.Impersonation, error) {
+	const op = "storage.pgsql.Impersonations"
+
+	query := `SELECT id, admin_id, user_id, app_id, reason, created_at, expires_at FROM impersonations
+              WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := s.db.QueryContext(ctx, query, user_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to list impersonations from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	impersonations := []model.Impersonation{}
+	for rows.Next() {
+		var i model.Impersonation
+		if err := rows.Scan(&i.Id, &i.AdminId, &i.UserId, &i.AppId, &i.Reason, &i.CreatedAt, &i.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		impersonations = append(impersonations, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return impersonations, nil
+}
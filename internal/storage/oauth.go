@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrCodeNotFound is returned when an authorization code does not exist or was already used
+var ErrCodeNotFound = errors.New("authorization code not found")
+
+// SaveAuthorizationCode stores a new authorization code and drops expired ones
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error {
+	const op = "storage.pgsql.SaveAuthorizationCode"
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM authorization_codes WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Warn("failed to delete expired authorization codes")
+	}
+
+	query := `INSERT INTO authorization_codes (code_hash, app_id, user_id, redirect_uri, scopes, code_challenge, nonce, auth_time, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := s.db.ExecContext(ctx, query, code.CodeHash, code.AppId, code.UserId, code.RedirectURI,
+		pq.Array(code.Scopes), code.CodeChallenge, code.Nonce, code.AuthTime, code.ExpiresAt)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    code.AppId,
+			"user_id":   code.UserId,
+			"error":     err,
+		}).Error("failed to save authorization code to database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    code.AppId,
+		"user_id":   code.UserId,
+	}).Debug("authorization code saved to database")
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes and returns an authorization code, so every code can be used once
+// Expiry is checked by the caller
+func (s *Storage) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*model.AuthorizationCode, error) {
+	const op = "storage.pgsql.ConsumeAuthorizationCode"
+
+	var code model.AuthorizationCode
+	query := `DELETE FROM authorization_codes WHERE code_hash = $1
+              RETURNING code_hash, app_id, user_id, redirect_uri, scopes, code_challenge, nonce, auth_time, expires_at`
+	err := s.db.QueryRowContext(ctx, query, codeHash).Scan(&code.CodeHash, &code.AppId, &code.UserId,
+		&code.RedirectURI, pq.Array(&code.Scopes), &code.CodeChallenge, &code.Nonce, &code.AuthTime, &code.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.log.WithField("operation", op).Warn("authorization code not found in database")
+			return nil, fmt.Errorf("%s: %w", op, ErrCodeNotFound)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to consume authorization code from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    code.AppId,
+		"user_id":   code.UserId,
+	}).Debug("authorization code consumed from database")
+	return &code, nil
+}
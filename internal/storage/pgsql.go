@@ -3,21 +3,30 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"ssoq/internal/envelope"
 	"ssoq/internal/model"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
-	_ "github.com/lib/pq"
 )
 
+// ErrUserNotFound is returned when an operation targets a user that does not exist
+var ErrUserNotFound = errors.New("user not found")
+
 // Storage represents the PostgreSQL database storage implementation
 type Storage struct {
-	db  *sql.DB
-	log *logrus.Logger
+	db      *sql.DB
+	log     *logrus.Logger
+	secrets *envelope.Envelope
 }
 
 // NewDB creates a new instance of the PostgreSQL storage with the provided connection string
-func NewDB(connection string, log *logrus.Logger) (*Storage, error) {
+// secrets encrypts app secrets at rest, nil stores new secrets as plaintext
+func NewDB(connection string, log *logrus.Logger, secrets *envelope.Envelope) (*Storage, error) {
 	db, err := sql.Open("postgres", connection)
 	if err != nil {
 		return nil, fmt.Errorf("storage.NewDB: %w", err)
@@ -27,7 +36,7 @@ func NewDB(connection string, log *logrus.Logger) (*Storage, error) {
 		return nil, fmt.Errorf("storage.NewDB ping: %w", err)
 	}
 
-	return &Storage{db: db, log: log}, nil
+	return &Storage{db: db, log: log, secrets: secrets}, nil
 }
 
 // Close closes the database connection
@@ -36,12 +45,20 @@ func (s *Storage) Close() error {
 }
 
 // SaveUser saves a new user to the database
-func (s *Storage) SaveUser(ctx context.Context, email string, password string, username string, app_id int64) (int64, error) {
+// tenant_app_id is the namespace the email must be unique in, 0 for global identities
+func (s *Storage) SaveUser(ctx context.Context, tenant_app_id int64, email string, password string, pepperVersion int, username string, app_id int64) (int64, error) {
 	const op = "storage.pgsql.SaveUser"
 
 	var id int64
-	query := `INSERT INTO users (email, pass_hash, username, app_id) VALUES ($1, $2, $3, $4) RETURNING id`
-	err := s.db.QueryRowContext(ctx, query, email, password, username, app_id).Scan(&id)
+	// The user becomes a member of the app it registered in within the same statement
+	query := `WITH u AS (
+                  INSERT INTO users (email, pass_hash, pepper_version, username, app_id, tenant_app_id)
+                  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+              ), m AS (
+                  INSERT INTO user_apps (user_id, app_id) SELECT id, $5 FROM u
+              )
+              SELECT id FROM u`
+	err := s.db.QueryRowContext(ctx, query, email, password, pepperVersion, username, app_id, tenant_app_id).Scan(&id)
 	if err != nil {
 		s.log.WithFields(logrus.Fields{
 			"operation": op,
@@ -61,14 +78,42 @@ func (s *Storage) SaveUser(ctx context.Context, email string, password string, u
 	return id, nil
 }
 
-// GetUser returns a user by email
-func (s *Storage) GetUser(ctx context.Context, email string) (*model.User, error) {
-	const op = "storage.pgsql.GetUser"
+// userColumns lists the users columns in the order expected by scanUser
+const userColumns = `id, email, pass_hash, pepper_version, username, app_id, tenant_app_id, failed_attempts, last_failed_at, locked_until, token_version, created_at, status, status_reason, status_changed_at, email_released_at, display_name, locale, timezone, metadata, updated_at`
 
+// scanUser scans a single users row selected with userColumns
+func scanUser(scan func(dest ...interface{}) error) (*model.User, error) {
 	var user model.User
 	var passHash string
-	query := `SELECT id, email, pass_hash, username, app_id FROM users WHERE email = $1`
-	err := s.db.QueryRowContext(ctx, query, email).Scan(&user.Id, &user.Email, &passHash, &user.Username, &user.AppId)
+	var lastFailedAt, lockedUntil, createdAt, statusChangedAt, emailReleasedAt, updatedAt sql.NullTime
+	var metadata []byte
+	err := scan(&user.Id, &user.Email, &passHash, &user.PepperVersion, &user.Username, &user.AppId, &user.TenantAppId,
+		&user.FailedAttempts, &lastFailedAt, &lockedUntil, &user.TokenVersion, &createdAt,
+		&user.Status, &user.StatusReason, &statusChangedAt, &emailReleasedAt,
+		&user.DisplayName, &user.Locale, &user.Timezone, &metadata, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = []byte(passHash)
+	user.LastFailedAt = lastFailedAt.Time
+	user.LockedUntil = lockedUntil.Time
+	user.CreatedAt = createdAt.Time
+	user.StatusChangedAt = statusChangedAt.Time
+	user.EmailReleasedAt = emailReleasedAt.Time
+	user.UpdatedAt = updatedAt.Time
+	if err := json.Unmarshal(metadata, &user.Metadata); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUser returns a user by email within the namespace tenant_app_id, 0 for global identities
+// Deleted users are returned until their email is released
+func (s *Storage) GetUser(ctx context.Context, tenant_app_id int64, email string) (*model.User, error) {
+	const op = "storage.pgsql.GetUser"
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE tenant_app_id = $1 AND email = $2 AND email_released_at IS NULL`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, tenant_app_id, email).Scan)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			s.log.WithFields(logrus.Fields{
@@ -85,30 +130,204 @@ func (s *Storage) GetUser(ctx context.Context, email string) (*model.User, error
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	user.Password = []byte(passHash)
-
 	s.log.WithFields(logrus.Fields{
 		"operation": op,
 		"user_id":   user.Id,
 		"email":     email,
 	}).Debug("user retrieved from database")
-	return &user, nil
+	return user, nil
+}
+
+// UpdatePassword replaces the password hash of a user together with its pepper version
+func (s *Storage) UpdatePassword(ctx context.Context, user_id int64, password string, pepperVersion int) error {
+	const op = "storage.pgsql.UpdatePassword"
+
+	query := `UPDATE users SET pass_hash = $1, pepper_version = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3`
+	_, err := s.db.ExecContext(ctx, query, password, pepperVersion, user_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to update password in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":      op,
+		"user_id":        user_id,
+		"pepper_version": pepperVersion,
+	}).Debug("password updated in database")
+	return nil
+}
+
+// RegisterFailedLogin increments the failed login counter of a user
+// Once the counter reaches threshold the account is locked for lockFor, a counter left over
+// from an expired lock starts over. It returns the new counter and the lock expiry (zero if not locked)
+func (s *Storage) RegisterFailedLogin(ctx context.Context, user_id int64, threshold int, lockFor time.Duration) (int, time.Time, error) {
+	const op = "storage.pgsql.RegisterFailedLogin"
+
+	query := `UPDATE users u SET
+                  failed_attempts = n.attempts,
+                  last_failed_at = CURRENT_TIMESTAMP,
+                  locked_until = CASE WHEN $2 > 0 AND n.attempts >= $2
+                                      THEN CURRENT_TIMESTAMP + make_interval(secs => $3)
+                                      ELSE NULL END
+              FROM (SELECT id, CASE WHEN locked_until IS NOT NULL AND locked_until <= CURRENT_TIMESTAMP
+                                    THEN 1 ELSE failed_attempts + 1 END AS attempts
+                    FROM users WHERE id = $1 FOR UPDATE) n
+              WHERE u.id = n.id
+              RETURNING u.failed_attempts, u.locked_until`
+
+	var attempts int
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRowContext(ctx, query, user_id, threshold, lockFor.Seconds()).Scan(&attempts, &lockedUntil)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to register failed login in database")
+		return 0, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":       op,
+		"user_id":         user_id,
+		"failed_attempts": attempts,
+	}).Debug("failed login registered in database")
+	return attempts, lockedUntil.Time, nil
+}
+
+// ResetFailedLogins clears the failed login counter and any lock of a user
+func (s *Storage) ResetFailedLogins(ctx context.Context, user_id int64) error {
+	const op = "storage.pgsql.ResetFailedLogins"
+
+	query := `UPDATE users SET failed_attempts = 0, last_failed_at = NULL, locked_until = NULL WHERE id = $1`
+	res, err := s.db.ExecContext(ctx, query, user_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to reset failed logins in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+	}).Debug("failed logins reset in database")
+	return nil
+}
+
+// AddUserApp grants a user access to an app, granting an existing membership is a no-op
+func (s *Storage) AddUserApp(ctx context.Context, user_id int64, app_id int64) error {
+	const op = "storage.pgsql.AddUserApp"
+
+	query := `INSERT INTO user_apps (user_id, app_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := s.db.ExecContext(ctx, query, user_id, app_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to add user app membership to database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+		"app_id":    app_id,
+	}).Debug("user app membership added to database")
+	return nil
+}
+
+// RemoveUserApp revokes a user's access to an app
+func (s *Storage) RemoveUserApp(ctx context.Context, user_id int64, app_id int64) error {
+	const op = "storage.pgsql.RemoveUserApp"
+
+	query := `DELETE FROM user_apps WHERE user_id = $1 AND app_id = $2`
+	_, err := s.db.ExecContext(ctx, query, user_id, app_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to remove user app membership from database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+		"app_id":    app_id,
+	}).Debug("user app membership removed from database")
+	return nil
+}
+
+// HasUserApp reports whether a user is a member of an app
+func (s *Storage) HasUserApp(ctx context.Context, user_id int64, app_id int64) (bool, error) {
+	const op = "storage.pgsql.HasUserApp"
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM user_apps WHERE user_id = $1 AND app_id = $2)`
+	err := s.db.QueryRowContext(ctx, query, user_id, app_id).Scan(&exists)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to check user app membership in database")
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return exists, nil
+}
+
+// appColumns lists the apps columns in the order expected by scanApp
+const appColumns = `id, name, secret, scopes, redirect_uris, backchannel_logout_uri, frontchannel_logout_uri, owner, grant_types, created_at, disabled_at, previous_secret, previous_secret_expires_at, attribute_schema`
+
+// scanApp scans a single apps row selected with appColumns and decrypts its secrets
+func (s *Storage) scanApp(scan func(dest ...interface{}) error) (*model.App, error) {
+	var app model.App
+	var disabledAt, previousSecretExpiresAt sql.NullTime
+	err := scan(&app.Id, &app.Name, &app.Secret, pq.Array(&app.Scopes), pq.Array(&app.RedirectURIs),
+		&app.BackchannelLogoutURI, &app.FrontchannelLogoutURI, &app.Owner, pq.Array(&app.GrantTypes), &app.CreatedAt, &disabledAt,
+		&app.PreviousSecret, &previousSecretExpiresAt, &app.AttributeSchema)
+	if err != nil {
+		return nil, err
+	}
+	app.DisabledAt = disabledAt.Time
+	app.PreviousSecretExpiresAt = previousSecretExpiresAt.Time
+	if app.Secret, err = s.secrets.Open(app.Secret); err != nil {
+		return nil, err
+	}
+	if app.PreviousSecret, err = s.secrets.Open(app.PreviousSecret); err != nil {
+		return nil, err
+	}
+	return &app, nil
 }
 
 // App returns an app by id
 func (s *Storage) App(ctx context.Context, app_id int64) (*model.App, error) {
 	const op = "storage.pgsql.App"
 
-	var app model.App
-	query := `SELECT id, name, secret FROM apps WHERE id = $1`
-	err := s.db.QueryRowContext(ctx, query, app_id).Scan(&app.Id, &app.Name, &app.Secret)
+	query := `SELECT ` + appColumns + ` FROM apps WHERE id = $1`
+	app, err := s.scanApp(s.db.QueryRowContext(ctx, query, app_id).Scan)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			s.log.WithFields(logrus.Fields{
 				"operation": op,
 				"app_id":    app_id,
 			}).Warn("app not found in database")
-			return nil, fmt.Errorf("%s: app not found", op)
+			return nil, fmt.Errorf("%s: %w", op, ErrAppNotFound)
 		}
 		s.log.WithFields(logrus.Fields{
 			"operation": op,
@@ -123,35 +342,41 @@ func (s *Storage) App(ctx context.Context, app_id int64) (*model.App, error) {
 		"app_id":    app.Id,
 		"app_name":  app.Name,
 	}).Debug("app retrieved from database")
-	return &app, nil
+	return app, nil
 }
 
-// SaveToken saves a refresh token for a user
-// It updates the token if a session already exists for the user
-func (s *Storage) SaveToken(ctx context.Context, user_id int64, token string) error {
-	const op = "storage.pgsql.SaveToken"
+// UserApps returns the apps the user is a member of
+func (s *Storage) UserApps(ctx context.Context, user_id int64) ([]model.App, error) {
+	const op = "storage.pgsql.UserApps"
 
-	query := `INSERT INTO sessions (user_id, refresh_token) VALUES ($1, $2)
-              ON CONFLICT (user_id) DO UPDATE SET refresh_token = EXCLUDED.refresh_token`
-
-	_, err := s.db.ExecContext(ctx, query, user_id, token)
+	query := `SELECT ` + appColumns + ` FROM apps
+              WHERE id IN (SELECT app_id FROM user_apps WHERE user_id = $1) ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query, user_id)
 	if err != nil {
 		s.log.WithFields(logrus.Fields{
 			"operation": op,
 			"user_id":   user_id,
 			"error":     err,
-		}).Error("failed to save token to database")
-		return fmt.Errorf("%s: %w", op, err)
+		}).Error("failed to list user apps from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	defer rows.Close()
 
-	s.log.WithFields(logrus.Fields{
-		"operation": op,
-		"user_id":   user_id,
-	}).Debug("token saved to database")
-	return nil
+	apps := []model.App{}
+	for rows.Next() {
+		app, err := s.scanApp(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		apps = append(apps, *app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return apps, nil
 }
 
-// DeleteToken deletes a refresh token for a user (logout)
+// DeleteToken deletes every session of a user (logout)
 func (s *Storage) DeleteToken(ctx context.Context, user_id int64) error {
 	const op = "storage.pgsql.DeleteToken"
 
@@ -174,44 +399,41 @@ func (s *Storage) DeleteToken(ctx context.Context, user_id int64) error {
 	return nil
 }
 
-// GetToken returns the refresh token for a user
-func (s *Storage) GetToken(ctx context.Context, user_id int64) (string, error) {
-	const op = "storage.pgsql.GetToken"
+// RevokeUserSessions deletes the sessions of a user and bumps its token version in one statement,
+// so access and refresh tokens issued before are rejected, and returns the new version
+func (s *Storage) RevokeUserSessions(ctx context.Context, user_id int64) (int, error) {
+	const op = "storage.pgsql.RevokeUserSessions"
 
-	var token string
-	query := `SELECT refresh_token FROM sessions WHERE user_id = $1`
-	err := s.db.QueryRowContext(ctx, query, user_id).Scan(&token)
+	query := `WITH deleted AS (DELETE FROM sessions WHERE user_id = $1)
+              UPDATE users SET token_version = token_version + 1 WHERE id = $1 RETURNING token_version`
+	var version int
+	err := s.db.QueryRowContext(ctx, query, user_id).Scan(&version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			s.log.WithFields(logrus.Fields{
-				"operation": op,
-				"user_id":   user_id,
-			}).Warn("token not found in database")
-			return "", nil // Or return a custom error
+			return 0, fmt.Errorf("%s: %w", op, ErrUserNotFound)
 		}
 		s.log.WithFields(logrus.Fields{
 			"operation": op,
 			"user_id":   user_id,
 			"error":     err,
-		}).Error("failed to get token from database")
-		return "", fmt.Errorf("%s: %w", op, err)
+		}).Error("failed to revoke user sessions in database")
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
 	s.log.WithFields(logrus.Fields{
-		"operation": op,
-		"user_id":   user_id,
-	}).Debug("token retrieved from database")
-	return token, nil
+		"operation":     op,
+		"user_id":       user_id,
+		"token_version": version,
+	}).Info("user sessions revoked in database")
+	return version, nil
 }
 
 // GetUserByID returns a user by their ID
 func (s *Storage) GetUserByID(ctx context.Context, id int64) (*model.User, error) {
 	const op = "storage.pgsql.GetUserByID"
 
-	var user model.User
-	var passHash string
-	query := `SELECT id, email, pass_hash, username, app_id FROM users WHERE id = $1`
-	err := s.db.QueryRowContext(ctx, query, id).Scan(&user.Id, &user.Email, &passHash, &user.Username, &user.AppId)
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, id).Scan)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			s.log.WithFields(logrus.Fields{
@@ -228,11 +450,49 @@ func (s *Storage) GetUserByID(ctx context.Context, id int64) (*model.User, error
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	user.Password = []byte(passHash)
-
 	s.log.WithFields(logrus.Fields{
 		"operation": op,
 		"user_id":   user.Id,
 	}).Debug("user retrieved from database by ID")
-	return &user, nil
+	return user, nil
+}
+
+// ratePurgeBatch bounds how many refilled buckets a single TakeRateToken deletes
+const ratePurgeBatch = 10
+
+// TakeRateToken takes one token from the shared bucket identified by key
+// The bucket refills at rate tokens per second up to burst, it returns false when the bucket is empty
+// Buckets that have refilled completely are deleted on the way, a new bucket starts full anyway
+func (s *Storage) TakeRateToken(ctx context.Context, key string, rate float64, burst int) (bool, error) {
+	const op = "storage.pgsql.TakeRateToken"
+
+	query := `WITH purged AS (DELETE FROM rate_limits WHERE key IN (SELECT key FROM rate_limits
+                  WHERE full_at < CURRENT_TIMESTAMP AND key <> $1 LIMIT $4 FOR UPDATE SKIP LOCKED))
+              INSERT INTO rate_limits (key, tokens, updated_at, full_at)
+              VALUES ($1, $3::float8 - 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + make_interval(secs => 1 / $2::float8))
+              ON CONFLICT (key) DO UPDATE SET
+                  tokens = LEAST($3::float8, rate_limits.tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - rate_limits.updated_at)) * $2::float8) - 1,
+                  updated_at = CURRENT_TIMESTAMP,
+                  full_at = CURRENT_TIMESTAMP + make_interval(secs => ($3::float8 + 1 - LEAST($3::float8, rate_limits.tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - rate_limits.updated_at)) * $2::float8)) / $2::float8)
+              WHERE LEAST($3::float8, rate_limits.tokens + EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - rate_limits.updated_at)) * $2::float8) >= 1
+              RETURNING tokens`
+
+	var tokens float64
+	err := s.db.QueryRowContext(ctx, query, key, rate, burst, ratePurgeBatch).Scan(&tokens)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.log.WithFields(logrus.Fields{
+				"operation": op,
+				"key":       key,
+			}).Debug("rate limit bucket is empty")
+			return false, nil
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"key":       key,
+			"error":     err,
+		}).Error("failed to take rate limit token from database")
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return true, nil
 }
@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrRoleNotFound is returned when an operation targets a role that does not exist
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrRoleExists is returned when a role with the same name already exists in the app
+	ErrRoleExists = errors.New("role already exists")
+	// ErrPermissionNotFound is returned when an operation references a permission that does not exist
+	ErrPermissionNotFound = errors.New("permission not found")
+	// ErrPermissionExists is returned when a permission with the same name already exists in the app
+	ErrPermissionExists = errors.New("permission already exists")
+)
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// SaveRole creates a role in an app
+func (s *Storage) SaveRole(ctx context.Context, app_id int64, name string, description string) (int64, error) {
+	const op = "storage.pgsql.SaveRole"
+
+	var id int64
+	query := `INSERT INTO roles (app_id, name, description) VALUES ($1, $2, $3) RETURNING id`
+	err := s.db.QueryRowContext(ctx, query, app_id, name, description).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s: %w", op, ErrRoleExists)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    app_id,
+			"role":      name,
+			"error":     err,
+		}).Error("failed to save role to database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"app_id":    app_id,
+		"role_id":   id,
+	}).Info("role saved to database")
+	return id, nil
+}
+
+// DeleteRole deletes a role together with its assignments
+func (s *Storage) DeleteRole(ctx context.Context, role_id int64) error {
+	const op = "storage.pgsql.DeleteRole"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM roles WHERE id = $1`, role_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"role_id":   role_id,
+			"error":     err,
+		}).Error("failed to delete role from database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrRoleNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"role_id":   role_id,
+	}).Info("role deleted from database")
+	return nil
+}
+
+// roleQuery selects roles with the names of their permissions, the caller appends the WHERE clause
+const roleQuery = `SELECT r.id, r.app_id, r.name, r.description,
+                          COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+                   FROM roles r
+                   LEFT JOIN role_permissions rp ON rp.role_id = r.id
+                   LEFT JOIN permissions p ON p.id = rp.permission_id `
+
+// queryRoles runs a roleQuery based query and scans the resulting roles
+func (s *Storage) queryRoles(ctx context.Context, query string, args ...interface{}) ([]model.Role, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []model.Role
+	for rows.Next() {
+		var role model.Role
+		if err := rows.Scan(&role.Id, &role.AppId, &role.Name, &role.Description, pq.Array(&role.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// Role returns a role by id
+func (s *Storage) Role(ctx context.Context, role_id int64) (*model.Role, error) {
+	const op = "storage.pgsql.Role"
+
+	roles, err := s.queryRoles(ctx, roleQuery+`WHERE r.id = $1 GROUP BY r.id`, role_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"role_id":   role_id,
+			"error":     err,
+		}).Error("failed to get role from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrRoleNotFound)
+	}
+	return &roles[0], nil
+}
+
+// Roles returns all roles of an app
+func (s *Storage) Roles(ctx context.Context, app_id int64) ([]model.Role, error) {
+	const op = "storage.pgsql.Roles"
+
+	roles, err := s.queryRoles(ctx, roleQuery+`WHERE r.app_id = $1 GROUP BY r.id ORDER BY r.name`, app_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to get roles from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return roles, nil
+}
+
+// UserRoles returns the roles assigned to a user within an app
+func (s *Storage) UserRoles(ctx context.Context, user_id int64, app_id int64) ([]model.Role, error) {
+	const op = "storage.pgsql.UserRoles"
+
+	roles, err := s.queryRoles(ctx, roleQuery+`JOIN user_roles ur ON ur.role_id = r.id
+                                                WHERE ur.user_id = $1 AND r.app_id = $2
+                                                GROUP BY r.id ORDER BY r.name`, user_id, app_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to get user roles from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return roles, nil
+}
+
+// SavePermission creates a permission in an app
+func (s *Storage) SavePermission(ctx context.Context, app_id int64, name string, description string) (int64, error) {
+	const op = "storage.pgsql.SavePermission"
+
+	var id int64
+	query := `INSERT INTO permissions (app_id, name, description) VALUES ($1, $2, $3) RETURNING id`
+	err := s.db.QueryRowContext(ctx, query, app_id, name, description).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s: %w", op, ErrPermissionExists)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation":  op,
+			"app_id":     app_id,
+			"permission": name,
+			"error":      err,
+		}).Error("failed to save permission to database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":     op,
+		"app_id":        app_id,
+		"permission_id": id,
+	}).Info("permission saved to database")
+	return id, nil
+}
+
+// DeletePermission deletes a permission and removes it from every role
+func (s *Storage) DeletePermission(ctx context.Context, permission_id int64) error {
+	const op = "storage.pgsql.DeletePermission"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM permissions WHERE id = $1`, permission_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation":     op,
+			"permission_id": permission_id,
+			"error":         err,
+		}).Error("failed to delete permission from database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrPermissionNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":     op,
+		"permission_id": permission_id,
+	}).Info("permission deleted from database")
+	return nil
+}
+
+// Permissions returns all permissions of an app
+func (s *Storage) Permissions(ctx context.Context, app_id int64) ([]model.Permission, error) {
+	const op = "storage.pgsql.Permissions"
+
+	query := `SELECT id, app_id, name, description FROM permissions WHERE app_id = $1 ORDER BY name`
+	rows, err := s.db.QueryContext(ctx, query, app_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"app_id":    app_id,
+			"error":     err,
+		}).Error("failed to get permissions from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var permissions []model.Permission
+	for rows.Next() {
+		var p model.Permission
+		if err := rows.Scan(&p.Id, &p.AppId, &p.Name, &p.Description); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		permissions = append(permissions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return permissions, nil
+}
+
+// SetRolePermissions replaces the permissions of a role with the named permissions of the role's app
+func (s *Storage) SetRolePermissions(ctx context.Context, role_id int64, permissions []string) error {
+	const op = "storage.pgsql.SetRolePermissions"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var app_id int64
+	err = tx.QueryRowContext(ctx, `SELECT app_id FROM roles WHERE id = $1 FOR UPDATE`, role_id).Scan(&app_id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%s: %w", op, ErrRoleNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, role_id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO role_permissions (role_id, permission_id)
+                                     SELECT $1, id FROM permissions WHERE app_id = $2 AND name = ANY($3)`,
+		role_id, app_id, pq.Array(permissions))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); int(n) != len(unique(permissions)) {
+		return fmt.Errorf("%s: %w", op, ErrPermissionNotFound)
+	}
+	if err := tx.Commit(); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"role_id":   role_id,
+			"error":     err,
+		}).Error("failed to set role permissions in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":   op,
+		"role_id":     role_id,
+		"permissions": permissions,
+	}).Info("role permissions set in database")
+	return nil
+}
+
+// AddUserRole assigns a role to a user, assigning it twice is a no-op
+func (s *Storage) AddUserRole(ctx context.Context, user_id int64, role_id int64) error {
+	const op = "storage.pgsql.AddUserRole"
+
+	query := `INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, query, user_id, role_id); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"role_id":   role_id,
+			"error":     err,
+		}).Error("failed to add user role to database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+		"role_id":   role_id,
+	}).Info("user role added to database")
+	return nil
+}
+
+// RemoveUserRole removes a role from a user
+func (s *Storage) RemoveUserRole(ctx context.Context, user_id int64, role_id int64) error {
+	const op = "storage.pgsql.RemoveUserRole"
+
+	query := `DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2`
+	if _, err := s.db.ExecContext(ctx, query, user_id, role_id); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"role_id":   role_id,
+			"error":     err,
+		}).Error("failed to remove user role from database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+		"role_id":   role_id,
+	}).Info("user role removed from database")
+	return nil
+}
+
+// UserAuthorization returns the role names and the union of their permissions of a user within an app
+func (s *Storage) UserAuthorization(ctx context.Context, user_id int64, app_id int64) ([]string, []string, error) {
+	const op = "storage.pgsql.UserAuthorization"
+
+	roles, err := s.UserRoles(ctx, user_id, app_id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	names := make([]string, 0, len(roles))
+	var permissions []string
+	for _, role := range roles {
+		names = append(names, role.Name)
+		permissions = append(permissions, role.Permissions...)
+	}
+	return names, unique(permissions), nil
+}
+
+// unique returns the distinct values of xs keeping their first occurrence order
+func unique(xs []string) []string {
+	seen := make(map[string]struct{}, len(xs))
+	res := make([]string, 0, len(xs))
+	for _, x := range xs {
+		if _, ok := seen[x]; ok {
+			continue
+		}
+		seen[x] = struct{}{}
+		res = append(res, x)
+	}
+	return res
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSessionNotFound is returned when a session does not exist, was revoked or its refresh token was already rotated
+var ErrSessionNotFound = errors.New("session not found")
+
+// NewSessionID reserves the id of a session, so tokens carrying it can be signed before the session is saved
+func (s *Storage) NewSessionID(ctx context.Context) (int64, error) {
+	const op = "storage.pgsql.NewSessionID"
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `SELECT nextval(pg_get_serial_sequence('sessions', 'id'))`).Scan(&id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to reserve session id")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+// SaveSession saves a new session with an id reserved by NewSessionID
+// Sessions of the user unused for longer than the lifetime of refresh tokens are removed on the way
+func (s *Storage) SaveSession(ctx context.Context, session *model.Session) error {
+	const op = "storage.pgsql.SaveSession"
+
+	query := `WITH purged AS (DELETE FROM sessions WHERE user_id = $2 AND last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 day')
+              INSERT INTO sessions (id, user_id, app_id, refresh_token, user_agent, ip) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := s.db.ExecContext(ctx, query, session.Id, session.UserId, session.AppId, session.RefreshToken,
+		session.UserAgent, session.IP)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation":  op,
+			"user_id":    session.UserId,
+			"session_id": session.Id,
+			"error":      err,
+		}).Error("failed to save session to database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":  op,
+		"user_id":    session.UserId,
+		"session_id": session.Id,
+	}).Debug("session saved to database")
+	return nil
+}
+
+// RotateSessionToken replaces the refresh token of a session and marks it as used
+// It fails with ErrSessionNotFound unless oldToken is the current token, so a refresh token is accepted only once
+func (s *Storage) RotateSessionToken(ctx context.Context, session_id int64, oldToken string, newToken string) error {
+	const op = "storage.pgsql.RotateSessionToken"
+
+	query := `UPDATE sessions SET refresh_token = $3, last_used_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND refresh_token = $2`
+	res, err := s.db.ExecContext(ctx, query, session_id, oldToken, newToken)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation":  op,
+			"session_id": session_id,
+			"error":      err,
+		}).Error("failed to rotate session token in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+	}
+	return nil
+}
+
+// SessionIDByToken returns the session of a refresh token issued before tokens carried their session id
+func (s *Storage) SessionIDByToken(ctx context.Context, user_id int64, token string) (int64, error) {
+	const op = "storage.pgsql.SessionIDByToken"
+
+	var id int64
+	query := `SELECT id FROM sessions WHERE user_id = $1 AND refresh_token = $2`
+	err := s.db.QueryRowContext(ctx, query, user_id, token).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to get session from database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+// Sessions returns the live sessions of a user, most recently used first
+func (s *Storage) Sessions(ctx context.Context, user_id int64) ([]model.Session, error) {
+	const op = "storage.pgsql.Sessions"
+
+	query := `SELECT id, user_id, app_id, user_agent, ip, created_at, last_used_at FROM sessions
+              WHERE user_id = $1 AND last_used_at >= CURRENT_TIMESTAMP - INTERVAL '1 day'
+              ORDER BY last_used_at DESC, id DESC`
+	rows, err := s.db.QueryContext(ctx, query, user_id)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to list sessions from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	sessions := []model.Session{}
+	for rows.Next() {
+		var session model.Session
+		var app_id sql.NullInt64
+		err := rows.Scan(&session.Id, &session.UserId, &app_id, &session.UserAgent, &session.IP,
+			&session.CreatedAt, &session.LastUsedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		session.AppId = app_id.Int64
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return sessions, nil
+}
+
+// SessionExists reports whether a session of a user is still live, revoked and expired sessions are not
+func (s *Storage) SessionExists(ctx context.Context, user_id int64, session_id int64) (bool, error) {
+	const op = "storage.pgsql.SessionExists"
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND user_id = $2
+              AND last_used_at >= CURRENT_TIMESTAMP - INTERVAL '1 day')`
+	if err := s.db.QueryRowContext(ctx, query, session_id, user_id).Scan(&exists); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation":  op,
+			"user_id":    user_id,
+			"session_id": session_id,
+			"error":      err,
+		}).Error("failed to check session in database")
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return exists, nil
+}
+
+// DeleteSession deletes a single session of a user and returns the app it was opened in, 0 if unknown
+func (s *Storage) DeleteSession(ctx context.Context, user_id int64, session_id int64) (int64, error) {
+	const op = "storage.pgsql.DeleteSession"
+
+	var app_id sql.NullInt64
+	query := `DELETE FROM sessions WHERE id = $1 AND user_id = $2 RETURNING app_id`
+	err := s.db.QueryRowContext(ctx, query, session_id, user_id).Scan(&app_id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: %w", op, ErrSessionNotFound)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation":  op,
+			"user_id":    user_id,
+			"session_id": session_id,
+			"error":      err,
+		}).Error("failed to delete session from database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation":  op,
+		"user_id":    user_id,
+		"session_id": session_id,
+	}).Info("session deleted from database")
+	return app_id.Int64, nil
+}
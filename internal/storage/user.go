@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ssoq/internal/model"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrUserExists is returned when another user of the same namespace already has the email
+	ErrUserExists = errors.New("user already exists")
+	// ErrUserChanged is returned when a user was updated since the version an update is based on
+	ErrUserChanged = errors.New("user was changed concurrently")
+)
+
+// Users returns up to limit users with an id greater than cursor, ordered by id, that match filter
+func (s *Storage) Users(ctx context.Context, filter model.UserFilter, cursor int64, limit int) ([]model.User, error) {
+	const op = "storage.pgsql.Users"
+
+	query := `SELECT ` + userColumns + ` FROM users
+              WHERE id > $1
+                AND ($2 = '' OR strpos(lower(email), lower($2)) > 0)
+                AND ($3::BIGINT = 0 OR id IN (SELECT user_id FROM user_apps WHERE app_id = $3))
+                AND ($4 = '' OR status = $4)
+              ORDER BY id LIMIT $5`
+	rows, err := s.db.QueryContext(ctx, query, cursor, filter.Email, filter.AppId, filter.Status, limit)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to list users from database")
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		user, err := scanUser(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return users, nil
+}
+
+// UpdateUser replaces the email and username of a user, deleted users cannot be changed
+func (s *Storage) UpdateUser(ctx context.Context, user_id int64, email string, username string) error {
+	const op = "storage.pgsql.UpdateUser"
+
+	query := `UPDATE users SET email = $2, username = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND status <> 'deleted'`
+	res, err := s.db.ExecContext(ctx, query, user_id, email, username)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"error":     err,
+		}).Error("failed to update user in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+	}).Info("user updated in database")
+	return nil
+}
+
+// SetUserStatus changes the status of a user and records the reason, deleted users cannot be changed
+// Setting the current status again only replaces the reason, the time of the change is kept
+func (s *Storage) SetUserStatus(ctx context.Context, user_id int64, status string, reason string) error {
+	const op = "storage.pgsql.SetUserStatus"
+
+	query := `UPDATE users SET status = $2, status_reason = $3,
+                  status_changed_at = CASE WHEN status = $2 THEN status_changed_at ELSE CURRENT_TIMESTAMP END,
+                  updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND status <> 'deleted'`
+	res, err := s.db.ExecContext(ctx, query, user_id, status, reason)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user_id,
+			"status":    status,
+			"error":     err,
+		}).Error("failed to update user status in database")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user_id,
+		"status":    status,
+	}).Info("user status updated in database")
+	return nil
+}
+
+// ReleaseDeletedEmails releases the emails of users deleted more than retention ago so they can be registered again
+// The rows are kept for audit, it returns the number of released emails
+func (s *Storage) ReleaseDeletedEmails(ctx context.Context, retention time.Duration) (int64, error) {
+	const op = "storage.pgsql.ReleaseDeletedEmails"
+
+	query := `UPDATE users SET email_released_at = CURRENT_TIMESTAMP
+              WHERE status = 'deleted' AND email_released_at IS NULL
+                AND status_changed_at <= CURRENT_TIMESTAMP - make_interval(secs => $1)`
+	res, err := s.db.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"error":     err,
+		}).Error("failed to release emails of deleted users in database")
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n > 0 {
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"users":     n,
+		}).Info("emails of deleted users released in database")
+	}
+	return n, nil
+}
+
+// UpdateProfile replaces the profile of a user if it was not changed since version, its updated_at
+// It returns the new version, ErrUserChanged when the user was updated meanwhile
+func (s *Storage) UpdateProfile(ctx context.Context, user *model.User, version time.Time) (time.Time, error) {
+	const op = "storage.pgsql.UpdateProfile"
+
+	metadata, err := json.Marshal(user.Metadata)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if user.Metadata == nil {
+		metadata = []byte("{}")
+	}
+	// Versions are compared in microseconds, the precision of TIMESTAMP
+	query := `UPDATE users SET username = $2, display_name = $3, locale = $4, timezone = $5, metadata = $6,
+                  updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND status <> 'deleted'
+                AND (extract(epoch FROM updated_at) * 1000000)::BIGINT = $7
+              RETURNING updated_at`
+	var updatedAt time.Time
+	err = s.db.QueryRowContext(ctx, query, user.Id, user.Username, user.DisplayName, user.Locale, user.Timezone,
+		metadata, version.UnixMicro()).Scan(&updatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.log.WithFields(logrus.Fields{
+				"operation": op,
+				"user_id":   user.Id,
+			}).Warn("profile changed concurrently")
+			return time.Time{}, fmt.Errorf("%s: %w", op, ErrUserChanged)
+		}
+		s.log.WithFields(logrus.Fields{
+			"operation": op,
+			"user_id":   user.Id,
+			"error":     err,
+		}).Error("failed to update profile in database")
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"operation": op,
+		"user_id":   user.Id,
+	}).Info("profile updated in database")
+	return updatedAt, nil
+}